@@ -3,12 +3,20 @@ package main
 import (
 	"context"
 	"errors"
+	"omhs-backend/internal/account"
+	"omhs-backend/internal/admin"
 	"omhs-backend/internal/auth"
 	"omhs-backend/internal/kanban"
+	"omhs-backend/internal/mail"
 	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/oidc"
+	"omhs-backend/internal/password"
 	"omhs-backend/internal/requests"
+	"omhs-backend/internal/signing"
 	"omhs-backend/internal/utils"
+	"omhs-backend/internal/webauthn"
 	"os"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -37,7 +45,7 @@ func main() {
 
 	r := gin.Default()
 	setupCORS(r, pm)
-	initRoutes(r, client)
+	initRoutes(r, client, pm)
 
 	pm.Execute(func() error { return r.Run(":8080") }, "Failed to run server")
 }
@@ -64,36 +72,134 @@ func setupCORS(r *gin.Engine, pm *utils.ProjectManager) {
 		AllowOrigins: []string{
 			"http://localhost:3000",
 			"http://localhost:4200"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", middleware.ImpersonationHeader},
 		AllowCredentials: true,
 	}))
 }
 
-func initRoutes(r *gin.Engine, client *mongo.Client) {
+func initRoutes(r *gin.Engine, client *mongo.Client, pm *utils.ProjectManager) {
 	api := r.Group("/api")
 
+	// --- Mail ---
+	// Services only enqueue; the worker delivers through the configured driver.
+	outboxRepo := mail.NewMongoOutboxRepository(client)
+	pm.Execute(outboxRepo.EnsureIndexes, "Failed to create outbox indexes")
+	outbox := mail.NewOutbox(outboxRepo)
+
+	var mailer mail.Mailer
+	pm.Execute(func() error {
+		var err error
+		mailer, err = mail.FromEnv()
+		return err
+	}, "Fatal: invalid mail configuration")
+	go mail.NewWorker(outboxRepo, mailer, mail.DefaultWorkerConfig()).Run(context.Background())
+
+	// --- Signing Keys ---
+	// Every instance shares the keyring in MongoDB; Run keeps it rotated and
+	// picks up keys published by the others.
+	keyRepo := signing.NewMongoKeyRepository(client)
+	pm.Execute(keyRepo.EnsureIndexes, "Failed to create signing key indexes")
+	var signingConfig signing.Config
+	pm.Execute(func() error {
+		var err error
+		signingConfig, err = signing.ConfigFromEnv()
+		return err
+	}, "Fatal: invalid JWT configuration")
+	keyring := signing.NewKeyring(keyRepo, signingConfig)
+	pm.Execute(func() error { return keyring.Rotate(time.Now()) }, "Fatal: failed to load signing keys")
+	utils.SetTokenKeys(keyring)
+	go keyring.Run(context.Background())
+	signing.RegisterRoutes(r, signing.NewJWKSController(keyring))
+
 	// --- Auth Module ---
 	authRepo := auth.NewMongoUserRepository(client)
-	authService := auth.NewAuthService(authRepo)
+	tokenRepo := auth.NewMongoTokenRepository(client)
+	resetRepo := auth.NewMongoResetTokenRepository(client)
+	pm.Execute(authRepo.MarkLegacyUsersVerified, "Failed to backfill email verification")
+	pm.Execute(authRepo.DropLegacyPasskeys, "Failed to drop legacy reset passkeys")
+	pm.Execute(tokenRepo.EnsureIndexes, "Failed to create refresh token indexes")
+	sessionRepo := auth.NewMongoSessionRepository(client)
+	pm.Execute(sessionRepo.EnsureIndexes, "Failed to create session indexes")
+	loginEventRepo := auth.NewMongoLoginEventRepository(client)
+	pm.Execute(loginEventRepo.EnsureIndexes, "Failed to create login history indexes")
+	pm.Execute(resetRepo.EnsureIndexes, "Failed to create reset token indexes")
+	magicLinkRepo := auth.NewMongoMagicLinkRepository(client)
+	pm.Execute(magicLinkRepo.EnsureIndexes, "Failed to create magic link indexes")
+	patRepo := auth.NewMongoPersonalAccessTokenRepository(client)
+	pm.Execute(patRepo.EnsureIndexes, "Failed to create personal access token indexes")
+	inviteRepo := auth.NewMongoInviteRepository(client)
+	pm.Execute(inviteRepo.EnsureIndexes, "Failed to create invite indexes")
+	auditRepo := auth.NewMongoAuditLogRepository(client)
+	pm.Execute(auditRepo.EnsureIndexes, "Failed to create audit log indexes")
+	var registration auth.RegistrationConfig
+	pm.Execute(func() error {
+		var err error
+		registration, err = auth.RegistrationConfigFromEnv()
+		return err
+	}, "Fatal: invalid registration configuration")
+	attemptRepo := auth.NewMongoLoginAttemptRepository(client)
+	pm.Execute(attemptRepo.EnsureIndexes, "Failed to create login attempt indexes")
+	throttle := auth.NewLoginThrottle(attemptRepo, auth.DefaultLoginThrottleConfig())
+	var authenticators []auth.Authenticator
+	pm.Execute(func() error {
+		var err error
+		authenticators, err = auth.AuthenticatorsFromEnv(authRepo)
+		return err
+	}, "Fatal: invalid AUTH_BACKENDS configuration")
+	authService := auth.NewAuthService(authRepo, tokenRepo, sessionRepo, loginEventRepo, resetRepo, magicLinkRepo, patRepo, inviteRepo, auditRepo, throttle, password.PolicyFromEnv(), registration, outbox, authenticators...)
 	authController := auth.NewAuthController(authService)
 	auth.RegisterRoutes(api, authController)
 
+	webauthnRepo := auth.NewMongoWebAuthnRepository(client)
+	pm.Execute(webauthnRepo.EnsureIndexes, "Failed to create WebAuthn indexes")
+	webauthnService := auth.NewWebAuthnService(authService, authRepo, webauthnRepo, webauthn.ConfigFromEnv())
+	auth.RegisterWebAuthnRoutes(api, auth.NewWebAuthnController(webauthnService))
+
+	// Providers are only contacted once someone logs in with them.
+	var oidcConfigs []oidc.Config
+	pm.Execute(func() error {
+		var err error
+		oidcConfigs, err = oidc.ConfigsFromEnv()
+		return err
+	}, "Fatal: invalid OIDC provider configuration")
+	var providers []*oidc.Provider
+	for _, cfg := range oidcConfigs {
+		providers = append(providers, oidc.NewProvider(cfg, nil))
+	}
+	oidcRepo := auth.NewMongoOIDCRepository(client)
+	pm.Execute(oidcRepo.EnsureIndexes, "Failed to create OIDC indexes")
+	auth.RegisterOIDCRoutes(api, auth.NewOIDCController(auth.NewOIDCService(authService, authRepo, oidcRepo, providers...)))
+
+	protected := api.Group("")
+	protected.Use(middleware.JWTMiddleware(authService))
+
 	// --- Requests Module ---
 	reqRepo := requests.NewMongoRequestRepository(client)
-	reqService := requests.NewRequestService(reqRepo)
+	allowlist := requests.ParseAllowlist(os.Getenv("REQUESTS_ALLOWLIST"))
+	reqService := requests.NewRequestService(reqRepo, allowlist)
 	reqController := requests.NewRequestController(reqService)
-	requests.RegisterRoutes(api, reqController)
+	requests.RegisterRoutes(protected, reqController)
 
 	// --- Kanban Module ---
 	kanbanRepo := kanban.NewKanbanRepository(reqRepo)
 	kanbanService := kanban.NewKanbanService(*kanbanRepo)
 	kanbanController := kanban.NewKanbanController(kanbanService)
-
-	kanbanGroup := api.Group("")
-	kanbanGroup.Use(middleware.JWTMiddleware())
-	kanban.RegisterRoutes(kanbanGroup, kanbanController)
+	kanban.RegisterRoutes(protected, kanbanController)
+
+	// --- Account Module ---
+	// Export and deletion of everything a user owns; the purger removes
+	// accounts once their deletion grace period is over.
+	accountService := account.NewAccountService(authRepo, authService, kanbanRepo, reqRepo, allowlist,
+		outbox, account.GracePeriodFromEnv(), tokenRepo, sessionRepo, loginEventRepo, resetRepo, magicLinkRepo, patRepo, webauthnRepo, oidcRepo)
+	account.RegisterRoutes(protected, account.NewAccountController(accountService))
+	go account.NewPurger(accountService, time.Hour).Run(context.Background())
+
+	// --- Admin Module ---
+	adminService := admin.NewAdminService(authRepo, authService, outboxRepo)
+	adminController := admin.NewAdminController(adminService)
+	admin.RegisterRoutes(protected, adminController)
 
 	for _, ri := range r.Routes() {
 		logrus.Infof("Route registered: %s %s", ri.Method, ri.Path)
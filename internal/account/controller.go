@@ -0,0 +1,95 @@
+package account
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+	service *AccountService
+}
+
+func NewAccountController(s *AccountService) *AccountController {
+	return &AccountController{service: s}
+}
+
+func (ctr *AccountController) Export(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	archive, err := ctr.service.Export(userId)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+
+	filename := "omhs-export-" + time.Now().UTC().Format("20060102") + ".zip"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+func (ctr *AccountController) RequestDeletion(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	status, err := ctr.service.RequestDeletion(userId, req, c.ClientIP())
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
+
+func (ctr *AccountController) CancelDeletion(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.CancelDeletion(userId); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deletion cancelled"})
+}
+
+func writeAccountError(c *gin.Context, err error) {
+	var retry *auth.RetryError
+	switch {
+	case errors.As(err, &retry):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is incorrect"})
+	case errors.Is(err, auth.ErrCurrentPasswordMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, auth.ErrUserNotFound), errors.Is(err, ErrDeletionNotScheduled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDeletionAlreadyQueued):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAuthenticatorUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,14 @@
+package account
+
+import "time"
+
+// DeleteAccountRequest confirms a deletion request with the current password.
+type DeleteAccountRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// DeletionStatus tells the user when their account will be purged.
+type DeletionStatus struct {
+	DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+	GraceDays           int       `json:"graceDays"`
+}
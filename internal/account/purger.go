@@ -0,0 +1,38 @@
+package account
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Purger deletes accounts whose deletion grace period has run out.
+type Purger struct {
+	service  *AccountService
+	interval time.Duration
+}
+
+func NewPurger(service *AccountService, interval time.Duration) *Purger {
+	return &Purger{service: service, interval: interval}
+}
+
+// Run purges due accounts every interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.service.PurgeDue(time.Now()); err != nil {
+			logrus.Errorf("Account purge failed: %v", err)
+		} else if n > 0 {
+			logrus.Infof("Purged %d deleted accounts", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
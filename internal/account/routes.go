@@ -0,0 +1,20 @@
+package account
+
+import (
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const BasePath = "/account"
+
+// RegisterRoutes expects r to already run JWTMiddleware.
+func RegisterRoutes(r *gin.RouterGroup, controller *AccountController) {
+	group := r.Group(BasePath, middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
+	{
+		group.GET("/export", middleware.RefuseImpersonation(), controller.Export)
+		group.POST("/delete", middleware.RefuseImpersonation(), controller.RequestDeletion)
+		group.DELETE("/delete", middleware.RefuseImpersonation(), controller.CancelDeletion)
+	}
+}
@@ -0,0 +1,253 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/kanban"
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/requests"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultGracePeriod is how long a deletion request can still be cancelled.
+const DefaultGracePeriod = 14 * 24 * time.Hour
+
+// purgeBatch bounds how many accounts one PurgeDue call deletes.
+const purgeBatch = 100
+
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrDeletionNotScheduled  = errors.New("account deletion is not scheduled")
+	ErrDeletionAlreadyQueued = errors.New("account deletion is already scheduled")
+)
+
+// UserDataStore is a store outside the users collection holding data of a
+// user, such as refresh tokens or passkeys. It is emptied when the account
+// is purged.
+type UserDataStore interface {
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+// UserDataExporter is a UserDataStore whose data is part of the export,
+// written to the archive as ExportName.
+type UserDataExporter interface {
+	ExportName() string
+	ExportByUser(userId primitive.ObjectID) (interface{}, error)
+}
+
+type AccountService struct {
+	users     auth.UserRepository
+	auth      *auth.AuthService
+	kanbans   *kanban.KanbanRepository
+	docs      requests.RequestRepository
+	allowlist requests.Allowlist
+	mailer    mail.Mailer
+	grace     time.Duration
+	stores    []UserDataStore
+}
+
+func NewAccountService(users auth.UserRepository, authService *auth.AuthService, kanbans *kanban.KanbanRepository, docs requests.RequestRepository, allowlist requests.Allowlist, mailer mail.Mailer, grace time.Duration, stores ...UserDataStore) *AccountService {
+	return &AccountService{users: users, auth: authService, kanbans: kanbans, docs: docs, allowlist: allowlist, mailer: mailer, grace: grace, stores: stores}
+}
+
+// GracePeriodFromEnv reads ACCOUNT_DELETION_GRACE_DAYS, keeping the default
+// for anything unset or invalid. Zero purges on the next worker run.
+func GracePeriodFromEnv() time.Duration {
+	if v, err := strconv.Atoi(utils.GetEnv("ACCOUNT_DELETION_GRACE_DAYS", "")); err == nil && v >= 0 {
+		return time.Duration(v) * 24 * time.Hour
+	}
+	return DefaultGracePeriod
+}
+
+// --- EXPORT ---
+
+// Export builds a ZIP of everything stored about the user: user.json,
+// kanban.json, requests/<database>/<collection>.json for every allowlisted
+// collection holding documents they own, and a file for every store that is
+// a UserDataExporter. Secrets such as the password hash are left out by the
+// JSON tags.
+func (s *AccountService) Export(userId primitive.ObjectID) ([]byte, error) {
+	user, err := s.users.FindByID(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	now := time.Now()
+
+	if err := writeJSON(zw, "user.json", user, now); err != nil {
+		return nil, err
+	}
+
+	board, err := s.kanbans.GetKanban(userId)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if err == nil {
+		if err := writeJSON(zw, "kanban.json", board, now); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range s.ownedCollections() {
+		docs, err := s.docs.GetAll(entry[0], entry[1], &userId)
+		if err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		if err := writeJSON(zw, "requests/"+entry[0]+"/"+entry[1]+".json", docs, now); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, store := range s.stores {
+		exporter, ok := store.(UserDataExporter)
+		if !ok {
+			continue
+		}
+		data, err := exporter.ExportByUser(userId)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeJSON(zw, exporter.ExportName(), data, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}, modified time.Time) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ownedCollections lists the allowlisted collections as {database, collection}
+// pairs. The Kanban collection is handled on its own and skipped here.
+func (s *AccountService) ownedCollections() [][2]string {
+	var out [][2]string
+	for _, entry := range s.allowlist.Entries() {
+		database, collection, ok := strings.Cut(entry, ".")
+		if !ok || (database == kanban.Database && collection == kanban.Collection) {
+			continue
+		}
+		out = append(out, [2]string{database, collection})
+	}
+	return out
+}
+
+// --- DELETION ---
+
+// RequestDeletion schedules the account for deletion after the grace period.
+// Until then everything keeps working and the request can be cancelled.
+func (s *AccountService) RequestDeletion(userId primitive.ObjectID, req DeleteAccountRequest, ip string) (*DeletionStatus, error) {
+	user, err := s.auth.Reauthenticate(userId, req.CurrentPassword, ip)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletionScheduledAt != nil {
+		return nil, ErrDeletionAlreadyQueued
+	}
+
+	at := time.Now().Add(s.grace).UTC()
+	if err := s.users.ScheduleDeletion(user.ID, &at); err != nil {
+		return nil, err
+	}
+
+	days := int(s.grace.Hours() / 24)
+	if err := s.sendDeletionNotice(user, at, days); err != nil {
+		logrus.Errorf("Failed to send deletion notice to %s: %v", user.Email, err)
+	}
+	return &DeletionStatus{DeletionScheduledAt: at, GraceDays: days}, nil
+}
+
+func (s *AccountService) CancelDeletion(userId primitive.ObjectID) error {
+	user, err := s.users.FindByID(userId)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if user.DeletionScheduledAt == nil {
+		return ErrDeletionNotScheduled
+	}
+	return s.users.ScheduleDeletion(user.ID, nil)
+}
+
+func (s *AccountService) sendDeletionNotice(user *auth.User, at time.Time, days int) error {
+	msg, err := mail.Render(mail.TemplateAccountDeletion, user.Locale, mail.Data{
+		"Username": user.Username,
+		"Date":     at.Format("2006-01-02"),
+		"Days":     days,
+	})
+	if err != nil {
+		return err
+	}
+	msg.ID = mail.Key(mail.TemplateAccountDeletion, user.ID.Hex(), strconv.FormatInt(at.Unix(), 10))
+	msg.To = user.Email
+	return s.mailer.Send(msg)
+}
+
+// PurgeDue deletes every account whose grace period ended before now, with
+// all of its data. It returns how many accounts were removed; a failing
+// account is logged and retried on the next run.
+func (s *AccountService) PurgeDue(now time.Time) (int, error) {
+	users, err := s.users.FindDueForDeletion(now, purgeBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range users {
+		if err := s.purge(&users[i]); err != nil {
+			logrus.Errorf("Failed to purge account %s: %v", users[i].ID.Hex(), err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purge removes the user record last, so a run that fails halfway finds the
+// account again next time and finishes the job.
+func (s *AccountService) purge(user *auth.User) error {
+	if err := s.kanbans.DeleteKanban(user.ID); err != nil {
+		return err
+	}
+	for _, entry := range s.ownedCollections() {
+		if _, err := s.docs.DeleteAll(entry[0], entry[1], user.ID); err != nil {
+			return err
+		}
+	}
+	for _, store := range s.stores {
+		if err := store.DeleteByUser(user.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.auth.UnlockLogin(user.Username); err != nil {
+		return err
+	}
+
+	logrus.Infof("Purging account %s after its deletion grace period", user.ID.Hex())
+	return s.users.Delete(user.ID)
+}
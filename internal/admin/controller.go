@@ -0,0 +1,315 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AdminController struct {
+	service *AdminService
+}
+
+func NewAdminController(s *AdminService) *AdminController {
+	return &AdminController{service: s}
+}
+
+func getTargetId(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id format"})
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// getQueryId parses an optional id query parameter; a missing one is the
+// zero id.
+func getQueryId(c *gin.Context, name string) (primitive.ObjectID, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return primitive.NilObjectID, true
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, auth.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, mail.ErrUnknownTemplate), errors.Is(err, mail.ErrOutboxNotFound), errors.Is(err, auth.ErrInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidEmail), errors.Is(err, auth.ErrInvalidInviteRole), errors.Is(err, auth.ErrInvalidInviteExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrImpersonateAdmin), errors.Is(err, auth.ErrImpersonationOver):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrSelfOperation), errors.Is(err, mail.ErrNotRetryable), errors.Is(err, auth.ErrAccountDisabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrResetThrottled):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (ctr *AdminController) ListUsers(c *gin.Context) {
+	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(DefaultPageSize)), 10, 64)
+
+	result, err := ctr.service.ListUsers(c.Query("search"), page, limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *AdminController) GetUser(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	user, err := ctr.service.GetUser(id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AdminController) DisableUser(c *gin.Context) {
+	ctr.setDisabled(c, true)
+}
+
+func (ctr *AdminController) EnableUser(c *gin.Context) {
+	ctr.setDisabled(c, false)
+}
+
+func (ctr *AdminController) setDisabled(c *gin.Context, disabled bool) {
+	actor, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	user, err := ctr.service.SetDisabled(actor, id, disabled)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AdminController) PromoteUser(c *gin.Context) {
+	ctr.setAdmin(c, true)
+}
+
+func (ctr *AdminController) DemoteUser(c *gin.Context) {
+	ctr.setAdmin(c, false)
+}
+
+func (ctr *AdminController) setAdmin(c *gin.Context, isAdmin bool) {
+	actor, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	user, err := ctr.service.SetAdmin(actor, id, isAdmin)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AdminController) UnlockUser(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	user, err := ctr.service.UnlockUser(id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AdminController) ResetPassword(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.ForcePasswordReset(id); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "passkey sent"})
+}
+
+func (ctr *AdminController) Impersonate(c *gin.Context) {
+	actor, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	token, err := ctr.service.Impersonate(actor, id, middleware.CurrentSessionID(c), c.ClientIP())
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+// GetAuditLog can be narrowed with the actorId and userId query parameters.
+func (ctr *AdminController) GetAuditLog(c *gin.Context) {
+	actorId, ok := getQueryId(c, "actorId")
+	if !ok {
+		return
+	}
+	userId, ok := getQueryId(c, "userId")
+	if !ok {
+		return
+	}
+	filter := auth.AuditLogFilter{ActorID: actorId, UserID: userId}
+	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(auth.DefaultAuditLogSize)), 10, 64)
+
+	result, err := ctr.service.AuditLog(filter, page, limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *AdminController) ListInvites(c *gin.Context) {
+	invites, err := ctr.service.ListInvites()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+func (ctr *AdminController) CreateInvite(c *gin.Context) {
+	actor, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req auth.CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	invite, err := ctr.service.CreateInvite(actor, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, invite)
+}
+
+func (ctr *AdminController) RevokeInvite(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.RevokeInvite(id); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
+
+func (ctr *AdminController) ListEmailTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, ctr.service.ListEmailTemplates())
+}
+
+// PreviewEmail returns the rendered template as JSON, or only the HTML or
+// text part with ?format=html|text so it can be opened in a browser.
+func (ctr *AdminController) PreviewEmail(c *gin.Context) {
+	preview, err := ctr.service.PreviewEmail(c.Param("name"), c.Query("locale"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	switch c.Query("format") {
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(preview.HTML))
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(preview.Text))
+	default:
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+func (ctr *AdminController) ListOutbox(c *gin.Context) {
+	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(DefaultPageSize)), 10, 64)
+
+	result, err := ctr.service.ListOutbox(c.Query("status"), page, limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *AdminController) GetOutboxMessage(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	msg, err := ctr.service.GetOutboxMessage(id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+func (ctr *AdminController) RetryOutboxMessage(c *gin.Context) {
+	id, ok := getTargetId(c)
+	if !ok {
+		return
+	}
+
+	msg, err := ctr.service.RetryOutboxMessage(id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
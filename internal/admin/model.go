@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"time"
+
+	"omhs-backend/internal/auth"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserSummary is the admin view of a user. It deliberately leaves out
+// the password hash and other secrets stored on auth.User.
+type UserSummary struct {
+	ID        primitive.ObjectID `json:"id"`
+	Username  string             `json:"username"`
+	Email     string             `json:"email"`
+	IsAdmin   bool               `json:"isAdmin"`
+	Roles     []string           `json:"roles"`
+	Disabled  bool               `json:"disabled"`
+	LastLogin time.Time          `json:"lastLogin"`
+}
+
+func NewUserSummary(u *auth.User) UserSummary {
+	return UserSummary{
+		ID:        u.ID,
+		Username:  u.Username,
+		Email:     u.Email,
+		IsAdmin:   u.IsAdmin,
+		Roles:     u.EffectiveRoles(),
+		Disabled:  u.Disabled,
+		LastLogin: u.LastLogin,
+	}
+}
+
+type UserPage struct {
+	Users []UserSummary `json:"users"`
+	Total int64         `json:"total"`
+	Page  int64         `json:"page"`
+	Limit int64         `json:"limit"`
+}
+
+type EmailTemplateList struct {
+	Templates []string `json:"templates"`
+	Locales   []string `json:"locales"`
+}
+
+// EmailPreview is a template rendered with sample data.
+type EmailPreview struct {
+	Template string `json:"template"`
+	Locale   string `json:"locale"`
+	Subject  string `json:"subject"`
+	Text     string `json:"text"`
+	HTML     string `json:"html"`
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const BasePath = "/admin"
+
+// RegisterRoutes expects r to already run JWTMiddleware. Every route here is admin-only.
+func RegisterRoutes(r *gin.RouterGroup, controller *AdminController) {
+	group := r.Group(BasePath, middleware.RequireRole(utils.RoleAdmin))
+	{
+		group.GET("/users", controller.ListUsers)
+		group.GET("/users/:id", controller.GetUser)
+		group.POST("/users/:id/disable", controller.DisableUser)
+		group.POST("/users/:id/enable", controller.EnableUser)
+		group.POST("/users/:id/promote", controller.PromoteUser)
+		group.POST("/users/:id/demote", controller.DemoteUser)
+		group.POST("/users/:id/unlock", controller.UnlockUser)
+		group.POST("/users/:id/reset-password", controller.ResetPassword)
+		group.POST("/users/:id/impersonate", controller.Impersonate)
+		group.GET("/audit-log", controller.GetAuditLog)
+
+		group.GET("/invites", controller.ListInvites)
+		group.POST("/invites", controller.CreateInvite)
+		group.DELETE("/invites/:id", controller.RevokeInvite)
+
+		group.GET("/emails", controller.ListEmailTemplates)
+		group.GET("/emails/:name/preview", controller.PreviewEmail)
+
+		group.GET("/outbox", controller.ListOutbox)
+		group.GET("/outbox/:id", controller.GetOutboxMessage)
+		group.POST("/outbox/:id/retry", controller.RetryOutboxMessage)
+	}
+}
@@ -0,0 +1,218 @@
+package admin
+
+import (
+	"errors"
+	"time"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/mail"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrSelfOperation = errors.New("admins cannot change their own account status")
+)
+
+type AdminService struct {
+	users  auth.UserRepository
+	auth   *auth.AuthService
+	outbox mail.OutboxRepository
+}
+
+func NewAdminService(users auth.UserRepository, authService *auth.AuthService, outbox mail.OutboxRepository) *AdminService {
+	return &AdminService{users: users, auth: authService, outbox: outbox}
+}
+
+// --- USERS ---
+func (s *AdminService) ListUsers(search string, page, limit int64) (*UserPage, error) {
+	page, limit = clampPage(page, limit)
+
+	users, total, err := s.users.List(search, (page-1)*limit, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserPage{Users: make([]UserSummary, 0, len(users)), Total: total, Page: page, Limit: limit}
+	for i := range users {
+		result.Users = append(result.Users, NewUserSummary(&users[i]))
+	}
+	return result, nil
+}
+
+func (s *AdminService) GetUser(id primitive.ObjectID) (*UserSummary, error) {
+	user, err := s.users.FindByID(id)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	summary := NewUserSummary(user)
+	return &summary, nil
+}
+
+// SetDisabled blocks or unblocks an account. Disabling also revokes every
+// session so the user is logged out straight away.
+func (s *AdminService) SetDisabled(actor, id primitive.ObjectID, disabled bool) (*UserSummary, error) {
+	if actor == id {
+		return nil, ErrSelfOperation
+	}
+	if err := s.users.SetDisabled(id, disabled); err != nil {
+		return nil, notFound(err)
+	}
+	if disabled {
+		if err := s.auth.RevokeUserSessions(id); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetUser(id)
+}
+
+// SetAdmin promotes or demotes a user. Demotion revokes existing sessions
+// so access tokens still carrying the admin role stop working.
+func (s *AdminService) SetAdmin(actor, id primitive.ObjectID, isAdmin bool) (*UserSummary, error) {
+	if actor == id {
+		return nil, ErrSelfOperation
+	}
+	if err := s.users.SetAdmin(id, isAdmin); err != nil {
+		return nil, notFound(err)
+	}
+	if !isAdmin {
+		if err := s.auth.RevokeUserSessions(id); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetUser(id)
+}
+
+// UnlockUser lifts a login lockout caused by too many failed passwords.
+func (s *AdminService) UnlockUser(id primitive.ObjectID) (*UserSummary, error) {
+	user, err := s.users.FindByID(id)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if err := s.auth.UnlockLogin(user.Username); err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+// ForcePasswordReset sends the user a reset passkey through the regular flow.
+func (s *AdminService) ForcePasswordReset(id primitive.ObjectID) error {
+	user, err := s.users.FindByID(id)
+	if err != nil {
+		return notFound(err)
+	}
+	return s.auth.ResetPassword(auth.ResetPasswordRequest{Email: user.Email, Username: user.Username})
+}
+
+// --- IMPERSONATION ---
+
+// Impersonate lets actor act as the user id for a few minutes, on the
+// actor's current session. Admins cannot be impersonated.
+func (s *AdminService) Impersonate(actor, id primitive.ObjectID, sessionId, ip string) (*auth.ImpersonationToken, error) {
+	return s.auth.Impersonate(actor, id, sessionId, ip)
+}
+
+// AuditLog lists impersonations and the requests made during them.
+func (s *AdminService) AuditLog(filter auth.AuditLogFilter, page, limit int64) (*auth.AuditLogPage, error) {
+	return s.auth.AuditLog(filter, page, limit)
+}
+
+// --- INVITES ---
+
+// CreateInvite emails a single-use registration invite from actor.
+func (s *AdminService) CreateInvite(actor primitive.ObjectID, req auth.CreateInviteRequest) (*auth.Invite, error) {
+	return s.auth.CreateInvite(actor, req)
+}
+
+// ListInvites returns the invites that have not been used, revoked or expired.
+func (s *AdminService) ListInvites() ([]auth.Invite, error) {
+	return s.auth.ListInvites()
+}
+
+func (s *AdminService) RevokeInvite(id primitive.ObjectID) error {
+	return s.auth.RevokeInvite(id)
+}
+
+// --- EMAILS ---
+func (s *AdminService) ListEmailTemplates() EmailTemplateList {
+	return EmailTemplateList{Templates: mail.TemplateNames(), Locales: mail.Locales()}
+}
+
+// PreviewEmail renders a template with sample data so wording and layout
+// can be checked per locale without sending anything.
+func (s *AdminService) PreviewEmail(name, locale string) (*EmailPreview, error) {
+	msg, err := mail.Preview(name, locale)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailPreview{
+		Template: name,
+		Locale:   mail.MatchLocale(locale),
+		Subject:  msg.Subject,
+		Text:     msg.Text,
+		HTML:     msg.HTML,
+	}, nil
+}
+
+// --- OUTBOX ---
+func (s *AdminService) ListOutbox(status string, page, limit int64) (*mail.OutboxPage, error) {
+	page, limit = clampPage(page, limit)
+	messages, total, err := s.outbox.List(status, (page-1)*limit, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &mail.OutboxPage{Messages: messages, Total: total}, nil
+}
+
+func (s *AdminService) GetOutboxMessage(id primitive.ObjectID) (*mail.OutboxMessage, error) {
+	msg, err := s.outbox.FindByID(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, mail.ErrOutboxNotFound
+	}
+	return msg, err
+}
+
+// RetryOutboxMessage requeues a dead message for immediate delivery.
+func (s *AdminService) RetryOutboxMessage(id primitive.ObjectID) (*mail.OutboxMessage, error) {
+	msg, err := s.GetOutboxMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != mail.StatusDead {
+		return nil, mail.ErrNotRetryable
+	}
+	if err := s.outbox.Retry(id, time.Now()); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, mail.ErrNotRetryable
+		}
+		return nil, err
+	}
+	return s.GetOutboxMessage(id)
+}
+
+func clampPage(page, limit int64) (int64, int64) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return page, limit
+}
+
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserNotFound
+	}
+	return err
+}
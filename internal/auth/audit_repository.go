@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditLogRepository is append-only. Entries outlive the accounts they
+// mention and are only dropped once AuditLogRetention has passed.
+type AuditLogRepository interface {
+	Append(entry *AuditEntry) error
+	List(filter AuditLogFilter, skip, limit int64) ([]AuditEntry, int64, error)
+}
+
+// AuditLogFilter narrows the log to one admin or one impersonated user;
+// zero ids match everyone.
+type AuditLogFilter struct {
+	ActorID primitive.ObjectID
+	UserID  primitive.ObjectID
+}
+
+type MongoAuditLogRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoAuditLogRepository(client *mongo.Client) *MongoAuditLogRepository {
+	return &MongoAuditLogRepository{client: client}
+}
+
+func (r *MongoAuditLogRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("auditLog")
+}
+
+func (r *MongoAuditLogRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(AuditLogRetention.Seconds()))},
+	})
+	return err
+}
+
+func (r *MongoAuditLogRepository) Append(entry *AuditEntry) error {
+	_, err := r.collection().InsertOne(context.TODO(), entry)
+	return err
+}
+
+// List returns a page of matching entries, newest first, and their total.
+func (r *MongoAuditLogRepository) List(f AuditLogFilter, skip, limit int64) ([]AuditEntry, int64, error) {
+	filter := bson.M{}
+	if !f.ActorID.IsZero() {
+		filter["actorId"] = f.ActorID
+	}
+	if !f.UserID.IsZero() {
+		filter["userId"] = f.UserID
+	}
+	total, err := r.collection().CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := r.collection().Find(context.TODO(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.TODO())
+
+	entries := []AuditEntry{}
+	if err := cursor.All(context.TODO(), &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/ldap"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const AuthSourceLDAP = "ldap"
+
+var (
+	ErrAuthenticatorUnavailable = errors.New("authentication service unavailable, try again later")
+	ErrExternalAccount          = errors.New("this account is managed by an external directory")
+)
+
+// Authenticator checks a username and password for Login. AuthService tries
+// its authenticators in order until one accepts.
+type Authenticator interface {
+	// Source is the User.AuthSource of the accounts this authenticator is
+	// responsible for; "" for local passwords.
+	Source() string
+	// Authenticate returns the user or ErrInvalidCredentials. Any other
+	// error means the credentials could not be checked at all.
+	Authenticate(username, password string) (*User, error)
+}
+
+// AuthenticatorsFromEnv builds the chain named by the comma-separated
+// AUTH_BACKENDS, "password" and "ldap", in the order listed. Only local
+// passwords are checked when it is unset.
+func AuthenticatorsFromEnv(users UserRepository) ([]Authenticator, error) {
+	var list []Authenticator
+	for _, name := range strings.Split(utils.GetEnv("AUTH_BACKENDS", "password"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "password":
+			list = append(list, NewPasswordAuthenticator(users))
+		case AuthSourceLDAP:
+			cfg, err := ldap.ConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, NewLDAPAuthenticator(ldap.NewDirectory(cfg), users))
+		default:
+			return nil, fmt.Errorf("unknown AUTH_BACKENDS entry %q", name)
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("AUTH_BACKENDS lists no authenticator")
+	}
+	return list, nil
+}
+
+// --- LOCAL PASSWORDS ---
+
+// PasswordAuthenticator checks the argon2id or legacy bcrypt hash stored on
+// the user. Accounts owned by another authenticator are skipped.
+type PasswordAuthenticator struct {
+	users UserRepository
+}
+
+func NewPasswordAuthenticator(users UserRepository) *PasswordAuthenticator {
+	return &PasswordAuthenticator{users: users}
+}
+
+func (a *PasswordAuthenticator) Source() string {
+	return ""
+}
+
+func (a *PasswordAuthenticator) Authenticate(username, password string) (*User, error) {
+	user, err := a.users.FindByUsername(username)
+	if err != nil || user.AuthSource != "" {
+		utils.VerifyDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, needsRehash := utils.VerifyPassword(user.Password, password)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		a.rehash(user, password)
+	}
+	return user, nil
+}
+
+// rehash upgrades a legacy bcrypt or outdated argon2 hash while the
+// plaintext is at hand. Failing here must not fail the login.
+func (a *PasswordAuthenticator) rehash(user *User, password string) {
+	hash, err := utils.HashPassword(password)
+	if err == nil {
+		err = a.users.UpdatePassword(user.ID, hash)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to rehash password for %s: %v", user.Username, err)
+	}
+}
+
+// --- LDAP ---
+
+// LDAPAuthenticator binds to the directory as the user. The local account is
+// created on first login and its email, display name and admin flag are
+// refreshed from the directory on every login after that.
+type LDAPAuthenticator struct {
+	dir   *ldap.Directory
+	users UserRepository
+}
+
+func NewLDAPAuthenticator(dir *ldap.Directory, users UserRepository) *LDAPAuthenticator {
+	return &LDAPAuthenticator{dir: dir, users: users}
+}
+
+func (a *LDAPAuthenticator) Source() string {
+	return AuthSourceLDAP
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*User, error) {
+	identity, err := a.dir.Authenticate(username, password)
+	if errors.Is(err, ldap.ErrInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticatorUnavailable, err)
+	}
+
+	user, err := a.users.FindByUsername(identity.Username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return a.provision(identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A local account of the same name is not taken over by the directory.
+	if user.AuthSource != AuthSourceLDAP {
+		logrus.Warnf("LDAP user %s matches local account %s, refusing login", identity.DN, user.ID.Hex())
+		return nil, ErrInvalidCredentials
+	}
+
+	displayName := clipDisplayName(identity.DisplayName)
+	if err := a.users.SyncExternalUser(user.ID, identity.Email, displayName, identity.IsAdmin); err != nil {
+		return nil, err
+	}
+	user.Email, user.EmailVerified = identity.Email, true
+	user.DisplayName, user.IsAdmin = displayName, identity.IsAdmin
+	return user, nil
+}
+
+func (a *LDAPAuthenticator) provision(identity *ldap.Identity) (*User, error) {
+	user := &User{
+		ID:            utils.NewObjectID(),
+		Username:      identity.Username,
+		Email:         identity.Email,
+		EmailVerified: true,
+		DisplayName:   clipDisplayName(identity.DisplayName),
+		IsAdmin:       identity.IsAdmin,
+		AuthSource:    AuthSourceLDAP,
+		ExternalID:    identity.DN,
+		LastLogin:     time.Now(),
+	}
+	if err := a.users.Create(user); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Provisioned LDAP user %s", identity.DN)
+	return user, nil
+}
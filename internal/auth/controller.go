@@ -1,10 +1,16 @@
 package auth
 
 import (
+	"errors"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
+
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/password"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthController struct {
@@ -24,15 +30,22 @@ func (ctr *AuthController) Register(c *gin.Context) {
 
 	user, err := ctr.service.Register(req)
 	if err != nil {
+		if writePolicyError(c, err) {
+			return
+		}
 		if err.Error() == "username already exists" {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
 		}
+		if errors.Is(err, ErrRegistrationClosed) || errors.Is(err, ErrEmailDomainNotAllowed) || errors.Is(err, ErrInvalidInvite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, user)
+	c.JSON(http.StatusCreated, user.Public())
 }
 
 func (ctr *AuthController) Login(c *gin.Context) {
@@ -42,13 +55,60 @@ func (ctr *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := ctr.service.Login(req)
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	result, err := ctr.service.Login(req)
 	if err != nil {
+		var retry *RetryError
+		switch {
+		case errors.As(err, &retry):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "email_not_verified"})
+		case errors.Is(err, ErrAuthenticatorUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *AuthController) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctr.service.Refresh(req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ctr *AuthController) Logout(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := ctr.service.Logout(req); err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
 }
 
 func (ctr *AuthController) ResetPassword(c *gin.Context) {
@@ -59,6 +119,11 @@ func (ctr *AuthController) ResetPassword(c *gin.Context) {
 	}
 
 	if err := ctr.service.ResetPassword(req); err != nil {
+		if errors.Is(err, ErrResetThrottled) {
+			c.Header("Retry-After", strconv.Itoa(int(ResetRequestInterval.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -74,9 +139,15 @@ func (ctr *AuthController) ChangePassword(c *gin.Context) {
 	}
 
 	if err := ctr.service.ChangePassword(req); err != nil {
-		if strings.Contains(err.Error(), "invalid passkey") {
+		if writePolicyError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, ErrInvalidPasskey):
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		} else {
+		case errors.Is(err, ErrResetLocked):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		}
 		return
@@ -84,3 +155,428 @@ func (ctr *AuthController) ChangePassword(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "password changed successfully"})
 }
+
+func (ctr *AuthController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	if err := ctr.service.VerifyEmail(token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+func (ctr *AuthController) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := ctr.service.ResendVerification(req); err != nil {
+		switch {
+		case errors.Is(err, ErrResendThrottled):
+			c.Header("Retry-After", strconv.Itoa(int(VerificationResendInterval.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrAlreadyVerified):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
+
+func (ctr *AuthController) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctr.service.LoginMFA(req)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RequestMagicLink answers the same whether or not the email belongs to an
+// account.
+func (ctr *AuthController) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+
+	if err := ctr.service.RequestMagicLink(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send login link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if an account with this email exists, a login link has been sent"})
+}
+
+// MagicLinkPage is where the emailed link points. Mail scanners open links
+// on their own, so it only passes the token on to the frontend, which logs
+// in with a POST once the user is actually there.
+func (ctr *AuthController) MagicLinkPage(c *gin.Context) {
+	c.Redirect(http.StatusFound, MagicLinkFrontendURL(c.Query("token")))
+}
+
+// LoginMagicLink takes the token from a JSON or form body.
+func (ctr *AuthController) LoginMagicLink(c *gin.Context) {
+	var req MagicLinkLoginRequest
+	if err := c.ShouldBind(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	result, err := ctr.service.LoginMagicLink(req)
+	if err != nil {
+		var retry *RetryError
+		if errors.As(err, &retry) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *AuthController) SetupTOTP(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	setup, err := ctr.service.SetupTOTP(userId)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (ctr *AuthController) ConfirmTOTP(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	codes, err := ctr.service.ConfirmTOTP(userId, req)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func (ctr *AuthController) DisableTOTP(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := ctr.service.DisableTOTP(userId, req); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+func (ctr *AuthController) GetMe(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := ctr.service.GetProfile(userId)
+	if err != nil {
+		writeProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AuthController) UpdateMe(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+
+	user, err := ctr.service.UpdateProfile(userId, req)
+	if err != nil {
+		writeProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (ctr *AuthController) ChangeMyPassword(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req ChangeOwnPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctr.service.ChangeOwnPassword(userId, req)
+	if err != nil {
+		writeProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ctr *AuthController) ConfirmEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	if err := ctr.service.ConfirmEmailChange(token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email changed"})
+}
+
+func (ctr *AuthController) ListTokens(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := ctr.service.ListPersonalAccessTokens(userId)
+	if err != nil {
+		writeTokenError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ctr *AuthController) CreateToken(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	token, err := ctr.service.CreatePersonalAccessToken(userId, req)
+	if err != nil {
+		writeTokenError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (ctr *AuthController) RevokeToken(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := ctr.service.RevokePersonalAccessToken(userId, id); err != nil {
+		writeTokenError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
+
+func (ctr *AuthController) ListSessions(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := ctr.service.ListSessions(userId, middleware.CurrentSessionID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (ctr *AuthController) RevokeSession(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.RevokeSession(userId, c.Param("id")); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeOtherSessions logs the caller out everywhere else.
+func (ctr *AuthController) RevokeOtherSessions(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := ctr.service.RevokeOtherSessions(userId, middleware.CurrentSessionID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions revoked", "revoked": revoked})
+}
+
+func (ctr *AuthController) GetLoginHistory(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(DefaultLoginHistorySize)), 10, 64)
+
+	history, err := ctr.service.LoginHistory(userId, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func writeTokenError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTooManyTokens):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidTokenName), errors.Is(err, ErrInvalidScope), errors.Is(err, ErrInvalidExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// writeProfileError maps errors of the /me endpoints. A wrong current
+// password is 403 rather than 401, the caller's token itself is fine.
+func writeProfileError(c *gin.Context, err error) {
+	if writePolicyError(c, err) {
+		return
+	}
+	var retry *RetryError
+	switch {
+	case errors.As(err, &retry):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidCredentials):
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is incorrect"})
+	case errors.Is(err, ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrExternalAccount):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAuthenticatorUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidDisplayName), errors.Is(err, ErrInvalidTimezone),
+		errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrCurrentPasswordMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// writePolicyError answers 400 with every broken password rule, e.g.
+// {"error": "...", "fields": [{"field": "password", "code": "too_short", ...}]}.
+func writePolicyError(c *gin.Context, err error) bool {
+	var perr *password.PolicyError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "password does not meet the requirements", "fields": perr.Fields})
+	return true
+}
+
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidMFAToken), errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrAccountDisabled):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMFALocked):
+		c.Header("Retry-After", strconv.Itoa(int(MFAFailureCooldown.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTOTPAlreadyEnabled), errors.Is(err, ErrTOTPNotEnabled), errors.Is(err, ErrNoPendingTOTP):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
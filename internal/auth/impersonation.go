@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// ImpersonationTTL bounds an impersonation token. It cannot be refreshed,
+	// so support has to ask again, and is audited again, once it runs out.
+	ImpersonationTTL = 10 * time.Minute
+
+	AuditActionImpersonate = "impersonate"
+	AuditActionRequest     = "impersonated-request"
+
+	AuditLogRetention   = 365 * 24 * time.Hour
+	DefaultAuditLogSize = 50
+	MaxAuditLogSize     = 200
+)
+
+var (
+	ErrImpersonateAdmin  = errors.New("admins cannot be impersonated")
+	ErrImpersonationOver = errors.New("impersonation is no longer allowed")
+)
+
+// --- IMPERSONATION ---
+
+// Impersonate issues an access token that lets the admin actor act as the
+// user target. It is bound to the admin's own session, so logging that out
+// ends the impersonation as well.
+func (s *AuthService) Impersonate(actorId, targetId primitive.ObjectID, sessionId, ip string) (*ImpersonationToken, error) {
+	actor, err := s.repo.FindByID(actorId)
+	if err != nil || !actor.IsAdmin {
+		return nil, ErrImpersonationOver
+	}
+	target, err := s.repo.FindByID(targetId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if target.IsAdmin {
+		return nil, ErrImpersonateAdmin
+	}
+	if target.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	now := time.Now()
+	token, err := utils.GenerateImpersonationJWT(target.ID.Hex(), target.Username, target.EffectiveRoles(),
+		utils.Actor{Subject: actor.ID.Hex(), Username: actor.Username}, sessionId, ImpersonationTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a record of it there is no impersonation.
+	if err := s.audit.Append(&AuditEntry{
+		ID:        utils.NewObjectID(),
+		Action:    AuditActionImpersonate,
+		ActorID:   actor.ID,
+		UserID:    target.ID,
+		IP:        ip,
+		CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &ImpersonationToken{AccessToken: token, ExpiresAt: now.Add(ImpersonationTTL), User: target.Public()}, nil
+}
+
+// ValidateImpersonation is ValidateSession for impersonation tokens: the
+// admin's session has to be live, and both accounts still have to be what
+// they were when the token was issued.
+func (s *AuthService) ValidateImpersonation(actorId, userId primitive.ObjectID, sessionId string) error {
+	if err := s.ValidateSession(actorId, sessionId); err != nil {
+		return err
+	}
+	actor, err := s.repo.FindByID(actorId)
+	if err != nil || !actor.IsAdmin {
+		return ErrImpersonationOver
+	}
+	user, err := s.repo.FindByID(userId)
+	if err != nil || user.IsAdmin {
+		return ErrImpersonationOver
+	}
+	if user.Disabled {
+		return ErrAccountDisabled
+	}
+	return nil
+}
+
+// RecordImpersonatedRequest adds a request made with an impersonation token
+// to the audit log. The response has been written by then, so errors are
+// only logged.
+func (s *AuthService) RecordImpersonatedRequest(actorId, userId primitive.ObjectID, method, path string, status int, ip string) {
+	err := s.audit.Append(&AuditEntry{
+		ID:        utils.NewObjectID(),
+		Action:    AuditActionRequest,
+		ActorID:   actorId,
+		UserID:    userId,
+		Method:    method,
+		Path:      path,
+		Status:    status,
+		IP:        ip,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to audit %s %s by %s as %s: %v", method, path, actorId.Hex(), userId.Hex(), err)
+	}
+}
+
+// AuditLog returns a page of the audit log, newest first.
+func (s *AuthService) AuditLog(filter AuditLogFilter, page, limit int64) (*AuditLogPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = DefaultAuditLogSize
+	}
+	if limit > MaxAuditLogSize {
+		limit = MaxAuditLogSize
+	}
+
+	entries, total, err := s.audit.List(filter, (page-1)*limit, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogPage{Entries: entries, Total: total, Page: page, Limit: limit}, nil
+}
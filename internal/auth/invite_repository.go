@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InviteRepository interface {
+	Create(invite *Invite) error
+	FindByCodeHash(codeHash string) (*Invite, error)
+	ListOutstanding(now time.Time) ([]Invite, error)
+	Consume(id, userId primitive.ObjectID, now time.Time) (bool, error)
+	Release(id primitive.ObjectID) error
+	Revoke(id primitive.ObjectID, now time.Time) error
+}
+
+type MongoInviteRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoInviteRepository(client *mongo.Client) *MongoInviteRepository {
+	return &MongoInviteRepository{client: client}
+}
+
+func (r *MongoInviteRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("invites")
+}
+
+func (r *MongoInviteRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "codeHash", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// outstandingInvites matches invites that are neither used, revoked nor expired.
+func outstandingInvites(now time.Time) bson.M {
+	return bson.M{
+		"usedAt":    bson.M{"$exists": false},
+		"revokedAt": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$exists": false}},
+			{"expiresAt": bson.M{"$gt": now}},
+		},
+	}
+}
+
+func (r *MongoInviteRepository) Create(invite *Invite) error {
+	_, err := r.collection().InsertOne(context.TODO(), invite)
+	return err
+}
+
+func (r *MongoInviteRepository) FindByCodeHash(codeHash string) (*Invite, error) {
+	var invite Invite
+	err := r.collection().FindOne(context.TODO(), bson.M{"codeHash": codeHash}).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// ListOutstanding returns the invites that can still be used, newest first.
+func (r *MongoInviteRepository) ListOutstanding(now time.Time) ([]Invite, error) {
+	cursor, err := r.collection().Find(context.TODO(), outstandingInvites(now),
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	invites := []Invite{}
+	if err := cursor.All(context.TODO(), &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// Consume marks the invite used by userId. It reports false if it was no
+// longer outstanding, e.g. because a concurrent registration used it first.
+func (r *MongoInviteRepository) Consume(id, userId primitive.ObjectID, now time.Time) (bool, error) {
+	filter := outstandingInvites(now)
+	filter["_id"] = id
+	res, err := r.collection().UpdateOne(context.TODO(), filter,
+		bson.M{"$set": bson.M{"usedAt": now, "usedBy": userId}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Release makes a consumed invite usable again after the registration it
+// was consumed for failed.
+func (r *MongoInviteRepository) Release(id primitive.ObjectID) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"usedAt": "", "usedBy": ""}})
+	return err
+}
+
+// Revoke only matches outstanding invites.
+func (r *MongoInviteRepository) Revoke(id primitive.ObjectID, now time.Time) error {
+	filter := outstandingInvites(now)
+	filter["_id"] = id
+	res, err := r.collection().UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{"revokedAt": now}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
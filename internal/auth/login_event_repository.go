@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginEventRepository is append-only: events are never changed, only
+// dropped once LoginHistoryRetention has passed or the account is purged.
+type LoginEventRepository interface {
+	Append(event *LoginEvent) error
+	List(userId primitive.ObjectID, skip, limit int64) ([]LoginEvent, int64, error)
+	HasSucceeded(userId primitive.ObjectID, fingerprint string) (bool, error)
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoLoginEventRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoLoginEventRepository(client *mongo.Client) *MongoLoginEventRepository {
+	return &MongoLoginEventRepository{client: client}
+}
+
+func (r *MongoLoginEventRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("loginEvents")
+}
+
+func (r *MongoLoginEventRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "fingerprint", Value: 1}, {Key: "success", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(LoginHistoryRetention.Seconds()))},
+	})
+	return err
+}
+
+func (r *MongoLoginEventRepository) Append(event *LoginEvent) error {
+	_, err := r.collection().InsertOne(context.TODO(), event)
+	return err
+}
+
+// List returns a page of the user's events, newest first, and their total.
+func (r *MongoLoginEventRepository) List(userId primitive.ObjectID, skip, limit int64) ([]LoginEvent, int64, error) {
+	filter := bson.M{"userId": userId}
+	total, err := r.collection().CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := r.collection().Find(context.TODO(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.TODO())
+
+	events := []LoginEvent{}
+	if err := cursor.All(context.TODO(), &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// HasSucceeded reports whether the user ever logged in successfully from
+// fingerprint, or at all when fingerprint is empty.
+func (r *MongoLoginEventRepository) HasSucceeded(userId primitive.ObjectID, fingerprint string) (bool, error) {
+	filter := bson.M{"userId": userId, "success": true}
+	if fingerprint != "" {
+		filter["fingerprint"] = fingerprint
+	}
+	n, err := r.collection().CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (r *MongoLoginEventRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ExportName and ExportByUser put the whole login history in the account
+// export.
+func (r *MongoLoginEventRepository) ExportName() string {
+	return "login-history.json"
+}
+
+func (r *MongoLoginEventRepository) ExportByUser(userId primitive.ObjectID) (interface{}, error) {
+	events, _, err := r.List(userId, 0, 0)
+	return events, err
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Login methods recorded in the history. Logins completed with a second
+	// factor get LoginMethodTOTPSuffix appended, e.g. "password+totp".
+	LoginMethodPassword   = "password"
+	LoginMethodWebAuthn   = "webauthn"
+	LoginMethodMagicLink  = "magic-link"
+	LoginMethodOIDC       = "oidc" // followed by ":<provider>"
+	LoginMethodTOTPSuffix = "+totp"
+
+	LoginHistoryRetention   = 365 * 24 * time.Hour
+	DefaultLoginHistorySize = 20
+	MaxLoginHistorySize     = 100
+)
+
+// --- LOGIN HISTORY ---
+
+// recordLogin appends an attempt to the user's history; failure is nil for
+// a successful login. A success from a device and network the user never
+// logged in from before triggers an alert email, except on the very first
+// login of the account. Errors are only logged so they never block a login.
+func (s *AuthService) recordLogin(user *User, client ClientInfo, method string, failure error) {
+	now := time.Now()
+	userAgent := clipUserAgent(client.UserAgent)
+	device := deviceName(userAgent)
+	event := &LoginEvent{
+		ID:          utils.NewObjectID(),
+		UserID:      user.ID,
+		Success:     failure == nil,
+		Method:      method,
+		IP:          client.IP,
+		UserAgent:   userAgent,
+		DeviceName:  device,
+		Fingerprint: utils.HashToken(device + "|" + client.IP),
+		CreatedAt:   now,
+	}
+	if failure != nil {
+		event.Reason = loginFailureReason(failure)
+	} else {
+		event.NewDevice = s.isNewDevice(user.ID, event.Fingerprint)
+	}
+
+	if err := s.events.Append(event); err != nil {
+		logrus.Errorf("Failed to record login of %s: %v", user.Username, err)
+	}
+
+	if event.NewDevice {
+		err := s.sendTemplate(user, mail.TemplateNewLogin, event.ID.Hex(), mail.Data{
+			"Device": event.DeviceName,
+			"IP":     event.IP,
+			"Time":   formatForUser(user, now),
+		})
+		if err != nil {
+			logrus.Errorf("Failed to send new login alert to %s: %v", user.Email, err)
+		}
+	}
+}
+
+// recordLoginFailure is recordLogin for a username that may not exist.
+// Attempts on unknown usernames are not kept.
+func (s *AuthService) recordLoginFailure(username string, client ClientInfo, failure error) {
+	user, err := s.repo.FindByUsername(username)
+	if err != nil {
+		return
+	}
+	s.recordLogin(user, client, loginMethod(user), failure)
+}
+
+func (s *AuthService) isNewDevice(userId primitive.ObjectID, fingerprint string) bool {
+	seen, err := s.events.HasSucceeded(userId, fingerprint)
+	if err != nil || seen {
+		return false
+	}
+	loggedInBefore, err := s.events.HasSucceeded(userId, "")
+	return err == nil && loggedInBefore
+}
+
+// LoginHistory returns a page of the user's login attempts, newest first.
+func (s *AuthService) LoginHistory(userId primitive.ObjectID, page, limit int64) (*LoginHistoryPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = DefaultLoginHistorySize
+	}
+	if limit > MaxLoginHistorySize {
+		limit = MaxLoginHistorySize
+	}
+
+	events, total, err := s.events.List(userId, (page-1)*limit, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginHistoryPage{Events: events, Total: total, Page: page, Limit: limit}, nil
+}
+
+// loginMethod is how a password login of user was checked.
+func loginMethod(user *User) string {
+	if user.AuthSource != "" {
+		return user.AuthSource
+	}
+	return LoginMethodPassword
+}
+
+func loginFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		return "invalid_credentials"
+	case errors.Is(err, ErrAccountLocked), errors.Is(err, ErrLoginThrottled):
+		return "locked"
+	case errors.Is(err, ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, ErrEmailNotVerified):
+		return "email_not_verified"
+	case errors.Is(err, ErrInvalidMFACode):
+		return "invalid_mfa_code"
+	case errors.Is(err, ErrMFALocked):
+		return "mfa_locked"
+	case errors.Is(err, ErrInvalidMagicLink):
+		return "invalid_magic_link"
+	default:
+		return "error"
+	}
+}
+
+// formatForUser shows t in the user's timezone, or UTC if they have none.
+func formatForUser(user *User, t time.Time) string {
+	loc := time.UTC
+	if user.Timezone != "" {
+		if l, err := time.LoadLocation(user.Timezone); err == nil {
+			loc = l
+		}
+	}
+	return t.In(loc).Format("2006-01-02 15:04 MST")
+}
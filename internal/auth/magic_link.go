@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PurposeMagicLink = "magic-link"
+
+	// MagicLinkTTL is how long an emailed login link stays valid. A new link
+	// can be requested at most once per MagicLinkRequestInterval and replaces
+	// the previous one.
+	MagicLinkTTL             = 15 * time.Minute
+	MagicLinkRequestInterval = time.Minute
+)
+
+var ErrInvalidMagicLink = errors.New("invalid, used or expired login link")
+
+// --- MAGIC LINK ---
+
+// RequestMagicLink emails a single-use login link to the account with the
+// given email. It says nothing about whether there is one: unknown
+// addresses, accounts that could not log in anyway and requests that come
+// too soon after the last link or while logins are locked are dropped
+// silently.
+func (s *AuthService) RequestMagicLink(req MagicLinkRequest) error {
+	user, err := s.repo.FindByEmail(strings.TrimSpace(req.Email))
+	if err != nil {
+		return nil
+	}
+	// directory accounts log in with the directory password only
+	if user.Disabled || !user.EmailVerified || user.AuthSource != "" {
+		return nil
+	}
+
+	now := time.Now()
+	if err := s.throttle.Check(user.Username, req.IP, now); err != nil {
+		return nil
+	}
+	if existing, err := s.magicLinks.FindActive(user.ID, now); err == nil && now.Sub(existing.CreatedAt) < MagicLinkRequestInterval {
+		return nil
+	}
+
+	// The signed token carries the user and expiry; the stored hash of its
+	// random value makes it single-use.
+	secret, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	token, err := utils.GenerateActionToken(PurposeMagicLink, user.ID.Hex(), secret, MagicLinkTTL)
+	if err != nil {
+		return err
+	}
+	tokenHash := utils.HashToken(secret)
+	if err := s.magicLinks.Replace(&MagicLink{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(MagicLinkTTL),
+	}); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api%s/magic-link/consume?token=%s", utils.PublicURL(), BasePath, url.QueryEscape(token))
+	return s.sendTemplate(user, mail.TemplateMagicLink, tokenHash, mail.Data{
+		"Link":    link,
+		"Minutes": int(MagicLinkTTL.Minutes()),
+	})
+}
+
+// MagicLinkFrontendURL is the frontend page that logs in with the token,
+// MAGIC_LINK_FRONTEND_URL or by default PUBLIC_URL/magic-link.
+func MagicLinkFrontendURL(token string) string {
+	target := utils.GetEnv("MAGIC_LINK_FRONTEND_URL", utils.PublicURL()+"/magic-link")
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+	return target + sep + url.Values{"token": {token}}.Encode()
+}
+
+// LoginMagicLink exchanges a login link for tokens, or for an MFA challenge
+// when the user has two-factor authentication on.
+func (s *AuthService) LoginMagicLink(req MagicLinkLoginRequest) (*LoginResult, error) {
+	claims, err := utils.ParseActionToken(PurposeMagicLink, req.Token)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+	userId, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	now := time.Now()
+	client := ClientInfo{IP: req.IP, UserAgent: req.UserAgent}
+	if err := s.throttle.Check(user.Username, req.IP, now); err != nil {
+		s.recordLogin(user, client, LoginMethodMagicLink, err)
+		return nil, err
+	}
+
+	// a link that was replaced or already used is not found or does not match
+	tokenHash := utils.HashToken(claims.Value)
+	link, err := s.magicLinks.FindActive(user.ID, now)
+	if err != nil || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(link.TokenHash)) != 1 {
+		s.recordLogin(user, client, LoginMethodMagicLink, ErrInvalidMagicLink)
+		return nil, ErrInvalidMagicLink
+	}
+	// The account may have changed since the link was sent; check before the
+	// link is used up.
+	if !user.EmailVerified || user.AuthSource != "" {
+		s.recordLogin(user, client, LoginMethodMagicLink, ErrInvalidMagicLink)
+		return nil, ErrInvalidMagicLink
+	}
+	if user.Disabled {
+		s.recordLogin(user, client, LoginMethodMagicLink, ErrAccountDisabled)
+		return nil, ErrAccountDisabled
+	}
+	consumed, err := s.magicLinks.Consume(link.ID, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		s.recordLogin(user, client, LoginMethodMagicLink, ErrInvalidMagicLink)
+		return nil, ErrInvalidMagicLink
+	}
+
+	if user.TOTPEnabled {
+		return s.newMFAChallenge(user, LoginMethodMagicLink)
+	}
+
+	tokens, err := s.completeLogin(user, client, LoginMethodMagicLink)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
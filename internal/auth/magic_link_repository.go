@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MagicLinkRepository interface {
+	Replace(link *MagicLink) error
+	FindActive(userId primitive.ObjectID, now time.Time) (*MagicLink, error)
+	Consume(id primitive.ObjectID, tokenHash string) (bool, error)
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoMagicLinkRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoMagicLinkRepository(client *mongo.Client) *MongoMagicLinkRepository {
+	return &MongoMagicLinkRepository{client: client}
+}
+
+func (r *MongoMagicLinkRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("magicLinks")
+}
+
+// EnsureIndexes keeps one link per user and lets Mongo expire old links.
+func (r *MongoMagicLinkRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Replace stores link as the user's only login link, so requesting a new
+// one invalidates the last. Callers leave link.ID unset.
+func (r *MongoMagicLinkRepository) Replace(link *MagicLink) error {
+	_, err := r.collection().ReplaceOne(context.TODO(),
+		bson.M{"userId": link.UserID}, link, options.Replace().SetUpsert(true))
+	return err
+}
+
+// FindActive ignores expired links the TTL monitor has not removed yet.
+func (r *MongoMagicLinkRepository) FindActive(userId primitive.ObjectID, now time.Time) (*MagicLink, error) {
+	var link MagicLink
+	err := r.collection().FindOne(context.TODO(),
+		bson.M{"userId": userId, "expiresAt": bson.M{"$gt": now}}).Decode(&link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Consume deletes the link. It reports false if it was already gone, so a
+// link logs in at most once even under concurrent requests.
+func (r *MongoMagicLinkRepository) Consume(id primitive.ObjectID, tokenHash string) (bool, error) {
+	res, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": id, "tokenHash": tokenHash})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+func (r *MongoMagicLinkRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PurposeMFA         = "mfa"
+	MFAChallengeTTL    = 5 * time.Minute
+	RecoveryCodeCount  = 10
+	MaxMFAFailures     = 5
+	MFAFailureCooldown = 15 * time.Minute
+)
+
+var (
+	ErrInvalidMFAToken    = errors.New("invalid or expired MFA challenge")
+	ErrInvalidMFACode     = errors.New("invalid authentication code")
+	ErrMFALocked          = errors.New("too many failed codes, try again later")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingTOTP      = errors.New("start two-factor setup first")
+)
+
+// --- TWO-FACTOR ENROLLMENT ---
+
+// SetupTOTP generates a new secret and keeps it pending until ConfirmTOTP
+// proves the user's authenticator produces matching codes.
+func (s *AuthService) SetupTOTP(userId primitive.ObjectID) (*TOTPSetupResponse, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetPendingTOTP(user.ID, secret); err != nil {
+		return nil, err
+	}
+
+	issuer := utils.GetEnv("TOTP_ISSUER", "OMHS")
+	return &TOTPSetupResponse{Secret: secret, URI: utils.TOTPURI(secret, issuer, user.Username)}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication and returns the recovery
+// codes. They are only stored hashed, so this is the only time they are shown.
+func (s *AuthService) ConfirmTOTP(userId primitive.ObjectID, req TOTPCodeRequest) (*RecoveryCodesResponse, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, ErrNoPendingTOTP
+	}
+
+	if _, ok := utils.ValidateTOTP(user.TOTPPendingSecret, req.Code, time.Now()); !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = utils.HashToken(code)
+	}
+
+	if err := s.repo.EnableTOTP(user.ID, user.TOTPPendingSecret, hashes); err != nil {
+		return nil, err
+	}
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It requires a current
+// code (or a recovery code) so a stolen access token alone is not enough.
+func (s *AuthService) DisableTOTP(userId primitive.ObjectID, req TOTPCodeRequest) error {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := s.checkSecondFactor(user, req.Code); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(user.ID)
+}
+
+// --- LOGIN SECOND STEP ---
+func (s *AuthService) LoginMFA(req MFALoginRequest) (*TokenPair, error) {
+	claims, err := utils.ParseActionToken(PurposeMFA, req.MFAToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	userId, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.repo.FindByID(userId)
+	if err != nil || !user.TOTPEnabled {
+		return nil, ErrInvalidMFAToken
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	// the challenge carries how the first factor was checked
+	client := ClientInfo{IP: req.IP, UserAgent: req.UserAgent}
+	method := claims.Value
+	if method == "" {
+		method = LoginMethodPassword
+	}
+	method += LoginMethodTOTPSuffix
+
+	if err := s.checkSecondFactor(user, req.Code); err != nil {
+		s.recordLogin(user, client, method, err)
+		return nil, err
+	}
+
+	return s.completeLogin(user, client, method)
+}
+
+func (s *AuthService) newMFAChallenge(user *User, method string) (*LoginResult, error) {
+	token, err := utils.GenerateActionToken(PurposeMFA, user.ID.Hex(), method, MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{MFARequired: true, MFAToken: token}, nil
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+// Failures are counted on the user, since a fresh challenge is only a
+// password away and must not reset the attempt budget. Every attempt is
+// counted before the code is checked and cleared again if it was right.
+func (s *AuthService) checkSecondFactor(user *User, code string) error {
+	claimed, err := s.repo.ClaimMFAAttempt(user.ID, time.Now(), MaxMFAFailures, MFAFailureCooldown)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrMFALocked
+	}
+
+	ok, err := s.verifySecondFactor(user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return s.repo.ResetMFAFailures(user.ID)
+}
+
+func (s *AuthService) verifySecondFactor(user *User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if counter, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return s.repo.ClaimTOTPCounter(user.ID, counter)
+	}
+
+	return s.repo.ConsumeRecoveryCode(user.ID, utils.HashToken(strings.ToLower(code)))
+}
@@ -3,6 +3,9 @@ package auth
 import (
 	"time"
 
+	"omhs-backend/internal/utils"
+	"omhs-backend/internal/webauthn"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -10,25 +13,367 @@ import (
 type User struct {
 	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Username           string             `bson:"username" json:"username"`
-	Password           string             `bson:"password" json:"password"`
+	Password           string             `bson:"password" json:"-"`
+	PasswordHistory    []string           `bson:"passwordHistory,omitempty" json:"-"`
 	Email              string             `bson:"email" json:"email"`
+	EmailVerified      bool               `bson:"emailVerified" json:"emailVerified"`
+	PendingEmail       string             `bson:"pendingEmail,omitempty" json:"pendingEmail,omitempty"`
+	DisplayName        string             `bson:"displayName,omitempty" json:"displayName,omitempty"`
+	Locale             string             `bson:"locale,omitempty" json:"locale,omitempty"`
+	Timezone           string             `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	VerificationSentAt time.Time          `bson:"verificationSentAt,omitempty" json:"verificationSentAt"`
 	IsAdmin            bool               `bson:"isAdmin" json:"isAdmin"`
+	Roles              []string           `bson:"roles,omitempty" json:"roles,omitempty"`
+	Disabled           bool               `bson:"disabled" json:"disabled"`
 	LastLogin          time.Time          `bson:"lastLogin" json:"lastLogin"`
-	Passkey            string             `bson:"passkey" json:"passkey"`
-	PasskeyGeneratedAt time.Time          `bson:"passkeyGeneratedAt" json:"passkeyGeneratedAt"`
+
+	// AuthSource names the Authenticator that owns the password, e.g. "ldap"
+	// for directory accounts; empty for local passwords. ExternalID is the
+	// account's id there.
+	AuthSource string `bson:"authSource,omitempty" json:"authSource,omitempty"`
+	ExternalID string `bson:"externalId,omitempty" json:"-"`
+
+	// DeletionScheduledAt is when the account and its data are purged; set
+	// by a deletion request and cleared if it is cancelled in time.
+	DeletionScheduledAt *time.Time `bson:"deletionScheduledAt,omitempty" json:"deletionScheduledAt,omitempty"`
+
+	// Two-factor authentication. Secrets and recovery code hashes never leave the server.
+	TOTPEnabled       bool      `bson:"totpEnabled" json:"totpEnabled"`
+	TOTPSecret        string    `bson:"totpSecret,omitempty" json:"-"`
+	TOTPPendingSecret string    `bson:"totpPendingSecret,omitempty" json:"-"`
+	TOTPLastCounter   int64     `bson:"totpLastCounter,omitempty" json:"-"`
+	RecoveryCodes     []string  `bson:"recoveryCodes,omitempty" json:"-"`
+	MFAFailures       int       `bson:"mfaFailures,omitempty" json:"-"`
+	MFAFailedAt       time.Time `bson:"mfaFailedAt,omitempty" json:"-"`
+}
+
+// EffectiveRoles returns the roles to put in the user's access tokens.
+// Every account has RoleUser; IsAdmin remains the source of truth for RoleAdmin.
+func (u *User) EffectiveRoles() []string {
+	roles := []string{utils.RoleUser}
+	if u.IsAdmin {
+		roles = append(roles, utils.RoleAdmin)
+	}
+	for _, role := range u.Roles {
+		if role != utils.RoleUser && role != utils.RoleAdmin {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// PublicUser is what a user sees of their own account. Anything secret or
+// internal stays on User.
+type PublicUser struct {
+	ID            primitive.ObjectID `json:"id"`
+	Username      string             `json:"username"`
+	Email         string             `json:"email"`
+	EmailVerified bool               `json:"emailVerified"`
+	PendingEmail  string             `json:"pendingEmail,omitempty"`
+	DisplayName   string             `json:"displayName"`
+	Locale        string             `json:"locale"`
+	Timezone      string             `json:"timezone"`
+	Roles         []string           `json:"roles"`
+	TOTPEnabled   bool               `json:"totpEnabled"`
+	LastLogin     time.Time          `json:"lastLogin"`
+	AuthSource    string             `json:"authSource,omitempty"`
+
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+}
+
+func (u *User) Public() *PublicUser {
+	return &PublicUser{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		PendingEmail:  u.PendingEmail,
+		DisplayName:   u.DisplayName,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+		Roles:         u.EffectiveRoles(),
+		TOTPEnabled:   u.TOTPEnabled,
+		LastLogin:     u.LastLogin,
+		AuthSource:    u.AuthSource,
+
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+}
+
+// RefreshToken is a single link in a rotating refresh token chain.
+// Every token issued from one login shares the same FamilyID, which is the
+// id of the login's Session.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	FamilyID  string             `bson:"familyId"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	RotatedAt *time.Time         `bson:"rotatedAt,omitempty"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty"`
+}
+
+// Session is one login of a user, kept until it is revoked or its refresh
+// tokens expire. Access tokens carry its id, so deleting it logs them out.
+type Session struct {
+	ID         string             `bson:"_id" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"-"`
+	IP         string             `bson:"ip" json:"ip"`
+	UserAgent  string             `bson:"userAgent" json:"userAgent"`
+	DeviceName string             `bson:"deviceName" json:"deviceName"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+
+	// Current marks the caller's own session in listings.
+	Current bool `bson:"-" json:"current"`
+}
+
+// LoginEvent is one entry of a user's append-only login history.
+// Fingerprint identifies the device and network it came from.
+type LoginEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"userId" json:"-"`
+	Success     bool               `bson:"success" json:"success"`
+	Method      string             `bson:"method" json:"method"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	IP          string             `bson:"ip" json:"ip"`
+	UserAgent   string             `bson:"userAgent" json:"userAgent"`
+	DeviceName  string             `bson:"deviceName" json:"deviceName"`
+	Fingerprint string             `bson:"fingerprint" json:"-"`
+	NewDevice   bool               `bson:"newDevice,omitempty" json:"newDevice,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Invite lets one person register, with the email it was sent to, while
+// registration is otherwise closed. Only a hash of the code is stored.
+type Invite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email     string             `bson:"email" json:"email"`
+	Role      string             `bson:"role,omitempty" json:"role,omitempty"`
+	CodeHash  string             `bson:"codeHash" json:"-"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	UsedBy    primitive.ObjectID `bson:"usedBy,omitempty" json:"-"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+type LoginHistoryPage struct {
+	Events []LoginEvent `json:"events"`
+	Total  int64        `json:"total"`
+	Page   int64        `json:"page"`
+	Limit  int64        `json:"limit"`
+}
+
+// AuditEntry records something an admin did as another user: starting an
+// impersonation, or a request made with an impersonation token. Method,
+// Path and Status are only set for requests.
+type AuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Action    string             `bson:"action" json:"action"`
+	ActorID   primitive.ObjectID `bson:"actorId" json:"actorId"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Method    string             `bson:"method,omitempty" json:"method,omitempty"`
+	Path      string             `bson:"path,omitempty" json:"path,omitempty"`
+	Status    int                `bson:"status,omitempty" json:"status,omitempty"`
+	IP        string             `bson:"ip" json:"ip"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type AuditLogPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int64        `json:"total"`
+	Page    int64        `json:"page"`
+	Limit   int64        `json:"limit"`
+}
+
+// ImpersonationToken is an access token for acting as User. There is no
+// refresh token; a new one has to be requested once it expires.
+type ImpersonationToken struct {
+	AccessToken string      `json:"token"`
+	ExpiresAt   time.Time   `json:"expiresAt"`
+	User        *PublicUser `json:"user"`
+}
+
+// ClientInfo is where a login comes from, as seen by the controller.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// ResetToken is an outstanding password reset passkey. Only a hash is
+// stored; Mongo removes the document once ExpiresAt has passed.
+type ResetToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	TokenHash string             `bson:"tokenHash"`
+	Attempts  int                `bson:"attempts"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// MagicLink is the outstanding passwordless login link of a user. The
+// link itself is a signed token; only a hash of the random value it carries
+// is stored, and Mongo removes the document once ExpiresAt has passed.
+type MagicLink struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// LoginAttempt counts recent failed logins for one key, "user:<name>" or
+// "ip:<address>". Mongo removes it once ExpiresAt has passed.
+type LoginAttempt struct {
+	ID            string     `bson:"_id"`
+	Failures      int        `bson:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty"`
+	ExpiresAt     time.Time  `bson:"expiresAt"`
+}
+
+// TokenPair is returned by Login and Refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LoginResult is either a token pair or, when the account has two-factor
+// authentication enabled, a short-lived MFA challenge to complete first.
+type LoginResult struct {
+	*TokenPair
+	MFARequired bool   `json:"mfaRequired,omitempty"`
+	MFAToken    string `json:"mfaToken,omitempty"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// PersonalAccessToken lets scripts call the API without a password. Only a
+// hash of the token is stored; the token itself is shown once on creation.
+type PersonalAccessToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	TokenHash  string             `bson:"tokenHash" json:"-"`
+	Hint       string             `bson:"hint" json:"hint"` // last characters, to tell tokens apart
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// CreatedPersonalAccessToken is the only response that contains the token.
+type CreatedPersonalAccessToken struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// WebAuthnCredential is a registered security key or platform authenticator.
+type WebAuthnCredential struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"userId" json:"-"`
+	Name         string             `bson:"name" json:"name"`
+	CredentialID []byte             `bson:"credentialId" json:"-"`
+	PublicKey    []byte             `bson:"publicKey" json:"-"`
+	SignCount    uint32             `bson:"signCount" json:"-"`
+	AAGUID       []byte             `bson:"aaguid" json:"-"`
+	Transports   []string           `bson:"transports,omitempty" json:"transports,omitempty"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	LastUsedAt   time.Time          `bson:"lastUsedAt,omitempty" json:"lastUsedAt"`
+}
+
+// WebAuthnSession holds a ceremony challenge between its begin and finish calls.
+type WebAuthnSession struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId,omitempty"`
+	Purpose   string             `bson:"purpose"`
+	Challenge []byte             `bson:"challenge"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+type WebAuthnBeginResponse struct {
+	SessionID string      `json:"sessionId"`
+	PublicKey interface{} `json:"publicKey"`
+}
+
+// OIDCIdentity links an account to a subject at an external identity
+// provider. The subject is the provider's stable user id; the email is only
+// what it reported when the link was made.
+type OIDCIdentity struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"userId" json:"-"`
+	Provider    string             `bson:"provider" json:"provider"`
+	Subject     string             `bson:"subject" json:"-"`
+	Email       string             `bson:"email,omitempty" json:"email,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	LastLoginAt time.Time          `bson:"lastLoginAt,omitempty" json:"lastLoginAt"`
+}
+
+// OIDCState remembers a login between the redirect to the provider and the
+// callback. It is keyed by a hash of the state parameter; BindingHash is
+// the hash of the cookie set in the browser that started the login.
+type OIDCState struct {
+	ID          string    `bson:"_id"`
+	Provider    string    `bson:"provider"`
+	Verifier    string    `bson:"verifier"`
+	Nonce       string    `bson:"nonce"`
+	BindingHash string    `bson:"bindingHash"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+// OIDCHandoff carries a finished provider login from the callback to the
+// frontend, which exchanges the one-time code for tokens. It is keyed by a
+// hash of the code.
+type OIDCHandoff struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	Method    string             `bson:"method"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	LoginURL    string `json:"loginUrl"`
 }
 
 // DTOs (request payloads)
 
 type RegisterRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Email      string `json:"email"`
+	Locale     string `json:"locale"`     // optional, e.g. "de"; picks the email language
+	InviteCode string `json:"inviteCode"` // needed unless registration is open to the email
 }
 
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// Filled in by the controller, not the client.
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// CreateInviteRequest invites email; Role presets a role other than the
+// default user role and ExpiresInDays 0 means the invite never expires.
+type CreateInviteRequest struct {
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	ExpiresInDays int    `json:"expiresInDays"`
 }
 
 type ResetPasswordRequest struct {
@@ -42,3 +387,106 @@ type ChangePasswordRequest struct {
 	Passkey     string `json:"passkey"`
 	NewPassword string `json:"newPassword"`
 }
+
+// UpdateProfileRequest is a partial update; nil fields are left alone.
+// Changing the email needs the current password.
+type UpdateProfileRequest struct {
+	DisplayName     *string `json:"displayName"`
+	Locale          *string `json:"locale"`
+	Timezone        *string `json:"timezone"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"currentPassword"`
+
+	IP string `json:"-"`
+}
+
+type ChangeOwnPasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// CreatePersonalAccessTokenRequest mints a token; ExpiresInDays 0 means it
+// never expires.
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type ResendVerificationRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+
+	IP string `json:"-"`
+}
+
+// MagicLinkLoginRequest is bound from the JSON or form body the frontend
+// posts with the token from the emailed link.
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" form:"token"`
+
+	IP        string `json:"-" form:"-"`
+	UserAgent string `json:"-" form:"-"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type WebAuthnRegisterFinishRequest struct {
+	SessionID  string                       `json:"sessionId"`
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	Username string `json:"username"`
+}
+
+// OIDCCallbackRequest is the query the provider redirects back with.
+// Binding is the value of the cookie set when the login started.
+type OIDCCallbackRequest struct {
+	Code  string `form:"code"`
+	State string `form:"state"`
+	Error string `form:"error"`
+
+	Binding string `form:"-"`
+}
+
+// OIDCExchangeRequest trades the code handed to the frontend for tokens.
+type OIDCExchangeRequest struct {
+	Code string `json:"code"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type WebAuthnLoginFinishRequest struct {
+	SessionID  string                     `json:"sessionId"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/oidc"
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCController struct {
+	service *OIDCService
+}
+
+func NewOIDCController(s *OIDCService) *OIDCController {
+	return &OIDCController{service: s}
+}
+
+func (ctr *OIDCController) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, ctr.service.Providers())
+}
+
+// OIDCBindingCookie ties a provider login to the browser that started it.
+const OIDCBindingCookie = "omhs_oidc"
+
+// setBindingCookie sets the cookie for the callback path only; SameSite=Lax
+// still sends it on the provider's top-level redirect back.
+func setBindingCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCBindingCookie, value, maxAge, "/api"+BasePath+"/oidc",
+		"", strings.HasPrefix(utils.PublicURL(), "https://"), true)
+}
+
+// Login sends the browser to the provider; "Sign in with" buttons link here.
+func (ctr *OIDCController) Login(c *gin.Context) {
+	authURL, binding, err := ctr.service.Begin(c.Param("provider"))
+	if err != nil {
+		writeOIDCError(c, err)
+		return
+	}
+
+	setBindingCookie(c, binding, int(OIDCStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback is a top-level browser navigation, so it answers with a redirect
+// to the frontend rather than JSON; the frontend then calls Exchange.
+func (ctr *OIDCController) Callback(c *gin.Context) {
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Redirect(http.StatusFound, ctr.service.FrontendRedirect("", ErrOIDCLogin))
+		return
+	}
+	req.Binding, _ = c.Cookie(OIDCBindingCookie)
+	setBindingCookie(c, "", -1)
+
+	code, err := ctr.service.Callback(c.Param("provider"), req)
+	c.Redirect(http.StatusFound, ctr.service.FrontendRedirect(code, err))
+}
+
+func (ctr *OIDCController) Exchange(c *gin.Context) {
+	var req OIDCExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	result, err := ctr.service.Exchange(req)
+	if err != nil {
+		writeOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctr *OIDCController) ListIdentities(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	identities, err := ctr.service.ListIdentities(userId)
+	if err != nil {
+		writeOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+func (ctr *OIDCController) Unlink(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.Unlink(userId, c.Param("id")); err != nil {
+		writeOIDCError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "identity unlinked"})
+}
+
+func writeOIDCError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrOIDCUnknownProvider), errors.Is(err, ErrIdentityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOIDCState), errors.Is(err, ErrOIDCLogin):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOIDCEmailUnverified), errors.Is(err, ErrAccountDisabled),
+		errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrEmailDomainNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOIDCEmailConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, oidc.ErrDiscovery):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
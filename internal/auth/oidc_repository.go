@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OIDCRepository interface {
+	CreateState(state *OIDCState) error
+	ConsumeState(id, provider string) (*OIDCState, error)
+	CreateHandoff(handoff *OIDCHandoff) error
+	ConsumeHandoff(id string) (*OIDCHandoff, error)
+	CreateIdentity(identity *OIDCIdentity) error
+	FindIdentity(provider, subject string) (*OIDCIdentity, error)
+	ListIdentities(userId primitive.ObjectID) ([]OIDCIdentity, error)
+	TouchIdentity(id primitive.ObjectID, at time.Time) error
+	DeleteIdentity(userId, id primitive.ObjectID) error
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoOIDCRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoOIDCRepository(client *mongo.Client) *MongoOIDCRepository {
+	return &MongoOIDCRepository{client: client}
+}
+
+func (r *MongoOIDCRepository) identities() *mongo.Collection {
+	return r.client.Database("users").Collection("oidcIdentities")
+}
+
+func (r *MongoOIDCRepository) states() *mongo.Collection {
+	return r.client.Database("users").Collection("oidcStates")
+}
+
+func (r *MongoOIDCRepository) handoffs() *mongo.Collection {
+	return r.client.Database("users").Collection("oidcHandoffs")
+}
+
+func (r *MongoOIDCRepository) EnsureIndexes() error {
+	_, err := r.identities().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, coll := range []*mongo.Collection{r.states(), r.handoffs()} {
+		_, err = coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MongoOIDCRepository) CreateState(state *OIDCState) error {
+	_, err := r.states().InsertOne(context.TODO(), state)
+	return err
+}
+
+// ConsumeState deletes and returns the state, so a callback can only be
+// completed once. Expired states are treated as missing.
+func (r *MongoOIDCRepository) ConsumeState(id, provider string) (*OIDCState, error) {
+	var state OIDCState
+	err := r.states().FindOneAndDelete(context.TODO(),
+		bson.M{"_id": id, "provider": provider, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (r *MongoOIDCRepository) CreateHandoff(handoff *OIDCHandoff) error {
+	_, err := r.handoffs().InsertOne(context.TODO(), handoff)
+	return err
+}
+
+// ConsumeHandoff deletes and returns the handoff, so its code works once.
+func (r *MongoOIDCRepository) ConsumeHandoff(id string) (*OIDCHandoff, error) {
+	var handoff OIDCHandoff
+	err := r.handoffs().FindOneAndDelete(context.TODO(),
+		bson.M{"_id": id, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&handoff)
+	if err != nil {
+		return nil, err
+	}
+	return &handoff, nil
+}
+
+func (r *MongoOIDCRepository) CreateIdentity(identity *OIDCIdentity) error {
+	_, err := r.identities().InsertOne(context.TODO(), identity)
+	return err
+}
+
+func (r *MongoOIDCRepository) FindIdentity(provider, subject string) (*OIDCIdentity, error) {
+	var identity OIDCIdentity
+	err := r.identities().FindOne(context.TODO(), bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *MongoOIDCRepository) ListIdentities(userId primitive.ObjectID) ([]OIDCIdentity, error) {
+	cursor, err := r.identities().Find(context.TODO(), bson.M{"userId": userId},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	identities := []OIDCIdentity{}
+	if err := cursor.All(context.TODO(), &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *MongoOIDCRepository) TouchIdentity(id primitive.ObjectID, at time.Time) error {
+	_, err := r.identities().UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{"lastLoginAt": at}})
+	return err
+}
+
+func (r *MongoOIDCRepository) DeleteIdentity(userId, id primitive.ObjectID) error {
+	res, err := r.identities().DeleteOne(context.TODO(), bson.M{"_id": id, "userId": userId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteByUser removes every identity linked to the user, and logins still
+// waiting to be exchanged.
+func (r *MongoOIDCRepository) DeleteByUser(userId primitive.ObjectID) error {
+	if _, err := r.handoffs().DeleteMany(context.TODO(), bson.M{"userId": userId}); err != nil {
+		return err
+	}
+	_, err := r.identities().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ExportName and ExportByUser put the linked provider accounts in the
+// account export.
+func (r *MongoOIDCRepository) ExportName() string {
+	return "oidc-identities.json"
+}
+
+func (r *MongoOIDCRepository) ExportByUser(userId primitive.ObjectID) (interface{}, error) {
+	return r.ListIdentities(userId)
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/oidc"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// OIDCStateTTL is how long a user has to log in at the provider.
+	OIDCStateTTL = 10 * time.Minute
+	// OIDCHandoffTTL is how long the frontend has to exchange the code it
+	// was redirected with.
+	OIDCHandoffTTL = time.Minute
+)
+
+var (
+	ErrOIDCUnknownProvider = errors.New("unknown identity provider")
+	ErrOIDCState           = errors.New("invalid or expired login attempt")
+	ErrOIDCLogin           = errors.New("identity provider login failed")
+	ErrOIDCEmailUnverified = errors.New("identity provider did not confirm a verified email address")
+	ErrOIDCEmailConflict   = errors.New("an account with this email exists but its address is not verified")
+	ErrIdentityNotFound    = errors.New("linked identity not found")
+)
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// OIDCService logs users in through external identity providers. A provider
+// identity is matched by its subject first; failing that it is linked to the
+// account with the same verified email, or a new account is created.
+type OIDCService struct {
+	auth        *AuthService
+	users       UserRepository
+	repo        OIDCRepository
+	providers   map[string]*oidc.Provider
+	order       []string
+	frontendURL string
+}
+
+// NewOIDCService sends browsers back to OIDC_FRONTEND_URL once a provider
+// login is over, by default PUBLIC_URL/login.
+func NewOIDCService(auth *AuthService, users UserRepository, repo OIDCRepository, providers ...*oidc.Provider) *OIDCService {
+	s := &OIDCService{
+		auth:        auth,
+		users:       users,
+		repo:        repo,
+		providers:   map[string]*oidc.Provider{},
+		frontendURL: utils.GetEnv("OIDC_FRONTEND_URL", utils.PublicURL()+"/login"),
+	}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+		s.order = append(s.order, p.Name())
+	}
+	return s
+}
+
+func (s *OIDCService) Providers() []OIDCProviderInfo {
+	list := make([]OIDCProviderInfo, 0, len(s.order))
+	for _, name := range s.order {
+		list = append(list, OIDCProviderInfo{
+			Name:        name,
+			DisplayName: s.providers[name].DisplayName(),
+			LoginURL:    fmt.Sprintf("/api%s/oidc/%s/login", BasePath, name),
+		})
+	}
+	return list
+}
+
+// --- LOGIN ---
+
+// Begin returns the provider URL to send the browser to, and the binding
+// value the browser has to keep in a cookie until the callback. Without it,
+// anyone could have a victim complete a login the attacker started and end
+// up in the attacker's account.
+func (s *OIDCService) Begin(providerName string) (authURL, binding string, err error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrOIDCUnknownProvider
+	}
+
+	state, err := utils.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	binding, err = utils.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err = provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	err = s.repo.CreateState(&OIDCState{
+		ID:          utils.HashToken(state),
+		Provider:    providerName,
+		Verifier:    verifier,
+		Nonce:       nonce,
+		BindingHash: utils.HashToken(binding),
+		ExpiresAt:   time.Now().Add(OIDCStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, binding, nil
+}
+
+// Callback checks the login the provider redirected back from and returns a
+// one-time code for the frontend to exchange for tokens.
+func (s *OIDCService) Callback(providerName string, req OIDCCallbackRequest) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrOIDCUnknownProvider
+	}
+	if req.State == "" || req.Binding == "" {
+		return "", ErrOIDCState
+	}
+	state, err := s.repo.ConsumeState(utils.HashToken(req.State), providerName)
+	if err != nil {
+		return "", ErrOIDCState
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(req.Binding)), []byte(state.BindingHash)) != 1 {
+		return "", ErrOIDCState
+	}
+	if req.Error != "" || req.Code == "" {
+		return "", ErrOIDCLogin
+	}
+
+	claims, err := provider.Exchange(req.Code, state.Verifier, state.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrDiscovery) {
+			return "", err
+		}
+		logrus.Warnf("OIDC login with %s failed: %v", providerName, err)
+		return "", ErrOIDCLogin
+	}
+
+	user, err := s.resolveUser(providerName, claims)
+	if err != nil {
+		return "", err
+	}
+	if user.Disabled {
+		return "", ErrAccountDisabled
+	}
+
+	code, err := utils.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.CreateHandoff(&OIDCHandoff{
+		ID:        utils.HashToken(code),
+		UserID:    user.ID,
+		Method:    LoginMethodOIDC + ":" + providerName,
+		ExpiresAt: time.Now().Add(OIDCHandoffTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Exchange completes a provider login for the frontend holding its code.
+// Accounts with two-factor authentication still get the usual MFA challenge.
+func (s *OIDCService) Exchange(req OIDCExchangeRequest) (*LoginResult, error) {
+	if req.Code == "" {
+		return nil, ErrOIDCState
+	}
+	handoff, err := s.repo.ConsumeHandoff(utils.HashToken(req.Code))
+	if err != nil {
+		return nil, ErrOIDCState
+	}
+	user, err := s.users.FindByID(handoff.UserID)
+	if err != nil {
+		return nil, ErrOIDCLogin
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if user.TOTPEnabled {
+		return s.auth.newMFAChallenge(user, handoff.Method)
+	}
+
+	tokens, err := s.auth.completeLogin(user, ClientInfo{IP: req.IP, UserAgent: req.UserAgent}, handoff.Method)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// FrontendRedirect is where the callback sends the browser: the frontend
+// URL with either the one-time code or an error code as query parameter.
+func (s *OIDCService) FrontendRedirect(code string, err error) string {
+	query := url.Values{}
+	if err != nil {
+		query.Set("error", oidcErrorCode(err))
+	} else {
+		query.Set("code", code)
+	}
+	sep := "?"
+	if strings.Contains(s.frontendURL, "?") {
+		sep = "&"
+	}
+	return s.frontendURL + sep + query.Encode()
+}
+
+// oidcErrorCode names a callback failure for the frontend.
+func oidcErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrOIDCUnknownProvider):
+		return "unknown_provider"
+	case errors.Is(err, ErrOIDCState):
+		return "invalid_state"
+	case errors.Is(err, ErrOIDCEmailUnverified):
+		return "email_unverified"
+	case errors.Is(err, ErrOIDCEmailConflict):
+		return "email_conflict"
+	case errors.Is(err, ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrEmailDomainNotAllowed):
+		return "registration_closed"
+	case errors.Is(err, oidc.ErrDiscovery):
+		return "provider_unavailable"
+	case errors.Is(err, ErrOIDCLogin):
+		return "login_failed"
+	default:
+		logrus.Errorf("OIDC callback failed: %v", err)
+		return "server_error"
+	}
+}
+
+// resolveUser finds or creates the account for a provider identity. Only a
+// locally verified address is linked to; otherwise whoever registered it
+// unverified would gain an account the provider's user logs into.
+func (s *OIDCService) resolveUser(providerName string, claims *oidc.Claims) (*User, error) {
+	now := time.Now()
+
+	identity, err := s.repo.FindIdentity(providerName, claims.Subject)
+	if err == nil {
+		if err := s.repo.TouchIdentity(identity.ID, now); err != nil {
+			logrus.Errorf("Failed to update identity %s: %v", identity.ID.Hex(), err)
+		}
+		user, err := s.users.FindByID(identity.UserID)
+		if err != nil {
+			return nil, ErrOIDCLogin
+		}
+		return user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !bool(claims.EmailVerified) {
+		return nil, ErrOIDCEmailUnverified
+	}
+
+	user, err := s.users.FindByEmail(email)
+	switch {
+	case err == nil && !user.EmailVerified:
+		return nil, ErrOIDCEmailConflict
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if user, err = s.provisionUser(claims, email); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	identity = &OIDCIdentity{
+		ID:          utils.NewObjectID(),
+		UserID:      user.ID,
+		Provider:    providerName,
+		Subject:     claims.Subject,
+		Email:       email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	}
+	if err := s.repo.CreateIdentity(identity); err != nil {
+		// a concurrent callback linked it first
+		if mongo.IsDuplicateKeyError(err) {
+			return s.resolveUser(providerName, claims)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionUser creates an account for a first-time provider login. It has
+// no password; one can be set through the reset flow.
+func (s *OIDCService) provisionUser(claims *oidc.Claims, email string) (*User, error) {
+	if err := s.auth.canProvision(email); err != nil {
+		return nil, err
+	}
+	username, err := s.freeUsername(claims.PreferredUsername, email)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:            utils.NewObjectID(),
+		Username:      username,
+		Email:         email,
+		EmailVerified: true,
+		DisplayName:   clipDisplayName(claims.Name),
+		LastLogin:     time.Now(),
+	}
+	if err := s.users.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// freeUsername derives a username from the provider's preferred username or
+// the email's local part, adding a random suffix until it is unused.
+func (s *OIDCService) freeUsername(preferred, email string) (string, error) {
+	base := preferred
+	if base == "" || strings.Contains(base, "@") {
+		base = email[:max(strings.LastIndex(email, "@"), 0)]
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(strings.ToLower(base), ""), "._-")
+	if len(base) > 32 {
+		base = base[:32]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		if _, err := s.users.FindByUsername(candidate); errors.Is(err, mongo.ErrNoDocuments) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		suffix, err := utils.GenerateToken()
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + suffix[:6]
+	}
+	return "", errors.New("could not find a free username")
+}
+
+// --- LINKED IDENTITIES ---
+func (s *OIDCService) ListIdentities(userId primitive.ObjectID) ([]OIDCIdentity, error) {
+	return s.repo.ListIdentities(userId)
+}
+
+func (s *OIDCService) Unlink(userId primitive.ObjectID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrIdentityNotFound
+	}
+	if err := s.repo.DeleteIdentity(userId, objID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	MaxPersonalAccessTokens = 50
+	MaxTokenExpiryDays      = 366
+	// LastUsedResolution is how precisely LastUsedAt follows actual use.
+	LastUsedResolution = time.Minute
+)
+
+var (
+	ErrInvalidTokenName   = errors.New("token name must be 1 to 64 characters")
+	ErrInvalidScope       = errors.New("unknown or missing scope")
+	ErrInvalidExpiry      = errors.New("expiresInDays must be between 0 and 366")
+	ErrTooManyTokens      = errors.New("too many personal access tokens")
+	ErrTokenNotFound      = errors.New("personal access token not found")
+	ErrInvalidAccessToken = errors.New("invalid, expired or revoked access token")
+)
+
+// --- PERSONAL ACCESS TOKENS ---
+
+// CreatePersonalAccessToken mints a token for userId. The returned value is
+// the only time the plaintext token is available.
+//
+// The cap is checked again after the insert and a token that went over it is
+// deleted, so concurrent requests can never leave more than
+// MaxPersonalAccessTokens active. Requests racing for the last slot may all
+// be refused.
+func (s *AuthService) CreatePersonalAccessToken(userId primitive.ObjectID, req CreatePersonalAccessTokenRequest) (*CreatedPersonalAccessToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > 64 {
+		return nil, ErrInvalidTokenName
+	}
+	if len(req.Scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	for _, scope := range req.Scopes {
+		if !utils.IsKnownScope(scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > MaxTokenExpiryDays {
+		return nil, ErrInvalidExpiry
+	}
+
+	// Revoked and expired tokens stay listed for a while but do not count.
+	now := time.Now()
+	active, err := s.pats.CountActive(userId, now)
+	if err != nil {
+		return nil, err
+	}
+	if active >= MaxPersonalAccessTokens {
+		return nil, ErrTooManyTokens
+	}
+
+	plain, err := utils.GeneratePersonalAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token := PersonalAccessToken{
+		ID:        utils.NewObjectID(),
+		UserID:    userId,
+		Name:      name,
+		TokenHash: utils.HashToken(plain),
+		Hint:      plain[len(plain)-4:],
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+	}
+	if req.ExpiresInDays > 0 {
+		expires := now.Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		token.ExpiresAt = &expires
+	}
+
+	if err := s.pats.Create(&token); err != nil {
+		return nil, err
+	}
+	active, err = s.pats.CountActive(userId, now)
+	if err == nil && active > MaxPersonalAccessTokens {
+		err = ErrTooManyTokens
+	}
+	if err != nil {
+		if delErr := s.pats.Delete(token.ID); delErr != nil {
+			logrus.Errorf("Failed to delete personal access token %s over the limit: %v", token.ID.Hex(), delErr)
+		}
+		return nil, err
+	}
+	return &CreatedPersonalAccessToken{PersonalAccessToken: token, Token: plain}, nil
+}
+
+func (s *AuthService) ListPersonalAccessTokens(userId primitive.ObjectID) ([]PersonalAccessToken, error) {
+	return s.pats.List(userId)
+}
+
+func (s *AuthService) RevokePersonalAccessToken(userId, id primitive.ObjectID) error {
+	err := s.pats.Revoke(userId, id, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// ValidatePersonalAccessToken resolves a token presented to JWTMiddleware.
+// The roles are the user's current ones, so demoting a user also limits
+// their tokens.
+func (s *AuthService) ValidatePersonalAccessToken(plain string) (*middleware.TokenPrincipal, error) {
+	token, err := s.pats.FindByHash(utils.HashToken(plain))
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && now.After(*token.ExpiresAt)) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.repo.FindByID(token.UserID)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	if err := s.pats.TouchLastUsed(token.ID, now, LastUsedResolution); err != nil {
+		logrus.Errorf("Failed to record use of access token %s: %v", token.ID.Hex(), err)
+	}
+
+	return &middleware.TokenPrincipal{
+		UserID:   user.ID,
+		Username: user.Username,
+		Roles:    user.EffectiveRoles(),
+		Scopes:   token.Scopes,
+	}, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PersonalAccessTokenRepository interface {
+	Create(token *PersonalAccessToken) error
+	Delete(id primitive.ObjectID) error
+	FindByHash(tokenHash string) (*PersonalAccessToken, error)
+	List(userId primitive.ObjectID) ([]PersonalAccessToken, error)
+	CountActive(userId primitive.ObjectID, now time.Time) (int64, error)
+	Revoke(userId, id primitive.ObjectID, at time.Time) error
+	TouchLastUsed(id primitive.ObjectID, at time.Time, minInterval time.Duration) error
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoPersonalAccessTokenRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoPersonalAccessTokenRepository(client *mongo.Client) *MongoPersonalAccessTokenRepository {
+	return &MongoPersonalAccessTokenRepository{client: client}
+}
+
+func (r *MongoPersonalAccessTokenRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("personalAccessTokens")
+}
+
+// EnsureIndexes also lets Mongo drop tokens once they have expired.
+func (r *MongoPersonalAccessTokenRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *MongoPersonalAccessTokenRepository) Create(token *PersonalAccessToken) error {
+	_, err := r.collection().InsertOne(context.TODO(), token)
+	return err
+}
+
+func (r *MongoPersonalAccessTokenRepository) Delete(id primitive.ObjectID) error {
+	_, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+func (r *MongoPersonalAccessTokenRepository) FindByHash(tokenHash string) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := r.collection().FindOne(context.TODO(), bson.M{"tokenHash": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// List returns the user's tokens, newest first, revoked ones included.
+func (r *MongoPersonalAccessTokenRepository) List(userId primitive.ObjectID) ([]PersonalAccessToken, error) {
+	cursor, err := r.collection().Find(context.TODO(), bson.M{"userId": userId},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	tokens := []PersonalAccessToken{}
+	if err := cursor.All(context.TODO(), &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CountActive counts the user's tokens that are neither revoked nor expired.
+func (r *MongoPersonalAccessTokenRepository) CountActive(userId primitive.ObjectID, now time.Time) (int64, error) {
+	return r.collection().CountDocuments(context.TODO(), bson.M{
+		"userId":    userId,
+		"revokedAt": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$exists": false}},
+			{"expiresAt": bson.M{"$gt": now}},
+		},
+	})
+}
+
+// Revoke only matches the user's own, still active tokens.
+func (r *MongoPersonalAccessTokenRepository) Revoke(userId, id primitive.ObjectID, at time.Time) error {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "userId": userId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// TouchLastUsed records a use, at most once per minInterval, so busy scripts
+// do not turn every request into a write.
+func (r *MongoPersonalAccessTokenRepository) TouchLastUsed(id primitive.ObjectID, at time.Time, minInterval time.Duration) error {
+	_, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "$or": []bson.M{
+			{"lastUsedAt": bson.M{"$exists": false}},
+			{"lastUsedAt": bson.M{"$lte": at.Add(-minInterval)}},
+		}},
+		bson.M{"$set": bson.M{"lastUsedAt": at}})
+	return err
+}
+
+func (r *MongoPersonalAccessTokenRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ExportName and ExportByUser put the token metadata in the account export;
+// the hashes stay out by the JSON tags.
+func (r *MongoPersonalAccessTokenRepository) ExportName() string {
+	return "personal-access-tokens.json"
+}
+
+func (r *MongoPersonalAccessTokenRepository) ExportByUser(userId primitive.ObjectID) (interface{}, error) {
+	return r.List(userId)
+}
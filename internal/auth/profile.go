@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone names must validate without a system zoneinfo
+	"unicode"
+	"unicode/utf8"
+
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PurposeChangeEmail = "change-email"
+	MaxDisplayNameLen  = 64
+)
+
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvalidDisplayName     = errors.New("display name must be at most 64 printable characters")
+	ErrInvalidTimezone        = errors.New("unknown timezone")
+	ErrInvalidEmail           = errors.New("invalid email address")
+	ErrCurrentPasswordMissing = errors.New("current password is required")
+)
+
+// GetProfile returns the caller's own account.
+func (s *AuthService) GetProfile(userId primitive.ObjectID) (*PublicUser, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return user.Public(), nil
+}
+
+// UpdateProfile applies the non-nil fields of req. A new email is not used
+// until the link sent to it is followed; until then it shows as pendingEmail.
+func (s *AuthService) UpdateProfile(userId primitive.ObjectID, req UpdateProfileRequest) (*PublicUser, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	displayName, locale, timezone := user.DisplayName, user.Locale, user.Timezone
+	if req.DisplayName != nil {
+		displayName = strings.TrimSpace(*req.DisplayName)
+		if !validDisplayName(displayName) {
+			return nil, ErrInvalidDisplayName
+		}
+	}
+	if req.Locale != nil {
+		locale = ""
+		if *req.Locale != "" {
+			locale = mail.MatchLocale(*req.Locale)
+		}
+	}
+	if req.Timezone != nil {
+		timezone = *req.Timezone
+		if timezone == "Local" {
+			return nil, ErrInvalidTimezone
+		}
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, ErrInvalidTimezone
+		}
+	}
+
+	var newEmail string
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		if user.AuthSource != "" {
+			return nil, ErrExternalAccount
+		}
+		addr, err := netmail.ParseAddress(*req.Email)
+		if err != nil || addr.Address != *req.Email {
+			return nil, ErrInvalidEmail
+		}
+		if err := s.checkCurrentPassword(user, req.CurrentPassword, req.IP); err != nil {
+			return nil, err
+		}
+		newEmail = addr.Address
+	}
+
+	if err := s.repo.UpdateProfile(user.ID, displayName, locale, timezone); err != nil {
+		return nil, err
+	}
+	user.DisplayName, user.Locale, user.Timezone = displayName, locale, timezone
+
+	if newEmail != "" {
+		if err := s.repo.SetPendingEmail(user.ID, newEmail); err != nil {
+			return nil, err
+		}
+		user.PendingEmail = newEmail
+		if err := s.sendEmailChange(user); err != nil {
+			logrus.Errorf("Failed to send email change confirmation to %s: %v", newEmail, err)
+		}
+	}
+	return user.Public(), nil
+}
+
+func validDisplayName(name string) bool {
+	if utf8.RuneCountInString(name) > MaxDisplayNameLen {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendEmailChange mails a confirmation link to the pending address. The token
+// is bound to that address, like NewEmailVerificationToken.
+func (s *AuthService) sendEmailChange(user *User) error {
+	token, err := utils.GenerateActionToken(PurposeChangeEmail, user.ID.Hex(), user.PendingEmail, VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api%s/confirm-email?token=%s", utils.PublicURL(), BasePath, url.QueryEscape(token))
+	return s.sendTemplateTo(user, user.PendingEmail, mail.TemplateVerifyEmail, utils.HashToken(token), mail.Data{
+		"Link":  link,
+		"Hours": int(VerificationTokenTTL.Hours()),
+	})
+}
+
+// ConfirmEmailChange makes the pending address the account's email.
+func (s *AuthService) ConfirmEmailChange(token string) error {
+	claims, err := utils.ParseActionToken(PurposeChangeEmail, token)
+	if err != nil {
+		return ErrInvalidVerification
+	}
+
+	userId, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return ErrInvalidVerification
+	}
+
+	changed, err := s.repo.ConfirmEmailChange(userId, claims.Value)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return ErrInvalidVerification
+	}
+	return nil
+}
+
+// ChangeOwnPassword replaces the password of a logged-in user who knows the
+// current one. Every session is revoked and a fresh token pair returned for
+// the caller.
+func (s *AuthService) ChangeOwnPassword(userId primitive.ObjectID, req ChangeOwnPasswordRequest) (*TokenPair, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.AuthSource != "" {
+		return nil, ErrExternalAccount
+	}
+	if err := s.checkCurrentPassword(user, req.CurrentPassword, req.IP); err != nil {
+		return nil, err
+	}
+	if err := s.checkNewPassword("newPassword", req.NewPassword, user); err != nil {
+		return nil, err
+	}
+
+	hash, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.RotatePassword(user.ID, hash, user.Password, s.policy.History); err != nil {
+		return nil, err
+	}
+	if err := s.RevokeUserSessions(user.ID); err != nil {
+		return nil, err
+	}
+	return s.startSession(user, ClientInfo{IP: req.IP, UserAgent: req.UserAgent})
+}
+
+// Reauthenticate checks the current password of a logged-in user before a
+// sensitive change made outside this package, such as deleting the account.
+func (s *AuthService) Reauthenticate(userId primitive.ObjectID, password, ip string) (*User, error) {
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if err := s.checkCurrentPassword(user, password, ip); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkCurrentPassword re-authenticates a logged-in user. Wrong guesses count
+// towards the same lockout as failed logins, so a stolen access token cannot
+// be used to brute-force the password.
+func (s *AuthService) checkCurrentPassword(user *User, password, ip string) error {
+	if password == "" {
+		return ErrCurrentPasswordMissing
+	}
+
+	now := time.Now()
+	if err := s.throttle.Check(user.Username, ip, now); err != nil {
+		return err
+	}
+	ok, err := s.passwordMatches(user, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.loginFailed(user, LoginRequest{Username: user.Username, IP: ip}, now)
+	}
+	if err := s.throttle.Reset(user.Username); err != nil {
+		logrus.Errorf("Failed to reset login failures for %s: %v", user.Username, err)
+	}
+	return nil
+}
+
+// clipDisplayName fits a name from an external provider into the limit
+// users are held to.
+func clipDisplayName(name string) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > MaxDisplayNameLen {
+		name = string([]rune(name)[:MaxDisplayNameLen])
+	}
+	return name
+}
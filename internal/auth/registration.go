@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Registration modes. Whatever the mode, someone holding an invite can
+// register with the email address it was sent to.
+const (
+	RegistrationOpen   = "open"   // anyone can register
+	RegistrationDomain = "domain" // only emails of the allowed domains
+	RegistrationInvite = "invite" // only with an invite
+
+	MaxInviteExpiryDays = 90
+)
+
+var (
+	ErrRegistrationClosed    = errors.New("registration requires an invite")
+	ErrEmailDomainNotAllowed = errors.New("registration is not open to this email domain")
+	ErrInvalidInvite         = errors.New("invalid, used or expired invite code")
+	ErrInviteNotFound        = errors.New("invite not found")
+	ErrInvalidInviteRole     = errors.New("unknown role")
+	ErrInvalidInviteExpiry   = fmt.Errorf("expiresInDays must be between 0 and %d", MaxInviteExpiryDays)
+)
+
+// RegistrationConfig decides who may create an account without an invite.
+type RegistrationConfig struct {
+	Mode           string
+	AllowedDomains []string // lowercase; used in RegistrationDomain mode
+}
+
+// RegistrationConfigFromEnv reads REGISTRATION_MODE ("open", "domain" or
+// "invite", default "open") and the comma-separated
+// REGISTRATION_ALLOWED_DOMAINS, which domain mode requires.
+func RegistrationConfigFromEnv() (RegistrationConfig, error) {
+	cfg := RegistrationConfig{Mode: utils.GetEnv("REGISTRATION_MODE", RegistrationOpen)}
+	for _, domain := range strings.Split(utils.GetEnv("REGISTRATION_ALLOWED_DOMAINS", ""), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, strings.TrimPrefix(domain, "@"))
+		}
+	}
+
+	switch cfg.Mode {
+	case RegistrationOpen, RegistrationInvite:
+	case RegistrationDomain:
+		if len(cfg.AllowedDomains) == 0 {
+			return cfg, errors.New("REGISTRATION_MODE=domain needs REGISTRATION_ALLOWED_DOMAINS")
+		}
+	default:
+		return cfg, fmt.Errorf("unknown REGISTRATION_MODE %q", cfg.Mode)
+	}
+	return cfg, nil
+}
+
+// allows reports whether email may register without an invite.
+func (c RegistrationConfig) allows(email string) error {
+	switch c.Mode {
+	case RegistrationOpen, "":
+		return nil
+	case RegistrationDomain:
+		domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+		for _, allowed := range c.AllowedDomains {
+			if domain == allowed {
+				return nil
+			}
+		}
+		return ErrEmailDomainNotAllowed
+	default:
+		return ErrRegistrationClosed
+	}
+}
+
+// --- REGISTRATION ---
+
+// checkRegistration decides whether email may register. A given invite code
+// has to be valid and meant for email; the invite is returned so Register
+// can consume it.
+func (s *AuthService) checkRegistration(email, inviteCode string) (*Invite, error) {
+	if inviteCode == "" {
+		return nil, s.registration.allows(email)
+	}
+
+	invite, err := s.invites.FindByCodeHash(utils.HashToken(strings.TrimSpace(inviteCode)))
+	if err != nil {
+		return nil, ErrInvalidInvite
+	}
+	now := time.Now()
+	if invite.UsedAt != nil || invite.RevokedAt != nil || (invite.ExpiresAt != nil && now.After(*invite.ExpiresAt)) {
+		return nil, ErrInvalidInvite
+	}
+	if !strings.EqualFold(invite.Email, strings.TrimSpace(email)) {
+		return nil, ErrInvalidInvite
+	}
+	return invite, nil
+}
+
+// canProvision is checkRegistration for accounts created on a first login
+// elsewhere, such as through an OpenID Connect provider.
+func (s *AuthService) canProvision(email string) error {
+	return s.registration.allows(email)
+}
+
+// --- INVITES ---
+
+// CreateInvite emails a single-use invite code to req.Email on behalf of
+// the admin actor.
+func (s *AuthService) CreateInvite(actor primitive.ObjectID, req CreateInviteRequest) (*Invite, error) {
+	addr, err := netmail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	if req.Role != "" && !utils.IsKnownRole(req.Role) {
+		return nil, ErrInvalidInviteRole
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > MaxInviteExpiryDays {
+		return nil, ErrInvalidInviteExpiry
+	}
+	admin, err := s.repo.FindByID(actor)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	code, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		ID:        utils.NewObjectID(),
+		Email:     addr.Address,
+		Role:      req.Role,
+		CodeHash:  utils.HashToken(code),
+		CreatedBy: actor,
+		CreatedAt: now,
+	}
+	if req.Role == utils.RoleUser {
+		invite.Role = ""
+	}
+	if req.ExpiresInDays > 0 {
+		expires := now.Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		invite.ExpiresAt = &expires
+	}
+	if err := s.invites.Create(invite); err != nil {
+		return nil, err
+	}
+
+	// The invitee has no account yet, so there is no locale to pick.
+	msg, err := mail.Render(mail.TemplateInvite, "", mail.Data{
+		"InvitedBy": admin.Username,
+		"Code":      code,
+		"Days":      req.ExpiresInDays,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = mail.Key(mail.TemplateInvite, invite.ID.Hex())
+	msg.To = invite.Email
+	if err := s.mailer.Send(msg); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (s *AuthService) ListInvites() ([]Invite, error) {
+	return s.invites.ListOutstanding(time.Now())
+}
+
+func (s *AuthService) RevokeInvite(id primitive.ObjectID) error {
+	err := s.invites.Revoke(id, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInviteNotFound
+	}
+	return err
+}
+
+// applyInvite gives a user registering with an invite its preset role. The
+// invite reached the address, so the email counts as verified.
+func applyInvite(user *User, invite *Invite) {
+	user.EmailVerified = true
+	switch invite.Role {
+	case "", utils.RoleUser:
+	case utils.RoleAdmin:
+		user.IsAdmin = true
+	default:
+		user.Roles = []string{invite.Role}
+	}
+}
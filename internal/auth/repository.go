@@ -2,21 +2,44 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository interface {
+	FindByID(id primitive.ObjectID) (*User, error)
 	FindByUsername(username string) (*User, error)
 	FindByEmailAndUsername(email, username string) (*User, error)
+	FindByEmail(email string) (*User, error)
 	Create(user *User) error
 	UpdatePassword(id primitive.ObjectID, newHash string) error
-	UpdatePasskey(id primitive.ObjectID, passkey string, at time.Time) error
-	InvalidatePasskey(id primitive.ObjectID) error
+	RotatePassword(id primitive.ObjectID, newHash, oldHash string, keep int) error
 	UpdateLastLogin(id primitive.ObjectID, at time.Time) error
+	List(search string, skip, limit int64) ([]User, int64, error)
+	SetDisabled(id primitive.ObjectID, disabled bool) error
+	SetAdmin(id primitive.ObjectID, isAdmin bool) error
+	MarkEmailVerified(id primitive.ObjectID) error
+	UpdateProfile(id primitive.ObjectID, displayName, locale, timezone string) error
+	SyncExternalUser(id primitive.ObjectID, email, displayName string, isAdmin bool) error
+	SetPendingEmail(id primitive.ObjectID, email string) error
+	ConfirmEmailChange(id primitive.ObjectID, email string) (bool, error)
+	ClaimVerificationSend(id primitive.ObjectID, at time.Time, minInterval time.Duration) (bool, error)
+	SetPendingTOTP(id primitive.ObjectID, secret string) error
+	EnableTOTP(id primitive.ObjectID, secret string, recoveryHashes []string) error
+	DisableTOTP(id primitive.ObjectID) error
+	ClaimTOTPCounter(id primitive.ObjectID, counter int64) (bool, error)
+	ConsumeRecoveryCode(id primitive.ObjectID, codeHash string) (bool, error)
+	ClaimMFAAttempt(id primitive.ObjectID, at time.Time, max int, cooldown time.Duration) (bool, error)
+	ResetMFAFailures(id primitive.ObjectID) error
+	ScheduleDeletion(id primitive.ObjectID, at *time.Time) error
+	FindDueForDeletion(now time.Time, limit int64) ([]User, error)
+	Delete(id primitive.ObjectID) error
 }
 
 type MongoUserRepository struct {
@@ -31,6 +54,12 @@ func (r *MongoUserRepository) collection() *mongo.Collection {
 	return r.client.Database("users").Collection("authentication")
 }
 
+func (r *MongoUserRepository) FindByID(id primitive.ObjectID) (*User, error) {
+	var user User
+	err := r.collection().FindOne(context.TODO(), bson.M{"_id": id}).Decode(&user)
+	return &user, err
+}
+
 func (r *MongoUserRepository) FindByUsername(username string) (*User, error) {
 	var user User
 	err := r.collection().FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
@@ -43,6 +72,16 @@ func (r *MongoUserRepository) FindByEmailAndUsername(email, username string) (*U
 	return &user, err
 }
 
+// FindByEmail matches case-insensitively. Should several accounts share the
+// address, a verified one wins.
+func (r *MongoUserRepository) FindByEmail(email string) (*User, error) {
+	var user User
+	filter := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "emailVerified", Value: -1}, {Key: "_id", Value: 1}})
+	err := r.collection().FindOne(context.TODO(), filter, opts).Decode(&user)
+	return &user, err
+}
+
 func (r *MongoUserRepository) Create(user *User) error {
 	_, err := r.collection().InsertOne(context.TODO(), user)
 	return err
@@ -50,19 +89,20 @@ func (r *MongoUserRepository) Create(user *User) error {
 
 func (r *MongoUserRepository) UpdatePassword(id primitive.ObjectID, newHash string) error {
 	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
-		bson.M{"$set": bson.M{"password": newHash}, "$unset": bson.M{"passkey": "", "passkeyGeneratedAt": ""}})
+		bson.M{"$set": bson.M{"password": newHash}})
 	return err
 }
 
-func (r *MongoUserRepository) UpdatePasskey(id primitive.ObjectID, passkey string, at time.Time) error {
-	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
-		bson.M{"$set": bson.M{"passkey": passkey, "passkeyGeneratedAt": at}})
-	return err
-}
-
-func (r *MongoUserRepository) InvalidatePasskey(id primitive.ObjectID) error {
-	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
-		bson.M{"$set": bson.M{"passkey": "NOT_PASSKEY"}})
+// RotatePassword replaces the password and pushes the old hash onto the
+// history, keeping only the newest keep entries.
+func (r *MongoUserRepository) RotatePassword(id primitive.ObjectID, newHash, oldHash string, keep int) error {
+	update := bson.M{"$set": bson.M{"password": newHash}}
+	if keep > 0 && oldHash != "" {
+		update["$push"] = bson.M{"passwordHistory": bson.M{"$each": []string{oldHash}, "$slice": -keep}}
+	} else if keep <= 0 {
+		update["$unset"] = bson.M{"passwordHistory": ""}
+	}
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id}, update)
 	return err
 }
 
@@ -74,3 +114,459 @@ func (r *MongoUserRepository) UpdateLastLogin(id primitive.ObjectID, at time.Tim
 	)
 	return err
 }
+
+// List pages through users ordered by username. A non-empty search matches
+// username or email case-insensitively.
+func (r *MongoUserRepository) List(search string, skip, limit int64) ([]User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter = bson.M{"$or": []bson.M{{"username": pattern}, {"email": pattern}}}
+	}
+
+	total, err := r.collection().CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}}).SetSkip(skip).SetLimit(limit)
+	cursor, err := r.collection().Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.TODO())
+
+	users := []User{}
+	if err := cursor.All(context.TODO(), &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *MongoUserRepository) SetDisabled(id primitive.ObjectID, disabled bool) error {
+	return r.setField(id, "disabled", disabled)
+}
+
+func (r *MongoUserRepository) SetAdmin(id primitive.ObjectID, isAdmin bool) error {
+	return r.setField(id, "isAdmin", isAdmin)
+}
+
+func (r *MongoUserRepository) MarkEmailVerified(id primitive.ObjectID) error {
+	return r.setField(id, "emailVerified", true)
+}
+
+func (r *MongoUserRepository) UpdateProfile(id primitive.ObjectID, displayName, locale, timezone string) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{"$set": bson.M{"displayName": displayName, "locale": locale, "timezone": timezone}})
+	return err
+}
+
+// SyncExternalUser copies what an external directory says about the user
+// over the local record; the directory is authoritative for these fields.
+func (r *MongoUserRepository) SyncExternalUser(id primitive.ObjectID, email, displayName string, isAdmin bool) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"email":         email,
+		"emailVerified": true,
+		"displayName":   displayName,
+		"isAdmin":       isAdmin,
+	}})
+	return err
+}
+
+func (r *MongoUserRepository) SetPendingEmail(id primitive.ObjectID, email string) error {
+	return r.setField(id, "pendingEmail", email)
+}
+
+// ConfirmEmailChange swaps in the pending address if it is still email, so
+// a link for an address that was replaced by a later change does nothing.
+func (r *MongoUserRepository) ConfirmEmailChange(id primitive.ObjectID, email string) (bool, error) {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "pendingEmail": email},
+		bson.M{
+			"$set":   bson.M{"email": email, "emailVerified": true},
+			"$unset": bson.M{"pendingEmail": ""},
+		})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// ClaimVerificationSend records that a verification email goes out at `at`,
+// unless one was already sent within minInterval. The check and the write
+// are a single update so concurrent resends cannot both win.
+func (r *MongoUserRepository) ClaimVerificationSend(id primitive.ObjectID, at time.Time, minInterval time.Duration) (bool, error) {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "$or": []bson.M{
+			{"verificationSentAt": bson.M{"$exists": false}},
+			{"verificationSentAt": bson.M{"$lte": at.Add(-minInterval)}},
+		}},
+		bson.M{"$set": bson.M{"verificationSentAt": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *MongoUserRepository) SetPendingTOTP(id primitive.ObjectID, secret string) error {
+	return r.setField(id, "totpPendingSecret", secret)
+}
+
+func (r *MongoUserRepository) EnableTOTP(id primitive.ObjectID, secret string, recoveryHashes []string) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"totpEnabled": true, "totpSecret": secret, "recoveryCodes": recoveryHashes},
+			"$unset": bson.M{"totpPendingSecret": "", "totpLastCounter": ""},
+		})
+	return err
+}
+
+func (r *MongoUserRepository) DisableTOTP(id primitive.ObjectID) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"totpEnabled": false},
+			"$unset": bson.M{"totpSecret": "", "totpPendingSecret": "", "totpLastCounter": "", "recoveryCodes": ""},
+		})
+	return err
+}
+
+// ClaimTOTPCounter records the time step of an accepted code. It reports false
+// if that step (or a later one) was already used, blocking code replay.
+func (r *MongoUserRepository) ClaimTOTPCounter(id primitive.ObjectID, counter int64) (bool, error) {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "$or": []bson.M{
+			{"totpLastCounter": bson.M{"$exists": false}},
+			{"totpLastCounter": bson.M{"$lt": counter}},
+		}},
+		bson.M{"$set": bson.M{"totpLastCounter": counter}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// ConsumeRecoveryCode removes the code hash, reporting whether it was present.
+func (r *MongoUserRepository) ConsumeRecoveryCode(id primitive.ObjectID, codeHash string) (bool, error) {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "recoveryCodes": codeHash},
+		bson.M{"$pull": bson.M{"recoveryCodes": codeHash}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// ClaimMFAAttempt counts a second-factor attempt as failed unless max have
+// failed within cooldown, checking and counting in one update so parallel
+// attempts cannot all pass the check. Once the cooldown has passed the count
+// starts over, so the user gets the full budget back. It reports whether the
+// attempt may go ahead.
+func (r *MongoUserRepository) ClaimMFAAttempt(id primitive.ObjectID, at time.Time, max int, cooldown time.Duration) (bool, error) {
+	cutoff := at.Add(-cooldown)
+	filter := bson.M{"_id": id, "$or": []bson.M{
+		{"mfaFailures": bson.M{"$exists": false}},
+		{"mfaFailures": bson.M{"$lt": max}},
+		{"mfaFailedAt": bson.M{"$lte": cutoff}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"mfaFailures": bson.M{"$cond": bson.A{
+			bson.M{"$lte": bson.A{"$mfaFailedAt", cutoff}},
+			1,
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$mfaFailures", 0}}, 1}},
+		}},
+		"mfaFailedAt": at,
+	}}}}
+	err := r.collection().FindOneAndUpdate(context.TODO(), filter, update).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *MongoUserRepository) ResetMFAFailures(id primitive.ObjectID) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"mfaFailures": "", "mfaFailedAt": ""}})
+	return err
+}
+
+// ScheduleDeletion sets when the account is purged; nil cancels it.
+func (r *MongoUserRepository) ScheduleDeletion(id primitive.ObjectID, at *time.Time) error {
+	if at == nil {
+		_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+			bson.M{"$unset": bson.M{"deletionScheduledAt": ""}})
+		return err
+	}
+	return r.setField(id, "deletionScheduledAt", *at)
+}
+
+func (r *MongoUserRepository) FindDueForDeletion(now time.Time, limit int64) ([]User, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "deletionScheduledAt", Value: 1}}).SetLimit(limit)
+	cursor, err := r.collection().Find(context.TODO(), bson.M{"deletionScheduledAt": bson.M{"$lte": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	users := []User{}
+	if err := cursor.All(context.TODO(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *MongoUserRepository) Delete(id primitive.ObjectID) error {
+	_, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// MarkLegacyUsersVerified treats accounts created before email verification
+// existed as verified, so they are not locked out. Safe to run on every start.
+func (r *MongoUserRepository) MarkLegacyUsersVerified() error {
+	_, err := r.collection().UpdateMany(context.TODO(),
+		bson.M{"emailVerified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerified": true}})
+	return err
+}
+
+// DropLegacyPasskeys removes the plaintext reset passkeys that used to be
+// stored on user documents. Safe to run on every start.
+func (r *MongoUserRepository) DropLegacyPasskeys() error {
+	_, err := r.collection().UpdateMany(context.TODO(),
+		bson.M{"$or": []bson.M{{"passkey": bson.M{"$exists": true}}, {"passkeyGeneratedAt": bson.M{"$exists": true}}}},
+		bson.M{"$unset": bson.M{"passkey": "", "passkeyGeneratedAt": ""}})
+	return err
+}
+
+func (r *MongoUserRepository) setField(id primitive.ObjectID, field string, value interface{}) error {
+	res, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{field: value}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(token *RefreshToken) error
+	FindRefreshToken(tokenHash string) (*RefreshToken, error)
+	MarkRotated(id primitive.ObjectID, at time.Time) (bool, error)
+	RevokeFamily(familyId string, at time.Time) error
+	RevokeUser(userId primitive.ObjectID, at time.Time) error
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoTokenRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoTokenRepository(client *mongo.Client) *MongoTokenRepository {
+	return &MongoTokenRepository{client: client}
+}
+
+func (r *MongoTokenRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("refreshTokens")
+}
+
+// EnsureIndexes creates the lookup indexes and lets Mongo expire old tokens.
+func (r *MongoTokenRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *MongoTokenRepository) CreateRefreshToken(token *RefreshToken) error {
+	_, err := r.collection().InsertOne(context.TODO(), token)
+	return err
+}
+
+func (r *MongoTokenRepository) FindRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := r.collection().FindOne(context.TODO(), bson.M{"tokenHash": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRotated flags the token as used. It reports false if the token had
+// already been rotated or revoked, which callers must treat as reuse.
+func (r *MongoTokenRepository) MarkRotated(id primitive.ObjectID, at time.Time) (bool, error) {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "rotatedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"rotatedAt": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *MongoTokenRepository) RevokeFamily(familyId string, at time.Time) error {
+	_, err := r.collection().UpdateMany(context.TODO(),
+		bson.M{"familyId": familyId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": at}})
+	return err
+}
+
+func (r *MongoTokenRepository) RevokeUser(userId primitive.ObjectID, at time.Time) error {
+	_, err := r.collection().UpdateMany(context.TODO(),
+		bson.M{"userId": userId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": at}})
+	return err
+}
+
+func (r *MongoTokenRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ResetTokenRepository stores password reset passkeys, at most one per user.
+type ResetTokenRepository interface {
+	Replace(token *ResetToken) error
+	FindActive(userId primitive.ObjectID, now time.Time) (*ResetToken, error)
+	ClaimAttempt(id primitive.ObjectID, max int) (int, error)
+	Consume(id primitive.ObjectID, tokenHash string) (bool, error)
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoResetTokenRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoResetTokenRepository(client *mongo.Client) *MongoResetTokenRepository {
+	return &MongoResetTokenRepository{client: client}
+}
+
+func (r *MongoResetTokenRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("resetTokens")
+}
+
+// EnsureIndexes keeps one token per user and lets Mongo expire old tokens.
+func (r *MongoResetTokenRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Replace stores token as the user's only reset token, discarding any older
+// one. The document keeps its _id, so callers leave token.ID unset.
+func (r *MongoResetTokenRepository) Replace(token *ResetToken) error {
+	_, err := r.collection().ReplaceOne(context.TODO(),
+		bson.M{"userId": token.UserID}, token, options.Replace().SetUpsert(true))
+	return err
+}
+
+// FindActive ignores expired tokens the TTL monitor has not removed yet.
+func (r *MongoResetTokenRepository) FindActive(userId primitive.ObjectID, now time.Time) (*ResetToken, error) {
+	var token ResetToken
+	err := r.collection().FindOne(context.TODO(),
+		bson.M{"userId": userId, "expiresAt": bson.M{"$gt": now}}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ClaimAttempt counts an attempt at the passkey unless max have been made,
+// checking and counting in one update so parallel guesses cannot all pass
+// the check. It returns the new number of attempts, or mongo.ErrNoDocuments
+// once the token is used up.
+func (r *MongoResetTokenRepository) ClaimAttempt(id primitive.ObjectID, max int) (int, error) {
+	var token ResetToken
+	err := r.collection().FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": id, "attempts": bson.M{"$lt": max}}, bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&token)
+	if err != nil {
+		return 0, err
+	}
+	return token.Attempts, nil
+}
+
+// Consume deletes the token. It reports false if it was already gone, so a
+// passkey can be redeemed at most once even under concurrent requests.
+func (r *MongoResetTokenRepository) Consume(id primitive.ObjectID, tokenHash string) (bool, error) {
+	res, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": id, "tokenHash": tokenHash})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+func (r *MongoResetTokenRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// LoginAttemptRepository keeps the failed-login counters used by LoginThrottle.
+type LoginAttemptRepository interface {
+	Find(key string) (*LoginAttempt, error)
+	RecordFailure(key string, now time.Time, window time.Duration) (int, error)
+	Lock(key string, until time.Time) error
+	Clear(key string) error
+}
+
+type MongoLoginAttemptRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoLoginAttemptRepository(client *mongo.Client) *MongoLoginAttemptRepository {
+	return &MongoLoginAttemptRepository{client: client}
+}
+
+func (r *MongoLoginAttemptRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("loginAttempts")
+}
+
+func (r *MongoLoginAttemptRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (r *MongoLoginAttemptRepository) Find(key string) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	if err := r.collection().FindOne(context.TODO(), bson.M{"_id": key}).Decode(&attempt); err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordFailure atomically counts a failure and returns the new count. A
+// counter whose window has run out (but that the TTL monitor has not removed
+// yet) starts again from one.
+func (r *MongoLoginAttemptRepository) RecordFailure(key string, now time.Time, window time.Duration) (int, error) {
+	live := bson.M{"$gt": bson.A{"$expiresAt", now}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures":      bson.M{"$cond": bson.A{live, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}}, 1}},
+		"lockedUntil":   bson.M{"$cond": bson.A{live, "$lockedUntil", "$$REMOVE"}},
+		"lastFailureAt": now,
+		"expiresAt":     bson.M{"$max": bson.A{bson.M{"$cond": bson.A{live, "$expiresAt", now}}, now.Add(window)}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt LoginAttempt
+	if err := r.collection().FindOneAndUpdate(context.TODO(), bson.M{"_id": key}, update, opts).Decode(&attempt); err != nil {
+		return 0, err
+	}
+	return attempt.Failures, nil
+}
+
+// Lock blocks key until the given time and keeps the counter alive as long.
+func (r *MongoLoginAttemptRepository) Lock(key string, until time.Time) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": key},
+		bson.M{"$set": bson.M{"lockedUntil": until}, "$max": bson.M{"expiresAt": until}})
+	return err
+}
+
+func (r *MongoLoginAttemptRepository) Clear(key string) error {
+	_, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": key})
+	return err
+}
@@ -1,15 +1,93 @@
 package auth
 
-import "github.com/gin-gonic/gin"
+import (
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
 
 const BasePath = "/auth"
 
+// RegisterRoutes mounts the authentication endpoints. The public ones need
+// no role since they are how callers obtain one; account settings such as
+// the /me profile, sessions, login history, personal access tokens and
+// two-factor enrollment require a logged-in user. Changes to credentials
+// are refused to admins impersonating the user.
 func RegisterRoutes(r *gin.RouterGroup, controller *AuthController) {
 	group := r.Group(BasePath)
 	{
 		group.POST("/register", controller.Register)
 		group.POST("/login", controller.Login)
+		group.POST("/login/mfa", controller.LoginMFA)
+		group.POST("/magic-link", controller.RequestMagicLink)
+		group.GET("/magic-link/consume", controller.MagicLinkPage)
+		group.POST("/magic-link/consume", controller.LoginMagicLink)
+		group.POST("/refresh", controller.Refresh)
+		group.POST("/logout", controller.Logout)
+		group.GET("/verify-email", controller.VerifyEmail)
+		group.GET("/confirm-email", controller.ConfirmEmailChange)
+		group.POST("/resend-verification", controller.ResendVerification)
 		group.POST("/reset-password", controller.ResetPassword)
 		group.POST("/change-password", controller.ChangePassword)
 	}
+
+	account := group.Group("",
+		middleware.JWTMiddleware(controller.service),
+		middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
+	{
+		account.GET("/me", controller.GetMe)
+		account.PATCH("/me", middleware.RefuseImpersonation(), controller.UpdateMe)
+		account.POST("/me/password", middleware.RefuseImpersonation(), controller.ChangeMyPassword)
+		account.GET("/tokens", controller.ListTokens)
+		account.POST("/tokens", middleware.RefuseImpersonation(), controller.CreateToken)
+		account.DELETE("/tokens/:id", middleware.RefuseImpersonation(), controller.RevokeToken)
+		account.GET("/sessions", controller.ListSessions)
+		account.DELETE("/sessions", middleware.RefuseImpersonation(), controller.RevokeOtherSessions)
+		account.DELETE("/sessions/:id", middleware.RefuseImpersonation(), controller.RevokeSession)
+		account.GET("/login-history", controller.GetLoginHistory)
+		account.POST("/2fa/setup", middleware.RefuseImpersonation(), controller.SetupTOTP)
+		account.POST("/2fa/confirm", middleware.RefuseImpersonation(), controller.ConfirmTOTP)
+		account.POST("/2fa/disable", middleware.RefuseImpersonation(), controller.DisableTOTP)
+	}
+}
+
+// RegisterWebAuthnRoutes mounts the passkey ceremonies under /auth/webauthn.
+// Login is public; registering and managing credentials needs a logged-in user.
+func RegisterWebAuthnRoutes(r *gin.RouterGroup, controller *WebAuthnController) {
+	group := r.Group(BasePath + "/webauthn")
+	{
+		group.POST("/login/begin", controller.BeginLogin)
+		group.POST("/login/finish", controller.FinishLogin)
+	}
+
+	account := group.Group("",
+		middleware.JWTMiddleware(controller.service.auth),
+		middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
+	{
+		account.POST("/register/begin", middleware.RefuseImpersonation(), controller.BeginRegistration)
+		account.POST("/register/finish", middleware.RefuseImpersonation(), controller.FinishRegistration)
+		account.GET("/credentials", controller.ListCredentials)
+		account.DELETE("/credentials/:id", middleware.RefuseImpersonation(), controller.DeleteCredential)
+	}
+}
+
+// RegisterOIDCRoutes mounts login through external identity providers under
+// /auth/oidc. Listing and unlinking identities needs a logged-in user.
+func RegisterOIDCRoutes(r *gin.RouterGroup, controller *OIDCController) {
+	group := r.Group(BasePath + "/oidc")
+	{
+		group.GET("/providers", controller.ListProviders)
+		group.GET("/:provider/login", controller.Login)
+		group.GET("/:provider/callback", controller.Callback)
+		group.POST("/exchange", controller.Exchange)
+	}
+
+	account := group.Group("",
+		middleware.JWTMiddleware(controller.service.auth),
+		middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
+	{
+		account.GET("/identities", controller.ListIdentities)
+		account.DELETE("/identities/:id", middleware.RefuseImpersonation(), controller.Unlink)
+	}
 }
@@ -1,24 +1,84 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/password"
 	"omhs-backend/internal/utils"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// RefreshTokenTTL bounds how long a login stays alive without being refreshed.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	PurposeVerifyEmail         = "verify-email"
+	VerificationTokenTTL       = 24 * time.Hour
+	VerificationResendInterval = time.Minute
+
+	// PasskeyTTL is how long an emailed password reset passkey stays valid.
+	// Passkeys are short, so each one only gets MaxResetAttempts guesses and
+	// a new one can be requested at most once per ResetRequestInterval.
+	PasskeyTTL           = 10 * time.Minute
+	MaxResetAttempts     = 5
+	ResetRequestInterval = time.Minute
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReuse   = errors.New("refresh token reuse detected")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrAccountDisabled     = errors.New("account is disabled")
+	ErrEmailNotVerified    = errors.New("email address not verified")
+	ErrAlreadyVerified     = errors.New("email address already verified")
+	ErrInvalidVerification = errors.New("invalid or expired verification link")
+	ErrResendThrottled     = errors.New("verification email sent recently, try again later")
+	ErrInvalidPasskey      = errors.New("invalid or expired passkey")
+	ErrResetLocked         = errors.New("too many wrong passkeys, request a new one")
+	ErrResetThrottled      = errors.New("password reset requested recently, try again later")
 )
 
 type AuthService struct {
-	repo UserRepository
+	repo           UserRepository
+	tokens         TokenRepository
+	sessions       SessionRepository
+	events         LoginEventRepository
+	resets         ResetTokenRepository
+	magicLinks     MagicLinkRepository
+	pats           PersonalAccessTokenRepository
+	invites        InviteRepository
+	audit          AuditLogRepository
+	throttle       *LoginThrottle
+	policy         password.Policy
+	registration   RegistrationConfig
+	mailer         mail.Mailer
+	authenticators []Authenticator
 }
 
-func NewAuthService(repo UserRepository) *AuthService {
-	return &AuthService{repo: repo}
+// NewAuthService checks passwords with the given authenticators in order,
+// or only against local password hashes when none are given.
+func NewAuthService(repo UserRepository, tokens TokenRepository, sessions SessionRepository, events LoginEventRepository, resets ResetTokenRepository, magicLinks MagicLinkRepository, pats PersonalAccessTokenRepository, invites InviteRepository, audit AuditLogRepository, throttle *LoginThrottle, policy password.Policy, registration RegistrationConfig, mailer mail.Mailer, authenticators ...Authenticator) *AuthService {
+	if len(authenticators) == 0 {
+		authenticators = []Authenticator{NewPasswordAuthenticator(repo)}
+	}
+	return &AuthService{repo: repo, tokens: tokens, sessions: sessions, events: events, resets: resets, magicLinks: magicLinks, pats: pats, invites: invites, audit: audit, throttle: throttle, policy: policy, registration: registration, mailer: mailer, authenticators: authenticators}
 }
 
 // --- REGISTER ---
+
+// Register creates a local account. Unless registration is open to the
+// email, it needs an invite; invited accounts start out verified.
 func (s *AuthService) Register(req RegisterRequest) (*User, error) {
 	if req.Username == "" || req.Password == "" || req.Email == "" {
 		return nil, errors.New("all fields are required")
@@ -28,47 +88,340 @@ func (s *AuthService) Register(req RegisterRequest) (*User, error) {
 		return nil, errors.New("username already exists")
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if perr := s.policy.Validate("password", req.Password, req.Username, req.Email); perr != nil {
+		return nil, perr
+	}
+
+	invite, err := s.checkRegistration(req.Email, req.InviteCode)
 	if err != nil {
 		return nil, err
 	}
 
+	hash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	locale := ""
+	if req.Locale != "" {
+		locale = mail.MatchLocale(req.Locale)
+	}
+
 	user := &User{
-		Username:  req.Username,
-		Password:  string(hash),
-		Email:     req.Email,
-		IsAdmin:   false,
-		ID:        utils.NewObjectID(),
-		LastLogin: time.Now(),
+		Username:      req.Username,
+		Password:      hash,
+		Email:         req.Email,
+		EmailVerified: false,
+		Locale:        locale,
+		IsAdmin:       false,
+		ID:            utils.NewObjectID(),
+		LastLogin:     time.Now(),
+	}
+
+	if invite != nil {
+		consumed, err := s.invites.Consume(invite.ID, user.ID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !consumed {
+			return nil, ErrInvalidInvite
+		}
+		applyInvite(user, invite)
 	}
 
 	if err := s.repo.Create(user); err != nil {
+		if invite != nil {
+			if relErr := s.invites.Release(invite.ID); relErr != nil {
+				logrus.Errorf("Failed to release invite %s: %v", invite.ID.Hex(), relErr)
+			}
+		}
 		return nil, err
 	}
+	if user.EmailVerified {
+		return user, nil
+	}
+
+	// The account exists either way; a failed send can be retried via resend.
+	if err := s.sendVerification(user); err != nil {
+		logrus.Errorf("Failed to send verification email to %s: %v", user.Email, err)
+	}
 	return user, nil
 }
 
+// --- EMAIL VERIFICATION ---
+
+// NewEmailVerificationToken signs a link token bound to the user's current email,
+// so a link stops working once the address changes.
+func NewEmailVerificationToken(user *User) (string, error) {
+	return utils.GenerateActionToken(PurposeVerifyEmail, user.ID.Hex(), user.Email, VerificationTokenTTL)
+}
+
+func (s *AuthService) VerifyEmail(token string) error {
+	claims, err := utils.ParseActionToken(PurposeVerifyEmail, token)
+	if err != nil {
+		return ErrInvalidVerification
+	}
+
+	userId, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return ErrInvalidVerification
+	}
+
+	user, err := s.repo.FindByID(userId)
+	if err != nil || user.Email != claims.Value {
+		return ErrInvalidVerification
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	return s.repo.MarkEmailVerified(user.ID)
+}
+
+func (s *AuthService) ResendVerification(req ResendVerificationRequest) error {
+	user, err := s.repo.FindByEmailAndUsername(req.Email, req.Username)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+	return s.sendVerification(user)
+}
+
+func (s *AuthService) sendVerification(user *User) error {
+	claimed, err := s.repo.ClaimVerificationSend(user.ID, time.Now(), VerificationResendInterval)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrResendThrottled
+	}
+
+	token, err := NewEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api%s/verify-email?token=%s", utils.PublicURL(), BasePath, url.QueryEscape(token))
+	return s.sendTemplate(user, mail.TemplateVerifyEmail, utils.HashToken(token), mail.Data{
+		"Link":  link,
+		"Hours": int(VerificationTokenTTL.Hours()),
+	})
+}
+
 // --- LOGIN ---
-func (s *AuthService) Login(req LoginRequest) (string, error) {
-	user, err := s.repo.FindByUsername(req.Username)
+func (s *AuthService) Login(req LoginRequest) (*LoginResult, error) {
+	now := time.Now()
+	client := ClientInfo{IP: req.IP, UserAgent: req.UserAgent}
+	if err := s.throttle.Check(req.Username, req.IP, now); err != nil {
+		s.recordLoginFailure(req.Username, client, err)
+		return nil, err
+	}
+
+	user, err := s.authenticate(req.Username, req.Password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		// the lockout notice goes to the account if there is one
+		known, findErr := s.repo.FindByUsername(req.Username)
+		if findErr != nil {
+			known = nil
+		}
+		err = s.loginFailed(known, req, now)
+		if known != nil {
+			s.recordLogin(known, client, loginMethod(known), err)
+		}
+		return nil, err
+	}
 	if err != nil {
-		return "", errors.New("invalid username or password")
+		return nil, err
+	}
+
+	if err := s.throttle.Reset(req.Username); err != nil {
+		logrus.Errorf("Failed to reset login failures for %s: %v", req.Username, err)
+	}
+
+	method := loginMethod(user)
+	if user.Disabled {
+		s.recordLogin(user, client, method, ErrAccountDisabled)
+		return nil, ErrAccountDisabled
 	}
 
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
-		return "", errors.New("invalid username or password")
+	if !user.EmailVerified {
+		s.recordLogin(user, client, method, ErrEmailNotVerified)
+		return nil, ErrEmailNotVerified
 	}
 
+	// the password alone is not enough once 2FA is on
+	if user.TOTPEnabled {
+		return s.newMFAChallenge(user, method)
+	}
+
+	tokens, err := s.completeLogin(user, client, method)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// authenticate asks each authenticator in turn. If none accepts and one of
+// them could not be reached, the caller is told to retry rather than that
+// the password is wrong.
+func (s *AuthService) authenticate(username, password string) (*User, error) {
+	var unavailable error
+	for _, a := range s.authenticators {
+		user, err := a.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			logrus.Errorf("Authenticator %q failed for %s: %v", a.Source(), username, err)
+			unavailable = ErrAuthenticatorUnavailable
+		}
+	}
+	if unavailable != nil {
+		return nil, unavailable
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// passwordMatches re-checks the password of a known account with the
+// authenticator that owns it.
+func (s *AuthService) passwordMatches(user *User, password string) (bool, error) {
+	for _, a := range s.authenticators {
+		if a.Source() != user.AuthSource {
+			continue
+		}
+		authed, err := a.Authenticate(user.Username, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			return false, nil
+		}
+		if err != nil {
+			logrus.Errorf("Authenticator %q failed for %s: %v", a.Source(), user.Username, err)
+			return false, ErrAuthenticatorUnavailable
+		}
+		return authed.ID == user.ID, nil
+	}
+	return false, nil
+}
+
+// loginFailed counts a wrong password. Unknown usernames are counted too, so
+// responses do not reveal which accounts exist. user is nil in that case.
+func (s *AuthService) loginFailed(user *User, req LoginRequest, now time.Time) error {
+	locked, err := s.throttle.RecordFailure(req.Username, req.IP, now)
+	if err != nil {
+		logrus.Errorf("Failed to record login failure for %s: %v", req.Username, err)
+		return ErrInvalidCredentials
+	}
+	if !locked {
+		return ErrInvalidCredentials
+	}
+
+	if user != nil {
+		// one notice per lockout period, however many attempts hit the lock
+		period := now.Truncate(s.throttle.cfg.LockoutDuration).Unix()
+		err := s.sendTemplate(user, mail.TemplateAccountLocked, strconv.FormatInt(period, 10), mail.Data{
+			"Minutes": int(s.throttle.cfg.LockoutDuration.Minutes()),
+			"IP":      req.IP,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to send lockout notification to %s: %v", user.Email, err)
+		}
+	}
+	return s.throttle.lockoutError()
+}
+
+// UnlockLogin lifts a login lockout before it runs out, for admins.
+func (s *AuthService) UnlockLogin(username string) error {
+	return s.throttle.Reset(username)
+}
+
+// completeLogin runs once every factor has been checked. method is recorded
+// in the login history.
+func (s *AuthService) completeLogin(user *User, client ClientInfo, method string) (*TokenPair, error) {
 	// update lastLogin
 	s.repo.UpdateLastLogin(user.ID, time.Now())
 
-	// create JWT
-	token, err := utils.GenerateJWT(user.ID.Hex(), user.Username)
+	// every login starts a new session and refresh token family
+	tokens, err := s.startSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+	s.recordLogin(user, client, method, nil)
+	return tokens, nil
+}
+
+// --- REFRESH ---
+func (s *AuthService) Refresh(req RefreshRequest) (*TokenPair, error) {
+	current, err := s.tokens.FindRefreshToken(utils.HashToken(req.RefreshToken))
 	if err != nil {
-		return "", err
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	return token, nil
+	// A rotated token being presented again means it leaked: kill the family.
+	rotated, err := s.tokens.MarkRotated(current.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		if err := s.endSession(current.UserID, current.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReuse
+	}
+
+	user, err := s.repo.FindByID(current.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if err := s.continueSession(user, current.FamilyID, ClientInfo{IP: req.IP, UserAgent: req.UserAgent}); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user, current.FamilyID)
+}
+
+// --- LOGOUT ---
+func (s *AuthService) Logout(req RefreshRequest) error {
+	current, err := s.tokens.FindRefreshToken(utils.HashToken(req.RefreshToken))
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+	return s.endSession(current.UserID, current.FamilyID)
+}
+
+// issueTokens signs an access token for the session and adds a refresh
+// token to its family.
+func (s *AuthService) issueTokens(user *User, sessionId string) (*TokenPair, error) {
+	access, err := utils.GenerateJWT(user.ID.Hex(), user.Username, user.EffectiveRoles(), sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.tokens.CreateRefreshToken(&RefreshToken{
+		ID:        utils.NewObjectID(),
+		UserID:    user.ID,
+		FamilyID:  sessionId,
+		TokenHash: utils.HashToken(refresh),
+		CreatedAt: now,
+		ExpiresAt: now.Add(RefreshTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{AccessToken: access, RefreshToken: refresh}, nil
 }
 
 // --- RESET PASSWORD ---
@@ -77,25 +430,55 @@ func (s *AuthService) ResetPassword(req ResetPasswordRequest) error {
 	if err != nil {
 		return errors.New("user not found")
 	}
+	if user.AuthSource != "" {
+		return ErrExternalAccount
+	}
+
+	now := time.Now()
+	if existing, err := s.resets.FindActive(user.ID, now); err == nil && now.Sub(existing.CreatedAt) < ResetRequestInterval {
+		return ErrResetThrottled
+	}
 
 	passkey, err := utils.GeneratePasskey()
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.UpdatePasskey(user.ID, passkey, time.Now()); err != nil {
+	// Replacing the token also resets the attempt counter of a locked one.
+	tokenHash := hashPasskey(user.ID, passkey)
+	if err := s.resets.Replace(&ResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(PasskeyTTL),
+	}); err != nil {
 		return err
 	}
 
-	// async invalidation
-	go func() {
-		time.Sleep(10 * time.Minute)
-		_ = s.repo.InvalidatePasskey(user.ID)
-	}()
+	return s.sendTemplate(user, mail.TemplatePasswordReset, tokenHash, mail.Data{
+		"Passkey": passkey,
+		"Minutes": int(PasskeyTTL.Minutes()),
+	})
+}
 
-	subject := "Password Reset Passkey"
-	message := fmt.Sprintf("Your passkey for resetting your password is: %s", passkey)
-	return utils.SendEmail(user.Email, subject, message)
+// sendTemplate renders an email template in the user's locale and sends it.
+// key names the event the email is about, e.g. the hash of the token it
+// carries; with the template and user it makes the message's idempotency key.
+func (s *AuthService) sendTemplate(user *User, name, key string, data mail.Data) error {
+	return s.sendTemplateTo(user, user.Email, name, key, data)
+}
+
+// sendTemplateTo is sendTemplate for an address other than the account's,
+// such as a pending new email.
+func (s *AuthService) sendTemplateTo(user *User, to, name, key string, data mail.Data) error {
+	data["Username"] = user.Username
+	msg, err := mail.Render(name, user.Locale, data)
+	if err != nil {
+		return err
+	}
+	msg.ID = mail.Key(name, user.ID.Hex(), key)
+	msg.To = to
+	return s.mailer.Send(msg)
 }
 
 // --- CHANGE PASSWORD ---
@@ -105,17 +488,68 @@ func (s *AuthService) ChangePassword(req ChangePasswordRequest) error {
 		return errors.New("user not found")
 	}
 
-	if user.Passkey != req.Passkey {
-		return errors.New("invalid passkey")
+	token, err := s.resets.FindActive(user.ID, time.Now())
+	if err != nil {
+		return ErrInvalidPasskey
+	}
+
+	// The attempt is counted before the passkey is compared, so parallel
+	// guesses get no more tries than sequential ones.
+	attempts, err := s.resets.ClaimAttempt(token.ID, MaxResetAttempts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrResetLocked
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashPasskey(user.ID, req.Passkey)), []byte(token.TokenHash)) != 1 {
+		if attempts >= MaxResetAttempts {
+			return ErrResetLocked
+		}
+		return ErrInvalidPasskey
 	}
-	if time.Since(user.PasskeyGeneratedAt) > 10*time.Minute {
-		return errors.New("passkey expired")
+
+	// Checked before the passkey is consumed so a rejected password can be
+	// retried with the same passkey.
+	if err := s.checkNewPassword("newPassword", req.NewPassword, user); err != nil {
+		return err
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	consumed, err := s.resets.Consume(token.ID, token.TokenHash)
 	if err != nil {
 		return err
 	}
+	if !consumed {
+		return ErrInvalidPasskey
+	}
+
+	hash, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.RotatePassword(user.ID, hash, user.Password, s.policy.History); err != nil {
+		return err
+	}
+	// Whoever knew the old password must not stay logged in.
+	return s.RevokeUserSessions(user.ID)
+}
+
+// checkNewPassword applies the policy to a replacement password and refuses
+// the current one and the last policy.History ones.
+func (s *AuthService) checkNewPassword(field, newPassword string, user *User) error {
+	if perr := s.policy.Validate(field, newPassword, user.Username, user.Email); perr != nil {
+		return perr
+	}
+	if s.policy.History > 0 && password.Reused(newPassword, append([]string{user.Password}, user.PasswordHistory...)...) {
+		return password.ReusedError(field, s.policy.History)
+	}
+	return nil
+}
 
-	return s.repo.UpdatePassword(user.ID, string(hash))
+// hashPasskey binds the hash to the user so equal passkeys of different
+// users do not share a hash.
+func hashPasskey(userId primitive.ObjectID, passkey string) string {
+	return utils.HashToken(userId.Hex() + ":" + strings.ToLower(strings.TrimSpace(passkey)))
 }
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"omhs-backend/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// LastSeenResolution is how precisely a session's LastSeenAt follows its
+	// requests.
+	LastSeenResolution = time.Minute
+	maxUserAgentLength = 512
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// --- SESSIONS ---
+
+// startSession records a new login and issues its first token pair. The
+// session id doubles as the refresh token family id.
+func (s *AuthService) startSession(user *User, client ClientInfo) (*TokenPair, error) {
+	session := newSession(utils.NewObjectID().Hex(), user.ID, client, time.Now())
+	if err := s.sessions.Create(session); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session.ID)
+}
+
+// continueSession extends the session a refresh token belongs to. Token
+// families from before sessions were recorded get one on their next refresh.
+func (s *AuthService) continueSession(user *User, familyId string, client ClientInfo) error {
+	now := time.Now()
+	session, err := s.sessions.FindByID(familyId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return s.sessions.Create(newSession(familyId, user.ID, client, now))
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != user.ID {
+		return ErrInvalidRefreshToken
+	}
+	return s.sessions.Refreshed(session.ID, client.IP, now, now.Add(RefreshTokenTTL))
+}
+
+func newSession(id string, userId primitive.ObjectID, client ClientInfo, now time.Time) *Session {
+	userAgent := clipUserAgent(client.UserAgent)
+	return &Session{
+		ID:         id,
+		UserID:     userId,
+		IP:         client.IP,
+		UserAgent:  userAgent,
+		DeviceName: deviceName(userAgent),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}
+}
+
+// endSession revokes the session's refresh tokens before deleting it, so a
+// failure in between leaves nothing a refresh could bring back.
+func (s *AuthService) endSession(userId primitive.ObjectID, id string) error {
+	if err := s.tokens.RevokeFamily(id, time.Now()); err != nil {
+		return err
+	}
+	if err := s.sessions.Delete(userId, id); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+// ListSessions returns where the user is logged in. currentId marks the
+// session of the caller, if it used one.
+func (s *AuthService) ListSessions(userId primitive.ObjectID, currentId string) ([]Session, error) {
+	sessions, err := s.sessions.List(userId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentId
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs one of the user's sessions out. Its access tokens stop
+// working immediately.
+func (s *AuthService) RevokeSession(userId primitive.ObjectID, id string) error {
+	session, err := s.sessions.FindByID(id)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && session.UserID != userId) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.endSession(userId, session.ID)
+}
+
+// RevokeOtherSessions logs the user out everywhere but the session with
+// currentId, and reports how many sessions were ended.
+func (s *AuthService) RevokeOtherSessions(userId primitive.ObjectID, currentId string) (int, error) {
+	sessions, err := s.sessions.List(userId)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == currentId {
+			continue
+		}
+		if err := s.endSession(userId, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// ValidateSession rejects access tokens of disabled users and of sessions
+// that were revoked or have expired.
+func (s *AuthService) ValidateSession(userId primitive.ObjectID, sessionId string) error {
+	if sessionId == "" {
+		return ErrSessionRevoked
+	}
+
+	user, err := s.repo.FindByID(userId)
+	if err != nil {
+		return ErrSessionRevoked
+	}
+	if user.Disabled {
+		return ErrAccountDisabled
+	}
+
+	session, err := s.sessions.FindByID(sessionId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userId {
+		return ErrSessionRevoked
+	}
+
+	if err := s.sessions.TouchLastSeen(session.ID, time.Now(), LastSeenResolution); err != nil {
+		logrus.Errorf("Failed to record activity of session %s: %v", session.ID, err)
+	}
+	return nil
+}
+
+// RevokeUserSessions logs the user out everywhere. Outstanding access tokens
+// stop working immediately because their sessions are gone.
+func (s *AuthService) RevokeUserSessions(userId primitive.ObjectID) error {
+	if err := s.tokens.RevokeUser(userId, time.Now()); err != nil {
+		return err
+	}
+	return s.sessions.DeleteByUser(userId)
+}
+
+var (
+	// Order matters: Edge and Opera also claim to be Chrome, Chrome claims
+	// to be Safari, iOS claims to be macOS and Android claims to be Linux.
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	}
+	userAgentPlatforms = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Macintosh", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// clipUserAgent bounds what a client can make us store.
+func clipUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgentLength {
+		return userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// deviceName turns a user agent into something like "Firefox on Windows".
+// It is a hint for people recognising their sessions, nothing more.
+func deviceName(userAgent string) string {
+	match := func(candidates []struct{ token, name string }) string {
+		for _, c := range candidates {
+			if strings.Contains(userAgent, c.token) {
+				return c.name
+			}
+		}
+		return ""
+	}
+
+	browser, platform := match(userAgentBrowsers), match(userAgentPlatforms)
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository stores one record per login. A session that is missing,
+// because it was revoked or has expired, is no longer valid.
+type SessionRepository interface {
+	Create(session *Session) error
+	FindByID(id string) (*Session, error)
+	List(userId primitive.ObjectID) ([]Session, error)
+	Refreshed(id, ip string, at, expiresAt time.Time) error
+	TouchLastSeen(id string, at time.Time, minInterval time.Duration) error
+	Delete(userId primitive.ObjectID, id string) error
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoSessionRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoSessionRepository(client *mongo.Client) *MongoSessionRepository {
+	return &MongoSessionRepository{client: client}
+}
+
+func (r *MongoSessionRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("sessions")
+}
+
+// EnsureIndexes also lets Mongo drop sessions whose refresh tokens have expired.
+func (r *MongoSessionRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *MongoSessionRepository) Create(session *Session) error {
+	_, err := r.collection().InsertOne(context.TODO(), session)
+	return err
+}
+
+// FindByID treats sessions past ExpiresAt as missing, since the TTL monitor
+// only runs once a minute.
+func (r *MongoSessionRepository) FindByID(id string) (*Session, error) {
+	var session Session
+	err := r.collection().FindOne(context.TODO(),
+		bson.M{"_id": id, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// List returns the user's live sessions, most recently used first.
+func (r *MongoSessionRepository) List(userId primitive.ObjectID) ([]Session, error) {
+	cursor, err := r.collection().Find(context.TODO(),
+		bson.M{"userId": userId, "expiresAt": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	sessions := []Session{}
+	if err := cursor.All(context.TODO(), &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Refreshed records a token refresh, which extends the session and may come
+// from a new address.
+func (r *MongoSessionRepository) Refreshed(id, ip string, at, expiresAt time.Time) error {
+	_, err := r.collection().UpdateOne(context.TODO(), bson.M{"_id": id},
+		bson.M{"$set": bson.M{"ip": ip, "lastSeenAt": at, "expiresAt": expiresAt}})
+	return err
+}
+
+// TouchLastSeen records a request, at most once per minInterval, so an
+// active browser does not turn every request into a write.
+func (r *MongoSessionRepository) TouchLastSeen(id string, at time.Time, minInterval time.Duration) error {
+	_, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "lastSeenAt": bson.M{"$lte": at.Add(-minInterval)}},
+		bson.M{"$set": bson.M{"lastSeenAt": at}})
+	return err
+}
+
+// Delete only matches the user's own sessions.
+func (r *MongoSessionRepository) Delete(userId primitive.ObjectID, id string) error {
+	res, err := r.collection().DeleteOne(context.TODO(), bson.M{"_id": id, "userId": userId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *MongoSessionRepository) DeleteByUser(userId primitive.ObjectID) error {
+	_, err := r.collection().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ExportName and ExportByUser put the active sessions in the account export.
+func (r *MongoSessionRepository) ExportName() string {
+	return "sessions.json"
+}
+
+func (r *MongoSessionRepository) ExportByUser(userId primitive.ObjectID) (interface{}, error) {
+	return r.List(userId)
+}
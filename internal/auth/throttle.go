@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrAccountLocked  = errors.New("too many failed logins, account temporarily locked")
+	ErrLoginThrottled = errors.New("too many failed logins, try again later")
+)
+
+// RetryError wraps ErrAccountLocked or ErrLoginThrottled with the time the
+// client has to wait, which the controller sends as Retry-After. A locked
+// client IP is ErrLoginThrottled: the account itself is not locked.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string { return e.Err.Error() }
+func (e *RetryError) Unwrap() error { return e.Err }
+
+type LoginThrottleConfig struct {
+	MaxUserFailures int           // failures per username before it is locked
+	MaxIPFailures   int           // failures per client IP before it is locked
+	LockoutDuration time.Duration // how long a lock lasts
+	FailureWindow   time.Duration // failures older than this are forgotten
+
+	// After DelayAfter failures every further try has to wait BaseDelay,
+	// doubling per failure up to MaxDelay.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func DefaultLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxUserFailures: 5,
+		MaxIPFailures:   50,
+		LockoutDuration: 15 * time.Minute,
+		FailureWindow:   15 * time.Minute,
+		DelayAfter:      2,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+	}
+}
+
+// Delay is the wait required before the next try after failures failures.
+func (c LoginThrottleConfig) Delay(failures int) time.Duration {
+	if failures <= c.DelayAfter || c.BaseDelay <= 0 {
+		return 0
+	}
+	delay := c.BaseDelay
+	for i := c.DelayAfter + 1; i < failures && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	return delay
+}
+
+// LoginThrottle counts failed password logins per username and per client IP.
+// Counting by username alone would let one attacker lock out everyone;
+// counting by IP alone would not stop a distributed attack on one account.
+type LoginThrottle struct {
+	repo LoginAttemptRepository
+	cfg  LoginThrottleConfig
+}
+
+func NewLoginThrottle(repo LoginAttemptRepository, cfg LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{repo: repo, cfg: cfg}
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check refuses a login attempt while the username or IP is locked or has
+// to wait out its progressive delay.
+func (t *LoginThrottle) Check(username, ip string, now time.Time) error {
+	userKey := userAttemptKey(username)
+	keys := []string{userKey}
+	if ip != "" {
+		keys = append(keys, ipAttemptKey(ip))
+	}
+
+	var wait time.Duration
+	locked := false
+	for _, key := range keys {
+		attempt, err := t.repo.Find(key)
+		if err != nil {
+			continue
+		}
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			locked = locked || key == userKey
+			wait = maxDuration(wait, attempt.LockedUntil.Sub(now))
+			continue
+		}
+		if ready := attempt.LastFailureAt.Add(t.cfg.Delay(attempt.Failures)); ready.After(now) {
+			wait = maxDuration(wait, ready.Sub(now))
+		}
+	}
+
+	switch {
+	case locked:
+		return &RetryError{Err: ErrAccountLocked, RetryAfter: wait}
+	case wait > 0:
+		return &RetryError{Err: ErrLoginThrottled, RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a wrong password. It reports whether this failure
+// locked the username, so the caller can notify the account owner.
+func (t *LoginThrottle) RecordFailure(username, ip string, now time.Time) (bool, error) {
+	if ip != "" {
+		failures, err := t.repo.RecordFailure(ipAttemptKey(ip), now, t.cfg.FailureWindow)
+		if err != nil {
+			return false, err
+		}
+		if failures >= t.cfg.MaxIPFailures {
+			logrus.Warnf("Locking logins from %s after %d failures", ip, failures)
+			if err := t.repo.Lock(ipAttemptKey(ip), now.Add(t.cfg.LockoutDuration)); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	failures, err := t.repo.RecordFailure(userAttemptKey(username), now, t.cfg.FailureWindow)
+	if err != nil {
+		return false, err
+	}
+	if failures < t.cfg.MaxUserFailures {
+		return false, nil
+	}
+	if err := t.repo.Lock(userAttemptKey(username), now.Add(t.cfg.LockoutDuration)); err != nil {
+		return false, err
+	}
+	return failures == t.cfg.MaxUserFailures, nil
+}
+
+// Reset forgets the failures of username, after a successful login or an
+// admin unlock. IP counters are left alone: one good password from an IP
+// says nothing about the other accounts it has been guessing.
+func (t *LoginThrottle) Reset(username string) error {
+	return t.repo.Clear(userAttemptKey(username))
+}
+
+func (t *LoginThrottle) lockoutError() error {
+	return &RetryError{Err: ErrAccountLocked, RetryAfter: t.cfg.LockoutDuration}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"omhs-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnController struct {
+	service *WebAuthnService
+}
+
+func NewWebAuthnController(s *WebAuthnService) *WebAuthnController {
+	return &WebAuthnController{service: s}
+}
+
+func (ctr *WebAuthnController) BeginRegistration(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	options, err := ctr.service.BeginRegistration(userId)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (ctr *WebAuthnController) FinishRegistration(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	cred, err := ctr.service.FinishRegistration(userId, req)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, cred)
+}
+
+func (ctr *WebAuthnController) BeginLogin(c *gin.Context) {
+	var req WebAuthnLoginBeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	options, err := ctr.service.BeginLogin(req)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (ctr *WebAuthnController) FinishLogin(c *gin.Context) {
+	var req WebAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	req.IP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctr.service.FinishLogin(req)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ctr *WebAuthnController) ListCredentials(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	creds, err := ctr.service.ListCredentials(userId)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, creds)
+}
+
+func (ctr *WebAuthnController) DeleteCredential(c *gin.Context) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := ctr.service.DeleteCredential(userId, c.Param("id")); err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "credential deleted"})
+}
+
+func writeWebAuthnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrWebAuthnSession), errors.Is(err, ErrWebAuthnCredential),
+		errors.Is(err, ErrWebAuthnVerification), errors.Is(err, ErrAccountDisabled):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrCredentialRegistered):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrCredentialNotFound), errors.Is(err, ErrWebAuthnNoCredentials):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebAuthnRepository interface {
+	CreateSession(session *WebAuthnSession) error
+	ConsumeSession(id primitive.ObjectID, purpose string) (*WebAuthnSession, error)
+	CreateCredential(cred *WebAuthnCredential) error
+	FindCredential(credentialId []byte) (*WebAuthnCredential, error)
+	ListCredentials(userId primitive.ObjectID) ([]WebAuthnCredential, error)
+	UpdateSignCount(id primitive.ObjectID, oldCount, newCount uint32, at time.Time) (bool, error)
+	DeleteCredential(userId, id primitive.ObjectID) error
+	DeleteByUser(userId primitive.ObjectID) error
+}
+
+type MongoWebAuthnRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoWebAuthnRepository(client *mongo.Client) *MongoWebAuthnRepository {
+	return &MongoWebAuthnRepository{client: client}
+}
+
+func (r *MongoWebAuthnRepository) credentials() *mongo.Collection {
+	return r.client.Database("users").Collection("webauthnCredentials")
+}
+
+func (r *MongoWebAuthnRepository) sessions() *mongo.Collection {
+	return r.client.Database("users").Collection("webauthnSessions")
+}
+
+func (r *MongoWebAuthnRepository) EnsureIndexes() error {
+	_, err := r.credentials().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "credentialId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.sessions().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (r *MongoWebAuthnRepository) CreateSession(session *WebAuthnSession) error {
+	_, err := r.sessions().InsertOne(context.TODO(), session)
+	return err
+}
+
+// ConsumeSession deletes and returns the session, so each challenge is
+// usable exactly once. Expired sessions are treated as missing.
+func (r *MongoWebAuthnRepository) ConsumeSession(id primitive.ObjectID, purpose string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	err := r.sessions().FindOneAndDelete(context.TODO(),
+		bson.M{"_id": id, "purpose": purpose, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *MongoWebAuthnRepository) CreateCredential(cred *WebAuthnCredential) error {
+	_, err := r.credentials().InsertOne(context.TODO(), cred)
+	return err
+}
+
+func (r *MongoWebAuthnRepository) FindCredential(credentialId []byte) (*WebAuthnCredential, error) {
+	var cred WebAuthnCredential
+	err := r.credentials().FindOne(context.TODO(), bson.M{"credentialId": credentialId}).Decode(&cred)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (r *MongoWebAuthnRepository) ListCredentials(userId primitive.ObjectID) ([]WebAuthnCredential, error) {
+	cursor, err := r.credentials().Find(context.TODO(), bson.M{"userId": userId},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	creds := []WebAuthnCredential{}
+	if err := cursor.All(context.TODO(), &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// UpdateSignCount stores the new counter only if nobody else moved it since
+// it was read, so two concurrent assertions cannot both succeed.
+func (r *MongoWebAuthnRepository) UpdateSignCount(id primitive.ObjectID, oldCount, newCount uint32, at time.Time) (bool, error) {
+	res, err := r.credentials().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "signCount": oldCount},
+		bson.M{"$set": bson.M{"signCount": newCount, "lastUsedAt": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (r *MongoWebAuthnRepository) DeleteCredential(userId, id primitive.ObjectID) error {
+	res, err := r.credentials().DeleteOne(context.TODO(), bson.M{"_id": id, "userId": userId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteByUser removes every credential and open ceremony of the user.
+func (r *MongoWebAuthnRepository) DeleteByUser(userId primitive.ObjectID) error {
+	if _, err := r.credentials().DeleteMany(context.TODO(), bson.M{"userId": userId}); err != nil {
+		return err
+	}
+	_, err := r.sessions().DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// ExportName and ExportByUser put the passkeys, without their key material,
+// in the account export.
+func (r *MongoWebAuthnRepository) ExportName() string {
+	return "passkeys.json"
+}
+
+func (r *MongoWebAuthnRepository) ExportByUser(userId primitive.ObjectID) (interface{}, error) {
+	return r.ListCredentials(userId)
+}
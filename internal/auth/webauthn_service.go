@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"omhs-backend/internal/utils"
+	"omhs-backend/internal/webauthn"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	webauthnPurposeRegister = "register"
+	webauthnPurposeLogin    = "login"
+	WebAuthnSessionTTL      = 5 * time.Minute
+)
+
+var (
+	ErrWebAuthnSession       = errors.New("invalid or expired WebAuthn session")
+	ErrWebAuthnCredential    = errors.New("unknown WebAuthn credential")
+	ErrCredentialRegistered  = errors.New("credential is already registered")
+	ErrCredentialNotFound    = errors.New("credential not found")
+	ErrWebAuthnVerification  = errors.New("WebAuthn verification failed")
+	ErrWebAuthnNoCredentials = errors.New("no WebAuthn credentials registered for this user")
+)
+
+// WebAuthnService runs passkey registration and passwordless login. A
+// successful assertion with user verification counts as both factors, so
+// it completes the login directly without the TOTP step.
+type WebAuthnService struct {
+	auth  *AuthService
+	users UserRepository
+	creds WebAuthnRepository
+	cfg   webauthn.Config
+}
+
+func NewWebAuthnService(auth *AuthService, users UserRepository, creds WebAuthnRepository, cfg webauthn.Config) *WebAuthnService {
+	return &WebAuthnService{auth: auth, users: users, creds: creds, cfg: cfg}
+}
+
+// --- REGISTRATION ---
+func (s *WebAuthnService) BeginRegistration(userId primitive.ObjectID) (*WebAuthnBeginResponse, error) {
+	user, err := s.users.FindByID(userId)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	existing, err := s.creds.ListCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.newSession(user.ID, webauthnPurposeRegister)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := webauthn.UserEntity{ID: user.ID[:], Name: user.Username, DisplayName: user.Username}
+	options := s.cfg.CreationOptions(session.Challenge, entity, descriptors(existing))
+	return &WebAuthnBeginResponse{SessionID: session.ID.Hex(), PublicKey: options}, nil
+}
+
+func (s *WebAuthnService) FinishRegistration(userId primitive.ObjectID, req WebAuthnRegisterFinishRequest) (*WebAuthnCredential, error) {
+	session, err := s.consumeSession(req.SessionID, webauthnPurposeRegister)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userId {
+		return nil, ErrWebAuthnSession
+	}
+
+	verified, err := s.cfg.VerifyRegistration(session.Challenge, &req.Credential)
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+
+	if _, err := s.creds.FindCredential(verified.ID); err == nil {
+		return nil, ErrCredentialRegistered
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	cred := &WebAuthnCredential{
+		ID:           utils.NewObjectID(),
+		UserID:       userId,
+		Name:         name,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		AAGUID:       verified.AAGUID,
+		Transports:   verified.Transports,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.creds.CreateCredential(cred); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrCredentialRegistered
+		}
+		return nil, err
+	}
+	return cred, nil
+}
+
+// --- LOGIN ---
+
+// BeginLogin starts an assertion. With a username the allowed credentials are
+// listed; without one the browser may offer any discoverable passkey.
+func (s *WebAuthnService) BeginLogin(req WebAuthnLoginBeginRequest) (*WebAuthnBeginResponse, error) {
+	userId := primitive.NilObjectID
+	var allow []webauthn.CredentialDescriptor
+
+	if req.Username != "" {
+		user, err := s.users.FindByUsername(req.Username)
+		if err != nil {
+			return nil, ErrWebAuthnNoCredentials
+		}
+		creds, err := s.creds.ListCredentials(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(creds) == 0 {
+			return nil, ErrWebAuthnNoCredentials
+		}
+		userId = user.ID
+		allow = descriptors(creds)
+	}
+
+	session, err := s.newSession(userId, webauthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	options := s.cfg.RequestOptions(session.Challenge, allow)
+	return &WebAuthnBeginResponse{SessionID: session.ID.Hex(), PublicKey: options}, nil
+}
+
+func (s *WebAuthnService) FinishLogin(req WebAuthnLoginFinishRequest) (*TokenPair, error) {
+	session, err := s.consumeSession(req.SessionID, webauthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.creds.FindCredential(req.Credential.RawID)
+	if err != nil {
+		return nil, ErrWebAuthnCredential
+	}
+	if !session.UserID.IsZero() && session.UserID != cred.UserID {
+		return nil, ErrWebAuthnCredential
+	}
+	if handle := req.Credential.Response.UserHandle; len(handle) > 0 && !bytes.Equal(handle, cred.UserID[:]) {
+		return nil, ErrWebAuthnCredential
+	}
+
+	count, err := s.cfg.VerifyAssertion(session.Challenge, &req.Credential, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+	updated, err := s.creds.UpdateSignCount(cred.ID, cred.SignCount, count, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrWebAuthnVerification
+	}
+
+	user, err := s.users.FindByID(cred.UserID)
+	if err != nil {
+		return nil, ErrWebAuthnCredential
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	return s.auth.completeLogin(user, ClientInfo{IP: req.IP, UserAgent: req.UserAgent}, LoginMethodWebAuthn)
+}
+
+// --- CREDENTIAL MANAGEMENT ---
+func (s *WebAuthnService) ListCredentials(userId primitive.ObjectID) ([]WebAuthnCredential, error) {
+	return s.creds.ListCredentials(userId)
+}
+
+func (s *WebAuthnService) DeleteCredential(userId primitive.ObjectID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrCredentialNotFound
+	}
+	if err := s.creds.DeleteCredential(userId, objID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrCredentialNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *WebAuthnService) newSession(userId primitive.ObjectID, purpose string) (*WebAuthnSession, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	session := &WebAuthnSession{
+		ID:        utils.NewObjectID(),
+		UserID:    userId,
+		Purpose:   purpose,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(WebAuthnSessionTTL),
+	}
+	if err := s.creds.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *WebAuthnService) consumeSession(id, purpose string) (*WebAuthnSession, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrWebAuthnSession
+	}
+	session, err := s.creds.ConsumeSession(objID, purpose)
+	if err != nil {
+		return nil, ErrWebAuthnSession
+	}
+	return session, nil
+}
+
+func descriptors(creds []WebAuthnCredential) []webauthn.CredentialDescriptor {
+	list := make([]webauthn.CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		list = append(list, webauthn.CredentialDescriptor{Type: "public-key", ID: c.CredentialID, Transports: c.Transports})
+	}
+	return list
+}
@@ -1,10 +1,20 @@
 package kanban
 
 import (
+	"errors"
+
 	"omhs-backend/internal/requests"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Database and Collection hold one board per user, stored through the
+// generic requests repository with the user id as document id.
+const (
+	Database   = "data"
+	Collection = "Kanbans"
 )
 
 type KanbanRepository struct {
@@ -15,9 +25,12 @@ func NewKanbanRepository(req requests.RequestRepository) *KanbanRepository {
 	return &KanbanRepository{req: req}
 }
 
+// Kanbans are keyed by user id, so lookups here are not owner-scoped;
+// that also keeps boards created before the owner field readable.
+
 // Load the board JSON for a specific user
 func (r *KanbanRepository) GetKanban(userId primitive.ObjectID) (map[string]interface{}, error) {
-	doc, err := r.req.Get("data", "Kanbans", userId)
+	doc, err := r.req.Get(Database, Collection, userId, nil)
 
 	if err != nil {
 		logrus.Warnf("KanbanRepo.GetKanban ERROR for %s → %T: %v",
@@ -30,10 +43,19 @@ func (r *KanbanRepository) GetKanban(userId primitive.ObjectID) (map[string]inte
 
 // Create a new Kanban document for this user
 func (r *KanbanRepository) CreateKanban(doc requests.Document) error {
-	return r.req.Create("data", "Kanbans", doc)
+	return r.req.Create(Database, Collection, doc)
 }
 
 // Update an existing Kanban document
 func (r *KanbanRepository) UpdateKanban(userId primitive.ObjectID, data map[string]interface{}) error {
-	return r.req.Update("data", "Kanbans", userId, data)
+	return r.req.Update(Database, Collection, userId, data, nil)
+}
+
+// Delete the user's Kanban document, if there is one
+func (r *KanbanRepository) DeleteKanban(userId primitive.ObjectID) error {
+	err := r.req.Delete(Database, Collection, userId, nil)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	return err
 }
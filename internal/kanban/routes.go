@@ -1,11 +1,19 @@
 package kanban
 
-import "github.com/gin-gonic/gin"
+import (
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
 
 const BasePath = "/kanban"
 
+// RegisterRoutes expects r to already run JWTMiddleware.
 func RegisterRoutes(r *gin.RouterGroup, controller *KanbanController) {
-	group := r.Group(BasePath)
+	group := r.Group(BasePath,
+		middleware.RequireScopes(utils.ScopeKanbanRead, utils.ScopeKanbanWrite),
+		middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
 	{
 		group.GET("", controller.GetKanban)
 		group.POST("", controller.CreateKanban)
@@ -43,8 +43,9 @@ func (s *KanbanService) GetKanban(userId primitive.ObjectID) (map[string]interfa
 
 func (s *KanbanService) CreateKanban(userId primitive.ObjectID, data map[string]interface{}) (*requests.Document, error) {
 	doc := requests.Document{
-		ID:   userId,
-		Data: data,
+		ID:    userId,
+		Owner: userId,
+		Data:  data,
 	}
 
 	if err := s.repo.CreateKanban(doc); err != nil {
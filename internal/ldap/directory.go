@@ -0,0 +1,251 @@
+// Package ldap authenticates users against an LDAP directory: it looks the
+// user up, binds as them to check the password and reads their groups. The
+// protocol itself is left to go-ldap; this package only maps our settings
+// and the directory's answers onto users.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+
+	"omhs-backend/internal/utils"
+)
+
+var ErrInvalidCredentials = errors.New("invalid LDAP credentials")
+
+// Config describes the directory. AdminGroups are group DNs or common names
+// whose members become admins.
+type Config struct {
+	URL                  string
+	StartTLS             bool
+	TLSConfig            *tls.Config
+	BindDN               string
+	BindPassword         string
+	UserBaseDN           string
+	UserAttribute        string
+	UserObjectClass      string
+	EmailAttribute       string
+	DisplayNameAttribute string
+	GroupBaseDN          string
+	GroupMemberAttribute string
+	AdminGroups          []string
+	Timeout              time.Duration
+}
+
+// ConfigFromEnv reads LDAP_URL, LDAP_START_TLS, LDAP_BIND_DN,
+// LDAP_BIND_PASSWORD, LDAP_USER_BASE_DN, LDAP_USER_ATTRIBUTE,
+// LDAP_USER_OBJECT_CLASS, LDAP_EMAIL_ATTRIBUTE, LDAP_DISPLAY_NAME_ATTRIBUTE,
+// LDAP_GROUP_BASE_DN, LDAP_GROUP_MEMBER_ATTRIBUTE and LDAP_ADMIN_GROUPS.
+// Admin groups are separated by semicolons since DNs contain commas.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		URL:                  utils.GetEnv("LDAP_URL", ""),
+		StartTLS:             utils.GetEnv("LDAP_START_TLS", "false") == "true",
+		BindDN:               utils.GetEnv("LDAP_BIND_DN", ""),
+		BindPassword:         utils.GetEnv("LDAP_BIND_PASSWORD", ""),
+		UserBaseDN:           utils.GetEnv("LDAP_USER_BASE_DN", ""),
+		UserAttribute:        utils.GetEnv("LDAP_USER_ATTRIBUTE", "uid"),
+		UserObjectClass:      utils.GetEnv("LDAP_USER_OBJECT_CLASS", "person"),
+		EmailAttribute:       utils.GetEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		DisplayNameAttribute: utils.GetEnv("LDAP_DISPLAY_NAME_ATTRIBUTE", "cn"),
+		GroupBaseDN:          utils.GetEnv("LDAP_GROUP_BASE_DN", ""),
+		GroupMemberAttribute: utils.GetEnv("LDAP_GROUP_MEMBER_ATTRIBUTE", "member"),
+		Timeout:              10 * time.Second,
+	}
+	for _, g := range strings.Split(utils.GetEnv("LDAP_ADMIN_GROUPS", ""), ";") {
+		if g = strings.TrimSpace(g); g != "" {
+			cfg.AdminGroups = append(cfg.AdminGroups, g)
+		}
+	}
+	if cfg.URL == "" || cfg.UserBaseDN == "" {
+		return cfg, errors.New("LDAP needs LDAP_URL and LDAP_USER_BASE_DN")
+	}
+	return cfg, nil
+}
+
+// Identity is what the directory says about an authenticated user.
+type Identity struct {
+	DN          string
+	Username    string
+	Email       string
+	DisplayName string
+	Groups      []string
+	IsAdmin     bool
+}
+
+type Directory struct {
+	cfg Config
+}
+
+func NewDirectory(cfg Config) *Directory {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Directory{cfg: cfg}
+}
+
+// Authenticate checks username and password. Unknown users and wrong
+// passwords both return ErrInvalidCredentials; anything else means the
+// directory could not be asked.
+func (d *Directory) Authenticate(username, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.cfg.BindDN != "" {
+		if err := bind(conn, d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service bind: %w", serviceError(err))
+		}
+	}
+
+	filter := fmt.Sprintf("(&(objectClass=%s)(%s=%s))",
+		goldap.EscapeFilter(d.cfg.UserObjectClass), d.cfg.UserAttribute, goldap.EscapeFilter(username))
+	entries, err := d.search(conn, d.cfg.UserBaseDN, filter, 2,
+		d.cfg.UserAttribute, d.cfg.EmailAttribute, d.cfg.DisplayNameAttribute, "memberOf")
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	// an ambiguous match is treated like none
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	user := entries[0]
+
+	if err := bind(conn, user.DN, password); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		DN:          user.DN,
+		Username:    user.GetEqualFoldAttributeValue(d.cfg.UserAttribute),
+		Email:       user.GetEqualFoldAttributeValue(d.cfg.EmailAttribute),
+		DisplayName: user.GetEqualFoldAttributeValue(d.cfg.DisplayNameAttribute),
+		Groups:      user.GetEqualFoldAttributeValues("memberOf"),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+
+	if d.cfg.GroupBaseDN != "" {
+		filter := fmt.Sprintf("(%s=%s)", d.cfg.GroupMemberAttribute, goldap.EscapeFilter(user.DN))
+		groups, err := d.search(conn, d.cfg.GroupBaseDN, filter, 0, "cn")
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			identity.Groups = append(identity.Groups, g.DN)
+		}
+	}
+
+	identity.IsAdmin = d.isAdmin(identity.Groups)
+	return identity, nil
+}
+
+// dial connects to the configured ldap:// or ldaps:// URL. With StartTLS a
+// plain connection is upgraded before anything else is sent.
+func (d *Directory) dial() (*goldap.Conn, error) {
+	u, err := url.Parse(d.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	tlsConfig := d.cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	conn, err := goldap.DialURL(d.cfg.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: d.cfg.Timeout}),
+		goldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(d.cfg.Timeout)
+	if d.cfg.StartTLS && u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// bind authenticates with a simple bind. An empty password is refused here:
+// servers treat it as an unauthenticated bind that always succeeds.
+func bind(conn *goldap.Conn, dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+	err := conn.Bind(dn, password)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// search runs a subtree search. A missing base DN is an empty result, not an
+// error.
+func (d *Directory) search(conn *goldap.Conn, baseDN, filter string, sizeLimit int, attributes ...string) ([]*goldap.Entry, error) {
+	req := goldap.NewSearchRequest(baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		sizeLimit, int(d.cfg.Timeout/time.Second), false, filter, attributes, nil)
+	res, err := conn.Search(req)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+// serviceError keeps a rejected service account from looking like a wrong
+// user password.
+func serviceError(err error) error {
+	if errors.Is(err, ErrInvalidCredentials) {
+		return errors.New("LDAP_BIND_DN credentials rejected")
+	}
+	return err
+}
+
+func (d *Directory) isAdmin(groups []string) bool {
+	for _, group := range groups {
+		for _, admin := range d.cfg.AdminGroups {
+			if strings.EqualFold(group, admin) || strings.EqualFold(commonName(group), admin) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commonName returns the value of the first RDN, e.g. "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com".
+func commonName(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return rdn
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+
+	"omhs-backend/internal/utils"
+)
+
+// Driver names accepted in MAIL_DRIVER.
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverLog    = "log"
+	DriverMemory = "memory"
+)
+
+// FromEnv builds the Mailer selected by MAIL_DRIVER. The SMTP driver reads
+// EMAIL_HOST, EMAIL_PORT, EMAIL_USER, EMAIL_PASS, EMAIL_FROM and EMAIL_TLS;
+// the file driver writes to the maildir in MAIL_DIR.
+func FromEnv() (Mailer, error) {
+	from := utils.GetEnv("EMAIL_FROM", os.Getenv("EMAIL_USER"))
+
+	switch driver := utils.GetEnv("MAIL_DRIVER", DriverSMTP); driver {
+	case DriverSMTP:
+		port := utils.GetEnv("EMAIL_PORT", "587")
+		mode := utils.GetEnv("EMAIL_TLS", DefaultTLSMode(port))
+		if mode != TLSImplicit && mode != TLSStartTLS && mode != TLSNone {
+			return nil, fmt.Errorf("unknown EMAIL_TLS mode %q", mode)
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("EMAIL_HOST"),
+			Port:     port,
+			Username: os.Getenv("EMAIL_USER"),
+			Password: os.Getenv("EMAIL_PASS"),
+			From:     from,
+			TLS:      mode,
+		}), nil
+	case DriverFile:
+		return NewFileMailer(utils.GetEnv("MAIL_DIR", "mail"), from)
+	case DriverLog:
+		return NewLogMailer(), nil
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
@@ -0,0 +1,22 @@
+package mail
+
+import "github.com/sirupsen/logrus"
+
+// LogMailer writes messages to the application log instead of sending them.
+// Only meant for development: bodies may contain reset codes and links.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Info(msg.Text)
+	return nil
+}
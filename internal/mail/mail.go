@@ -0,0 +1,125 @@
+// Package mail delivers outgoing email through a pluggable Mailer. Drivers
+// exist for SMTP, a maildir on disk, the application log and an in-memory
+// capture used by tests.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMessage = errors.New("message needs a recipient and a subject")
+
+// Message is a single outgoing email. HTML is optional; when set the message
+// is sent as multipart/alternative with Text as the fallback part. ID is an
+// optional idempotency key: the outbox stores a given ID only once, and it
+// becomes the Message-ID so retried deliveries can be recognised as the same.
+type Message struct {
+	ID      string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Key builds a Message ID from the template and what the email is about,
+// such as the user and the token it carries, so the same email is only
+// queued once however often its sender is retried. Parts must not contain
+// characters a Message-ID cannot, so use ids and hashes rather than
+// addresses.
+func Key(template string, parts ...string) string {
+	return strings.Join(append([]string{template}, parts...), ".")
+}
+
+// Mailer sends a message or reports why it could not be delivered.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+func (m Message) validate() error {
+	if strings.TrimSpace(m.To) == "" || strings.TrimSpace(m.Subject) == "" {
+		return ErrInvalidMessage
+	}
+	// Header values must not smuggle in extra headers.
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+// Bytes renders the message as an RFC 5322 document sent from from.
+func (m Message) Bytes(from string) []byte {
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+
+	writeHeader("From", from)
+	writeHeader("To", m.To)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID(m.ID, from))
+	writeHeader("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		writeHeader("Content-Type", "text/plain; charset=UTF-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		writeQuotedPrintable(&buf, m.Text)
+		return buf.Bytes()
+	}
+
+	parts := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+
+	// Clients show the last part they understand, so HTML goes last.
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(w, part.body)
+	}
+	parts.Close()
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(normalizeNewlines(body)))
+	qp.Close()
+	w.Write([]byte("\r\n"))
+}
+
+func normalizeNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// messageID returns a Message-ID in the sender's domain, random unless the
+// message carries its own ID.
+func messageID(id, from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.TrimSuffix(from[at+1:], ">")
+	}
+	if id == "" || strings.ContainsAny(id, "<>@ \r\n") {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return "<" + id + "@" + domain + ">"
+}
@@ -0,0 +1,95 @@
+package mail
+
+import (
+	"errors"
+	"time"
+
+	"omhs-backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Outbox message states. Messages move pending -> sending -> sent, or back
+// to pending with a later NextAttemptAt on failure, and finally to dead once
+// MaxAttempts is exhausted. Dead messages stay until an admin retries them
+// or DeadRetention runs out.
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+var (
+	ErrOutboxNotFound = errors.New("outbox message not found")
+	ErrNotRetryable   = errors.New("only dead messages can be retried")
+)
+
+// OutboxMessage is a queued email. Bodies are kept out of JSON because they
+// may carry reset codes and sign-in links.
+type OutboxMessage struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key           string             `bson:"key" json:"key"`
+	To            string             `bson:"to" json:"to"`
+	Subject       string             `bson:"subject" json:"subject"`
+	Text          string             `bson:"text" json:"-"`
+	HTML          string             `bson:"html,omitempty" json:"-"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	LastError     string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LeaseUntil    time.Time          `bson:"leaseUntil,omitempty" json:"-"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	SentAt        *time.Time         `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+}
+
+func (m *OutboxMessage) Message() Message {
+	return Message{ID: m.Key, To: m.To, Subject: m.Subject, Text: m.Text, HTML: m.HTML}
+}
+
+type OutboxPage struct {
+	Messages []OutboxMessage `json:"messages"`
+	Total    int64           `json:"total"`
+}
+
+// Outbox is a Mailer that only records messages; a Worker delivers them.
+// Send succeeds as long as the message is stored, so callers are no longer
+// coupled to the availability of the SMTP server.
+type Outbox struct {
+	repo OutboxRepository
+}
+
+func NewOutbox(repo OutboxRepository) *Outbox {
+	return &Outbox{repo: repo}
+}
+
+func (o *Outbox) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	id := utils.NewObjectID()
+	key := msg.ID
+	if key == "" {
+		key = id.Hex()
+	}
+
+	now := time.Now()
+	err := o.repo.Enqueue(&OutboxMessage{
+		ID:            id,
+		Key:           key,
+		To:            msg.To,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	// The same key was queued before: sending it again is a no-op.
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SentRetention is how long delivered messages are kept for inspection.
+	SentRetention = 30 * 24 * time.Hour
+	// DeadRetention is how long, counted from when it was queued, a message
+	// that could not be delivered waits for an admin retry.
+	DeadRetention = 30 * 24 * time.Hour
+)
+
+type OutboxRepository interface {
+	Enqueue(msg *OutboxMessage) error
+	ClaimNext(now time.Time, lease time.Duration) (*OutboxMessage, error)
+	MarkSent(id primitive.ObjectID, at time.Time) error
+	MarkFailed(id primitive.ObjectID, errMsg string, nextAttempt time.Time, dead bool) error
+	List(status string, skip, limit int64) ([]OutboxMessage, int64, error)
+	FindByID(id primitive.ObjectID) (*OutboxMessage, error)
+	Retry(id primitive.ObjectID, at time.Time) error
+}
+
+type MongoOutboxRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoOutboxRepository(client *mongo.Client) *MongoOutboxRepository {
+	return &MongoOutboxRepository{client: client}
+}
+
+func (r *MongoOutboxRepository) collection() *mongo.Collection {
+	return r.client.Database("mail").Collection("outbox")
+}
+
+// EnsureIndexes makes keys unique, supports the worker's polling query and
+// lets Mongo drop delivered messages after SentRetention and dead ones after
+// DeadRetention.
+func (r *MongoOutboxRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "sentAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(SentRetention.Seconds()))},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().
+			SetExpireAfterSeconds(int32(DeadRetention.Seconds())).
+			SetPartialFilterExpression(bson.M{"status": StatusDead})},
+	})
+	return err
+}
+
+func (r *MongoOutboxRepository) Enqueue(msg *OutboxMessage) error {
+	_, err := r.collection().InsertOne(context.TODO(), msg)
+	return err
+}
+
+// ClaimNext atomically leases the oldest due message to the caller. Messages
+// whose lease ran out (a worker died mid-send) are picked up again.
+func (r *MongoOutboxRepository) ClaimNext(now time.Time, lease time.Duration) (*OutboxMessage, error) {
+	filter := bson.M{"$or": []bson.M{
+		{"status": StatusPending, "nextAttemptAt": bson.M{"$lte": now}},
+		{"status": StatusSending, "leaseUntil": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": StatusSending, "leaseUntil": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var msg OutboxMessage
+	if err := r.collection().FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// MarkSent drops the bodies along with the lease: once delivered, the reset
+// codes and sign-in links in them have no reason to stay in the database.
+func (r *MongoOutboxRepository) MarkSent(id primitive.ObjectID, at time.Time) error {
+	_, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "status": StatusSending},
+		bson.M{"$set": bson.M{"status": StatusSent, "sentAt": at},
+			"$unset": bson.M{"lastError": "", "leaseUntil": "", "text": "", "html": ""}})
+	return err
+}
+
+func (r *MongoOutboxRepository) MarkFailed(id primitive.ObjectID, errMsg string, nextAttempt time.Time, dead bool) error {
+	status := StatusPending
+	if dead {
+		status = StatusDead
+	}
+	_, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "status": StatusSending},
+		bson.M{"$set": bson.M{"status": status, "lastError": errMsg, "nextAttemptAt": nextAttempt}, "$unset": bson.M{"leaseUntil": ""}})
+	return err
+}
+
+func (r *MongoOutboxRepository) List(status string, skip, limit int64) ([]OutboxMessage, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := r.collection().CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetSkip(skip).SetLimit(limit)
+	cursor, err := r.collection().Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	messages := []OutboxMessage{}
+	if err := cursor.All(context.TODO(), &messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+func (r *MongoOutboxRepository) FindByID(id primitive.ObjectID) (*OutboxMessage, error) {
+	var msg OutboxMessage
+	if err := r.collection().FindOne(context.TODO(), bson.M{"_id": id}).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Retry puts a dead message back in the queue with a fresh attempt budget.
+func (r *MongoOutboxRepository) Retry(id primitive.ObjectID, at time.Time) error {
+	res, err := r.collection().UpdateOne(context.TODO(),
+		bson.M{"_id": id, "status": StatusDead},
+		bson.M{"$set": bson.M{"status": StatusPending, "attempts": 0, "nextAttemptAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
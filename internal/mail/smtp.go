@@ -0,0 +1,146 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TLS modes for SMTPConfig.TLS.
+const (
+	TLSImplicit = "implicit" // TLS from the first byte, usually port 465
+	TLSStartTLS = "starttls" // upgrade a plain connection, usually port 587
+	TLSNone     = "none"     // plain text, only for local relays
+)
+
+var ErrStartTLSUnsupported = errors.New("SMTP server does not support STARTTLS")
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string // empty disables AUTH, e.g. for a local relay
+	Password string
+	From     string
+	TLS      string
+	Timeout  time.Duration
+
+	// TLSConfig overrides the default client TLS settings (server name only).
+	TLSConfig *tls.Config
+}
+
+// DefaultTLSMode picks the TLS mode conventionally used on port.
+func DefaultTLSMode(port string) string {
+	switch port {
+	case "465":
+		return TLSImplicit
+	case "587":
+		return TLSStartTLS
+	default:
+		return TLSNone
+	}
+}
+
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	if cfg.TLS == "" {
+		cfg.TLS = DefaultTLSMode(cfg.Port)
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	c, err := m.dial()
+	if err != nil {
+		logrus.Errorf("SMTP connect error: %v", err)
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+		if err := c.StartTLS(m.tlsConfig()); err != nil {
+			return err
+		}
+	}
+
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes(m.cfg.From)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := c.Quit(); err != nil {
+		return err
+	}
+
+	logrus.Infof("Email sent successfully to %s", msg.To)
+	return nil
+}
+
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	dialer := &net.Dialer{Timeout: m.cfg.Timeout}
+
+	var conn net.Conn
+	var err error
+	if m.cfg.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, m.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the whole conversation, not just the dial.
+	conn.SetDeadline(time.Now().Add(m.cfg.Timeout))
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *SMTPMailer) tlsConfig() *tls.Config {
+	if m.cfg.TLSConfig != nil {
+		return m.cfg.TLSConfig
+	}
+	return &tls.Config{ServerName: m.cfg.Host}
+}
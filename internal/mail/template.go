@@ -0,0 +1,195 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names shipped in templates/<locale>/<name>.{txt,html}. The .txt
+// file defines the "subject" template and holds the plain-text body; the
+// .html file defines "content", rendered inside templates/layout.html.
+const (
+	TemplateVerifyEmail     = "verify-email"
+	TemplatePasswordReset   = "password-reset"
+	TemplateAccountLocked   = "account-locked"
+	TemplateAccountDeletion = "account-deletion"
+	TemplateNewLogin        = "new-login"
+	TemplateInvite          = "invite"
+	TemplateMagicLink       = "magic-link"
+)
+
+// DefaultLocale is used when a user has no locale or one we have no translation for.
+const DefaultLocale = "en"
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Data is what templates are executed with. Render adds Subject and Locale.
+type Data map[string]interface{}
+
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates maps locale -> name -> parsed template. Parsed once at startup so
+// a broken template fails the build's tests rather than a user's request.
+var templates = mustParseTemplates()
+
+func mustParseTemplates() map[string]map[string]*emailTemplate {
+	layout := htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html"))
+
+	locales, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	parsed := map[string]map[string]*emailTemplate{}
+	for _, dir := range locales {
+		if !dir.IsDir() {
+			continue
+		}
+		locale := dir.Name()
+		files, _ := fs.Glob(templateFS, path.Join("templates", locale, "*.txt"))
+
+		parsed[locale] = map[string]*emailTemplate{}
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+			html := htmltemplate.Must(htmltemplate.Must(layout.Clone()).ParseFS(templateFS, strings.TrimSuffix(file, ".txt")+".html"))
+			text := texttemplate.Must(texttemplate.New(path.Base(file)).ParseFS(templateFS, file))
+			parsed[locale][name] = &emailTemplate{text: text, html: html}
+		}
+	}
+	return parsed
+}
+
+// Locales lists the locales we have templates for.
+func Locales() []string {
+	list := make([]string, 0, len(templates))
+	for locale := range templates {
+		list = append(list, locale)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// TemplateNames lists the templates available in the default locale.
+func TemplateNames() []string {
+	list := make([]string, 0, len(templates[DefaultLocale]))
+	for name := range templates[DefaultLocale] {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// MatchLocale maps a tag such as "de-AT" or "de_at" to a supported locale,
+// falling back to the base language and then to DefaultLocale.
+func MatchLocale(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if _, ok := templates[tag]; ok {
+		return tag
+	}
+	if base, _, found := strings.Cut(tag, "-"); found {
+		if _, ok := templates[base]; ok {
+			return base
+		}
+	}
+	return DefaultLocale
+}
+
+// Render builds a message from template name in the closest available
+// locale. The caller fills in the recipient.
+func Render(name, locale string, data Data) (Message, error) {
+	locale = MatchLocale(locale)
+	tmpl, ok := templates[locale][name]
+	if !ok {
+		locale = DefaultLocale
+		if tmpl, ok = templates[locale][name]; !ok {
+			return Message{}, ErrUnknownTemplate
+		}
+	}
+
+	vars := Data{}
+	for k, v := range data {
+		vars[k] = v
+	}
+	vars["Locale"] = locale
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", vars); err != nil {
+		return Message{}, err
+	}
+	vars["Subject"] = strings.TrimSpace(subject.String())
+
+	if err := tmpl.text.Execute(&text, vars); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", vars); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: vars["Subject"].(string),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// previewData is sample data for rendering each template in the admin preview.
+var previewData = map[string]Data{
+	TemplateVerifyEmail: {
+		"Username": "jane.doe",
+		"Link":     "https://example.com/api/auth/verify-email?token=preview",
+		"Hours":    24,
+	},
+	TemplatePasswordReset: {
+		"Username": "jane.doe",
+		"Passkey":  "1a2b3c4d",
+		"Minutes":  10,
+	},
+	TemplateAccountLocked: {
+		"Username": "jane.doe",
+		"Minutes":  15,
+		"IP":       "203.0.113.7",
+	},
+	TemplateAccountDeletion: {
+		"Username": "jane.doe",
+		"Date":     "2025-01-31",
+		"Days":     14,
+	},
+	TemplateNewLogin: {
+		"Username": "jane.doe",
+		"Device":   "Firefox on Windows",
+		"IP":       "203.0.113.7",
+		"Time":     "2025-01-31 09:41 UTC",
+	},
+	TemplateInvite: {
+		"InvitedBy": "admin",
+		"Code":      "3f9c2a6e0b41d7e58a1c9f2b6d4e7a10",
+		"Days":      7,
+	},
+	TemplateMagicLink: {
+		"Username": "jane.doe",
+		"Link":     "https://example.com/api/auth/magic-link/consume?token=preview",
+		"Minutes":  15,
+	},
+}
+
+// Preview renders name with sample data, for checking templates without sending.
+func Preview(name, locale string) (Message, error) {
+	data, ok := previewData[name]
+	if !ok {
+		return Message{}, ErrUnknownTemplate
+	}
+	return Render(name, locale, data)
+}
@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type WorkerConfig struct {
+	PollInterval time.Duration // wait between polls when the queue is empty
+	Lease        time.Duration // how long a claimed message is reserved
+	MaxAttempts  int           // attempts before a message goes dead
+	BaseDelay    time.Duration // delay after the first failure, doubled each time
+	MaxDelay     time.Duration
+}
+
+func DefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		PollInterval: 5 * time.Second,
+		Lease:        2 * time.Minute,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+	}
+}
+
+// Worker delivers outbox messages through a real Mailer. Several workers may
+// run against the same collection: claims are atomic.
+type Worker struct {
+	repo     OutboxRepository
+	delivery Mailer
+	cfg      WorkerConfig
+}
+
+func NewWorker(repo OutboxRepository, delivery Mailer, cfg WorkerConfig) *Worker {
+	return &Worker{repo: repo, delivery: delivery, cfg: cfg}
+}
+
+// Run delivers messages until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	for {
+		processed, err := w.ProcessNext()
+		if err != nil {
+			logrus.Errorf("Outbox worker error: %v", err)
+		}
+		if processed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.cfg.PollInterval):
+		}
+	}
+}
+
+// ProcessNext delivers at most one due message. It reports whether there
+// was one, so callers can drain the queue without waiting.
+func (w *Worker) ProcessNext() (bool, error) {
+	now := time.Now()
+	msg, err := w.repo.ClaimNext(now, w.cfg.Lease)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	sendErr := w.delivery.Send(msg.Message())
+	if sendErr == nil {
+		return true, w.repo.MarkSent(msg.ID, time.Now())
+	}
+
+	dead := msg.Attempts >= w.cfg.MaxAttempts
+	if dead {
+		logrus.Errorf("Giving up on email %s to %s after %d attempts: %v", msg.ID.Hex(), msg.To, msg.Attempts, sendErr)
+	} else {
+		logrus.Warnf("Email %s to %s failed (attempt %d): %v", msg.ID.Hex(), msg.To, msg.Attempts, sendErr)
+	}
+	return true, w.repo.MarkFailed(msg.ID, sendErr.Error(), now.Add(w.Backoff(msg.Attempts)), dead)
+}
+
+// Backoff is the delay before the next try after the given number of attempts.
+func (w *Worker) Backoff(attempts int) time.Duration {
+	delay := w.cfg.BaseDelay
+	for i := 1; i < attempts && delay < w.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > w.cfg.MaxDelay {
+		delay = w.cfg.MaxDelay
+	}
+	return delay
+}
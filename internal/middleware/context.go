@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CurrentUserID returns the caller's id set by JWTMiddleware, writing a 401
+// response when it is missing.
+func CurrentUserID(c *gin.Context) (primitive.ObjectID, bool) {
+	val, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "no userId in token"})
+		return primitive.NilObjectID, false
+	}
+	return val.(primitive.ObjectID), true
+}
+
+// CurrentSessionID returns the session of the caller's access token, or ""
+// for personal access tokens, which are not tied to a login.
+func CurrentSessionID(c *gin.Context) string {
+	return c.GetString("sessionId")
+}
+
+// CurrentActorID returns the admin acting as the caller through an
+// impersonation token.
+func CurrentActorID(c *gin.Context) (primitive.ObjectID, bool) {
+	val, exists := c.Get("actorId")
+	if !exists {
+		return primitive.NilObjectID, false
+	}
+	return val.(primitive.ObjectID), true
+}
@@ -6,11 +6,33 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func JWTMiddleware() gin.HandlerFunc {
+// ImpersonationHeader names the admin acting as the user on every response
+// to an impersonation token, so frontends can show it.
+const ImpersonationHeader = "X-Impersonated-By"
+
+// SessionValidator reports whether the session behind an access token is
+// still active, and resolves personal access tokens to the user they act for.
+// Requests made with impersonation tokens are checked and audited through it
+// as well.
+type SessionValidator interface {
+	ValidateSession(userId primitive.ObjectID, sessionId string) error
+	ValidatePersonalAccessToken(token string) (*TokenPrincipal, error)
+	ValidateImpersonation(actorId, userId primitive.ObjectID, sessionId string) error
+	RecordImpersonatedRequest(actorId, userId primitive.ObjectID, method, path string, status int, ip string)
+}
+
+// TokenPrincipal is the caller behind a personal access token.
+type TokenPrincipal struct {
+	UserID   primitive.ObjectID
+	Username string
+	Roles    []string
+	Scopes   []string
+}
+
+func JWTMiddleware(sessions SessionValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		header := c.GetHeader("Authorization")
 		if header == "" {
@@ -28,27 +50,73 @@ func JWTMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return utils.JwtSecret, nil
-		})
+		// Personal access tokens are opaque; they only reach routes that
+		// declare the scopes they need, see RequireScopes.
+		if strings.HasPrefix(tokenString, utils.PersonalAccessTokenPrefix) {
+			principal, err := sessions.ValidatePersonalAccessToken(tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
 
-		if err != nil || !token.Valid {
+			c.Set("userId", principal.UserID)
+			c.Set("username", principal.Username)
+			c.Set("roles", principal.Roles)
+			c.Set("scopes", principal.Scopes)
+			c.Next()
+			return
+		}
+
+		claims, err := utils.ParseJWT(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		userIdStr := claims["userId"].(string)
-
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		userId, err := primitive.ObjectIDFromHex(claims.UserID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token userId"})
 			c.Abort()
 			return
 		}
 
+		// An admin acting as the user: the session is the admin's, and the
+		// request ends up in the audit log whatever its outcome.
+		if claims.Actor != nil {
+			actorId, err := primitive.ObjectIDFromHex(claims.Actor.Subject)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token actor"})
+				c.Abort()
+				return
+			}
+			if err := sessions.ValidateImpersonation(actorId, userId, claims.SessionID); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			c.Set("userId", userId)
+			c.Set("username", claims.Username)
+			c.Set("roles", claims.Roles)
+			c.Set("actorId", actorId)
+			c.Header(ImpersonationHeader, claims.Actor.Username)
+			c.Next()
+			sessions.RecordImpersonatedRequest(actorId, userId, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP())
+			return
+		}
+
+		if err := sessions.ValidateSession(userId, claims.SessionID); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
 		c.Set("userId", userId)
+		c.Set("username", claims.Username)
+		c.Set("roles", claims.Roles)
+		c.Set("sessionId", claims.SessionID)
 		c.Next()
 	}
 }
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+
+	"omhs-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole lets the request through if the caller holds any of the given roles.
+// It must be mounted after JWTMiddleware, which puts the token's roles in the context.
+// Personal access tokens are refused unless RequireScopes ran first and passed.
+func RequireRole(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("roles"); !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			c.Abort()
+			return
+		}
+
+		if _, scoped := c.Get("scopes"); scoped && !c.GetBool("scopeChecked") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "personal access tokens cannot be used here"})
+			c.Abort()
+			return
+		}
+
+		for _, role := range allowed {
+			if HasRole(c, role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// HasRole reports whether the authenticated caller holds the given role.
+func HasRole(c *gin.Context, role string) bool {
+	val, exists := c.Get("roles")
+	if !exists {
+		return false
+	}
+	roles, _ := val.([]string)
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScopes opens a route group to personal access tokens: safe methods
+// need the read scope, everything else the write scope. Callers with a
+// session token pass untouched. Mount it before RequireRole.
+func RequireScopes(read, write string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		val, scoped := c.Get("scopes")
+		if !scoped {
+			c.Next()
+			return
+		}
+
+		needed := write
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			needed = read
+		}
+
+		granted, _ := val.([]string)
+		if !utils.ScopeAllows(granted, needed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "token is missing the " + needed + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Set("scopeChecked", true)
+		c.Next()
+	}
+}
+
+// RefuseImpersonation keeps impersonation tokens away from routes that
+// change how the account is logged into or whether it exists, or that hand
+// out all of its data, so an admin acting as the user cannot keep access
+// past the token, log the user out, or take more than support needs.
+func RefuseImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonated := CurrentActorID(c); impersonated {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating a user"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
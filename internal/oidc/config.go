@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"omhs-backend/internal/utils"
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Config describes one external identity provider. Name is the path segment
+// under /api/auth/oidc/ and must stay stable: linked identities refer to it.
+type Config struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+}
+
+// ConfigsFromEnv reads the comma-separated OIDC_PROVIDERS and, for each name,
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET,
+// OIDC_<NAME>_SCOPES, OIDC_<NAME>_DISPLAY_NAME and OIDC_<NAME>_REDIRECT_URL.
+// A listed provider without issuer or client id is an error, so a typo does
+// not silently remove a login option.
+func ConfigsFromEnv() ([]Config, error) {
+	var configs []Config
+	for _, name := range strings.Split(utils.GetEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		// "providers" and "identities" are routes of their own
+		if !providerNamePattern.MatchString(name) || name == "providers" || name == "identities" {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := Config{
+			Name:         name,
+			DisplayName:  utils.GetEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:       strings.TrimRight(utils.GetEnv(prefix+"ISSUER", ""), "/"),
+			ClientID:     utils.GetEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: utils.GetEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(utils.GetEnv(prefix+"SCOPES", "openid email profile")),
+			RedirectURL:  utils.GetEnv(prefix+"REDIRECT_URL", DefaultRedirectURL(name)),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// DefaultRedirectURL is the backend callback for provider. A frontend may be
+// registered instead, as long as it forwards code and state to the callback.
+func DefaultRedirectURL(name string) string {
+	return fmt.Sprintf("%s/api/auth/oidc/%s/callback", utils.PublicURL(), name)
+}
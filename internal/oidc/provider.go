@@ -0,0 +1,323 @@
+// Package oidc implements the relying-party side of OpenID Connect: the
+// authorization code flow with PKCE and ID token verification against the
+// provider's published keys. Storing state and linking accounts is left to
+// callers.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// discoveryTTL bounds how long provider metadata and keys are cached.
+	discoveryTTL = time.Hour
+	// keyRefreshCooldown limits refetching the JWKS for unknown kids.
+	keyRefreshCooldown = time.Minute
+	clockLeeway        = time.Minute
+	maxResponseSize    = 1 << 20
+)
+
+var (
+	ErrDiscovery    = errors.New("OIDC provider metadata unavailable")
+	ErrExchange     = errors.New("authorization code exchange failed")
+	ErrInvalidToken = errors.New("invalid ID token")
+)
+
+// Metadata is the part of the provider's discovery document we use.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Claims are the ID token claims used for login and account linking.
+// EmailVerified accepts the string form some providers send.
+type Claims struct {
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp,omitempty"`
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = flexBool(t)
+	case string:
+		*b = flexBool(strings.EqualFold(t, "true"))
+	}
+	return nil
+}
+
+// Provider talks to one identity provider. Metadata and keys are fetched on
+// first use, so an unreachable provider does not stop the server starting.
+type Provider struct {
+	cfg  Config
+	http *http.Client
+
+	mu          sync.Mutex
+	meta        *Metadata
+	metaFetched time.Time
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// NewProvider uses httpClient for every request to the provider, or a
+// client with a short timeout when it is nil.
+func NewProvider(cfg Config, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, http: httpClient}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+// NewVerifier returns a random PKCE code verifier (RFC 7636).
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewNonce returns a random value to bind an ID token to one login attempt.
+func NewNonce() (string, error) {
+	return randomString(24)
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func challengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the browser is sent to log in at the provider.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", ErrDiscovery
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challengeS256(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token
+// claims. nonce and verifier are the values used for AuthCodeURL.
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	// client_secret_basic is the default; some providers only take the
+	// secret in the body
+	basic := p.cfg.ClientSecret != "" && (len(meta.TokenAuthMethods) == 0 || contains(meta.TokenAuthMethods, "client_secret_basic"))
+	if p.cfg.ClientSecret != "" && !basic {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.doJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+	return p.verifyIDToken(tokens.IDToken, meta.Issuer, nonce)
+}
+
+func (p *Provider) verifyIDToken(raw, issuer, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if !keyMatchesAlg(key, token.Method.Alg()) {
+			return nil, ErrInvalidToken
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidToken
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// metadata returns the cached discovery document, refetching it once it is
+// older than discoveryTTL. The issuer must match the configured one exactly.
+func (p *Provider) metadata() (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && time.Since(p.metaFetched) < discoveryTTL {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta Metadata
+	if err := p.doJSON(req, &meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.cfg.Issuer || meta.AuthorizationEndpoint == "" ||
+		meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, ErrDiscovery
+	}
+
+	p.meta, p.metaFetched = &meta, time.Now()
+	return p.meta, nil
+}
+
+// key looks kid up in the provider's JWKS, refetching it when kid is unknown
+// since the provider may have rotated.
+func (p *Provider) key(kid string) (crypto.PublicKey, error) {
+	meta, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysFetched) < discoveryTTL {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshCooldown {
+		return nil, ErrInvalidToken
+	}
+
+	req, err := http.NewRequest(http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwkSet
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
+
+func (p *Provider) doJSON(req *http.Request, out interface{}) error {
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", req.URL.Path, resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
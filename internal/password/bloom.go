@@ -0,0 +1,114 @@
+package password
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+)
+
+//go:generate go run genbloom.go -in data/common-passwords.txt -out breached.bloom
+
+var errInvalidBloom = errors.New("invalid bloom filter data")
+
+const bloomMagic = "OMHSBLM1"
+
+// BloomFilter answers "possibly in the set" or "definitely not". Members are
+// addressed by their SHA-1 digest only, the same k-anonymity friendly form
+// the Have I Been Pwned range API uses, so neither the bundled filter nor a
+// lookup ever handles a plaintext list.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint32
+}
+
+func NewBloomFilter(m uint64, k uint32) *BloomFilter {
+	if m == 0 {
+		m = 1
+	}
+	return &BloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// indexes derives k bit positions from the digest by double hashing.
+func (f *BloomFilter) indexes(password string) []uint64 {
+	sum := sha1.Sum([]byte(password))
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+
+	idx := make([]uint64, f.k)
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % f.m
+	}
+	return idx
+}
+
+func (f *BloomFilter) Add(password string) {
+	for _, i := range f.indexes(password) {
+		f.bits[i/64] |= 1 << (i % 64)
+	}
+}
+
+func (f *BloomFilter) Contains(password string) bool {
+	for _, i := range f.indexes(password) {
+		if f.bits[i/64]&(1<<(i%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary writes magic, m, k and the bit array, big endian.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, len(bloomMagic)+12+8*len(f.bits))
+	data = append(data, bloomMagic...)
+	data = binary.BigEndian.AppendUint64(data, f.m)
+	data = binary.BigEndian.AppendUint32(data, f.k)
+	for _, word := range f.bits {
+		data = binary.BigEndian.AppendUint64(data, word)
+	}
+	return data, nil
+}
+
+func (f *BloomFilter) UnmarshalBinary(data []byte) error {
+	header := len(bloomMagic) + 12
+	if len(data) < header || string(data[:len(bloomMagic)]) != bloomMagic {
+		return errInvalidBloom
+	}
+	m := binary.BigEndian.Uint64(data[len(bloomMagic):])
+	k := binary.BigEndian.Uint32(data[len(bloomMagic)+8:])
+	words := (m + 63) / 64
+	if m == 0 || k == 0 || uint64(len(data)-header) != words*8 {
+		return errInvalidBloom
+	}
+
+	f.m, f.k = m, k
+	f.bits = make([]uint64, words)
+	for i := range f.bits {
+		f.bits[i] = binary.BigEndian.Uint64(data[header+8*i:])
+	}
+	return nil
+}
+
+//go:embed breached.bloom
+var breachedData []byte
+
+var (
+	breachedOnce   sync.Once
+	breachedFilter *BloomFilter
+)
+
+// Breached reports whether password, or its lowercase form, is in the
+// bundled breached-password filter. False positives are possible (rarely),
+// false negatives are not.
+func Breached(password string) bool {
+	breachedOnce.Do(func() {
+		breachedFilter = &BloomFilter{}
+		if err := breachedFilter.UnmarshalBinary(breachedData); err != nil {
+			panic(err)
+		}
+	})
+	return breachedFilter.Contains(password) || breachedFilter.Contains(strings.ToLower(password))
+}
@@ -0,0 +1,77 @@
+//go:build ignore
+
+// genbloom builds breached.bloom from a list of breached passwords.
+//
+//	go run genbloom.go -in data/common-passwords.txt -out breached.bloom
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"omhs-backend/internal/password"
+)
+
+var suffixes = []string{"", "1", "12", "123", "1234", "12345", "!", "!!", "1!", "123!", "01", "007", "69", "99", "2020", "2021", "2022", "2023", "2024", "2025", "2026"}
+
+func main() {
+	in := flag.String("in", "data/common-passwords.txt", "password list, one per line")
+	out := flag.String("out", "breached.bloom", "output filter")
+	fpRate := flag.Float64("p", 0.0001, "false positive rate")
+	variants := flag.Bool("variants", true, "add capitalised and suffixed variants")
+	flag.Parse()
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "# ") || word == "#" {
+			continue
+		}
+		if !*variants {
+			seen[word] = true
+			continue
+		}
+		for _, base := range []string{word, capitalise(word)} {
+			for _, suffix := range suffixes {
+				seen[base+suffix] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	n := float64(len(seen))
+	m := uint64(math.Ceil(-n * math.Log(*fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / n * math.Ln2))
+
+	filter := password.NewBloomFilter(m, k)
+	for word := range seen {
+		filter.Add(word)
+	}
+
+	data, _ := filter.MarshalBinary()
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d passwords, %d bits, %d hashes, %d bytes\n", len(seen), m, k, len(data))
+}
+
+func capitalise(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
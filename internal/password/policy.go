@@ -0,0 +1,168 @@
+package password
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"omhs-backend/internal/utils"
+)
+
+// Field error codes. Clients switch on these; Message is only a fallback.
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeTooFewClasses = "too_few_classes"
+	CodeContainsUser  = "contains_user_info"
+	CodeBreached      = "breached"
+	CodeReused        = "reused"
+)
+
+// FieldError describes one reason a request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError carries every rule a password broke so the client can show
+// them all at once.
+type PolicyError struct {
+	Fields []FieldError
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "password rejected: " + strings.Join(msgs, "; ")
+}
+
+// Policy is the set of rules new passwords must satisfy. MinClasses counts
+// how many of lowercase, uppercase, digits and symbols must appear. History
+// is how many previous hashes are kept and refused on change.
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	MinClasses    int
+	History       int
+	CheckBreached bool
+}
+
+func DefaultPolicy() Policy {
+	return Policy{MinLength: 10, MaxLength: 128, MinClasses: 3, History: 5, CheckBreached: true}
+}
+
+// PolicyFromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_MIN_CLASSES, PASSWORD_HISTORY and PASSWORD_CHECK_BREACHED, keeping
+// the defaults for anything unset or invalid.
+func PolicyFromEnv() Policy {
+	p := DefaultPolicy()
+	if v, err := strconv.Atoi(utils.GetEnv("PASSWORD_MIN_LENGTH", "")); err == nil && v >= 1 {
+		p.MinLength = v
+	}
+	if v, err := strconv.Atoi(utils.GetEnv("PASSWORD_MAX_LENGTH", "")); err == nil && v >= p.MinLength {
+		p.MaxLength = v
+	}
+	if v, err := strconv.Atoi(utils.GetEnv("PASSWORD_MIN_CLASSES", "")); err == nil && v >= 0 && v <= 4 {
+		p.MinClasses = v
+	}
+	if v, err := strconv.Atoi(utils.GetEnv("PASSWORD_HISTORY", "")); err == nil && v >= 0 {
+		p.History = v
+	}
+	if v, err := strconv.ParseBool(utils.GetEnv("PASSWORD_CHECK_BREACHED", "")); err == nil {
+		p.CheckBreached = v
+	}
+	return p
+}
+
+// Validate checks password against the policy. field names the request field
+// in the returned errors; username and email are refused as substrings.
+// Reuse is not checked here since it needs the stored hashes, see Reused.
+func (p Policy) Validate(field, password, username, email string) *PolicyError {
+	var errs []FieldError
+	add := func(code, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(CodeTooShort, "must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(CodeTooLong, "must be at most %d characters", p.MaxLength)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		add(CodeTooFewClasses, "must mix at least %d of lowercase, uppercase, digits and symbols", p.MinClasses)
+	}
+	if containsUserInfo(password, username, email) {
+		add(CodeContainsUser, "must not contain your username or email")
+	}
+	if p.CheckBreached && Breached(password) {
+		add(CodeBreached, "appears in a list of breached passwords")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &PolicyError{Fields: errs}
+}
+
+// Reused reports whether password matches any of the given hashes.
+func Reused(password string, hashes ...string) bool {
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		if ok, _ := utils.VerifyPassword(hash, password); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReusedError is the PolicyError returned for a password found in history.
+func ReusedError(field string, history int) *PolicyError {
+	return &PolicyError{Fields: []FieldError{{
+		Field:   field,
+		Code:    CodeReused,
+		Message: fmt.Sprintf("must differ from your last %d passwords", history+1),
+	}}}
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsUserInfo matches case-insensitively. Very short values are ignored,
+// otherwise a username like "jo" would rule out half the dictionary.
+func containsUserInfo(password, username, email string) bool {
+	pw := strings.ToLower(password)
+	candidates := []string{username, email}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		candidates = append(candidates, email[:at])
+	}
+	for _, c := range candidates {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if len(c) >= 3 && strings.Contains(pw, c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package requests
+
+import (
+	"sort"
+	"strings"
+)
+
+// Allowlist holds the "database.collection" pairs the generic API may touch.
+// Anything not listed is refused, including for admins.
+type Allowlist map[string]bool
+
+// ParseAllowlist reads a comma-separated list such as "data.Kanbans,testdb.notes".
+func ParseAllowlist(spec string) Allowlist {
+	allowed := Allowlist{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		allowed[entry] = true
+	}
+	return allowed
+}
+
+func (a Allowlist) Allows(database, collection string) bool {
+	return a[database+"."+collection]
+}
+
+// Entries lists the allowed "database.collection" pairs in sorted order.
+func (a Allowlist) Entries() []string {
+	entries := make([]string, 0, len(a))
+	for entry := range a {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
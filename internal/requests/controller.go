@@ -1,9 +1,14 @@
 package requests
 
 import (
+	"errors"
 	"net/http"
 
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
+
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type RequestController struct {
@@ -14,7 +19,31 @@ func NewRequestController(s *RequestService) *RequestController {
 	return &RequestController{service: s}
 }
 
+func getCaller(c *gin.Context) (Caller, bool) {
+	userId, ok := middleware.CurrentUserID(c)
+	if !ok {
+		return Caller{}, false
+	}
+	return Caller{UserID: userId, IsAdmin: middleware.HasRole(c, utils.RoleAdmin)}, true
+}
+
+// writeError maps service errors to a status, falling back to fallback.
+func writeError(c *gin.Context, err error, fallback int) {
+	switch {
+	case errors.Is(err, ErrCollectionNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+	default:
+		c.JSON(fallback, gin.H{"error": err.Error()})
+	}
+}
+
 func (ctr *RequestController) Create(c *gin.Context) {
+	caller, ok := getCaller(c)
+	if !ok {
+		return
+	}
 	db := c.Param("database")
 	col := c.Param("collection")
 
@@ -24,28 +53,36 @@ func (ctr *RequestController) Create(c *gin.Context) {
 		return
 	}
 
-	doc, err := ctr.service.Create(db, col, body)
+	doc, err := ctr.service.Create(caller, db, col, body)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err, http.StatusBadRequest)
 		return
 	}
 	c.JSON(http.StatusCreated, doc)
 }
 
 func (ctr *RequestController) Get(c *gin.Context) {
+	caller, ok := getCaller(c)
+	if !ok {
+		return
+	}
 	db := c.Param("database")
 	col := c.Param("collection")
 	id := c.Param("id")
 
-	doc, err := ctr.service.Get(db, col, id)
+	doc, err := ctr.service.Get(caller, db, col, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, err, http.StatusNotFound)
 		return
 	}
 	c.JSON(http.StatusOK, doc)
 }
 
 func (ctr *RequestController) Update(c *gin.Context) {
+	caller, ok := getCaller(c)
+	if !ok {
+		return
+	}
 	db := c.Param("database")
 	col := c.Param("collection")
 	id := c.Param("id")
@@ -56,33 +93,41 @@ func (ctr *RequestController) Update(c *gin.Context) {
 		return
 	}
 
-	doc, err := ctr.service.Update(db, col, id, body)
+	doc, err := ctr.service.Update(caller, db, col, id, body)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err, http.StatusBadRequest)
 		return
 	}
 	c.JSON(http.StatusOK, doc)
 }
 
 func (ctr *RequestController) Delete(c *gin.Context) {
+	caller, ok := getCaller(c)
+	if !ok {
+		return
+	}
 	db := c.Param("database")
 	col := c.Param("collection")
 	id := c.Param("id")
 
-	if err := ctr.service.Delete(db, col, id); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := ctr.service.Delete(caller, db, col, id); err != nil {
+		writeError(c, err, http.StatusBadRequest)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
 func (ctr *RequestController) GetAll(c *gin.Context) {
+	caller, ok := getCaller(c)
+	if !ok {
+		return
+	}
 	db := c.Param("database")
 	col := c.Param("collection")
 
-	docs, err := ctr.service.GetAll(db, col)
+	docs, err := ctr.service.GetAll(caller, db, col)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err, http.StatusBadRequest)
 		return
 	}
 	c.JSON(http.StatusOK, docs)
@@ -4,6 +4,22 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 
 // Document represents a generic MongoDB document structure.
 type Document struct {
-	ID   primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
-	Data map[string]interface{} `json:"data" bson:"data"`
+	ID    primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
+	Owner primitive.ObjectID     `json:"owner,omitempty" bson:"owner,omitempty"`
+	Data  map[string]interface{} `json:"data" bson:"data"`
+}
+
+// Caller identifies who is making a request. Documents are scoped to
+// their owner unless the caller is an admin.
+type Caller struct {
+	UserID  primitive.ObjectID
+	IsAdmin bool
+}
+
+// scope returns the owner filter to apply for this caller, or nil for none.
+func (c Caller) scope() *primitive.ObjectID {
+	if c.IsAdmin {
+		return nil
+	}
+	return &c.UserID
 }
@@ -9,12 +9,15 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// RequestRepository methods taking an owner only match documents with that
+// owner field; a nil owner leaves the query unscoped.
 type RequestRepository interface {
 	Create(database, collection string, doc Document) error
-	Get(database, collection string, id primitive.ObjectID) (*Document, error)
-	Update(database, collection string, id primitive.ObjectID, data map[string]interface{}) error
-	Delete(database, collection string, id primitive.ObjectID) error
-	GetAll(database, collection string) ([]Document, error)
+	Get(database, collection string, id primitive.ObjectID, owner *primitive.ObjectID) (*Document, error)
+	Update(database, collection string, id primitive.ObjectID, data map[string]interface{}, owner *primitive.ObjectID) error
+	Delete(database, collection string, id primitive.ObjectID, owner *primitive.ObjectID) error
+	GetAll(database, collection string, owner *primitive.ObjectID) ([]Document, error)
+	DeleteAll(database, collection string, owner primitive.ObjectID) (int64, error)
 }
 
 type MongoRequestRepository struct {
@@ -29,19 +32,28 @@ func (r *MongoRequestRepository) col(database, collection string) *mongo.Collect
 	return r.client.Database(database).Collection(collection)
 }
 
+func scoped(filter bson.M, owner *primitive.ObjectID) bson.M {
+	if owner != nil {
+		filter["owner"] = *owner
+	}
+	return filter
+}
+
 func (r *MongoRequestRepository) Create(database, collection string, doc Document) error {
 	_, err := r.col(database, collection).InsertOne(context.TODO(), doc)
 	logrus.Infof("Document before insert: %+v", doc)
 	return err
 }
 
-func (r *MongoRequestRepository) Get(database, collection string, id primitive.ObjectID) (*Document, error) {
+func (r *MongoRequestRepository) Get(database, collection string, id primitive.ObjectID, owner *primitive.ObjectID) (*Document, error) {
 	var raw bson.M
-	err := r.col(database, collection).FindOne(context.TODO(), bson.M{"_id": id}).Decode(&raw)
+	err := r.col(database, collection).FindOne(context.TODO(), scoped(bson.M{"_id": id}, owner)).Decode(&raw)
 	if err != nil {
 		return nil, err
 	}
 
+	docOwner, _ := raw["owner"].(primitive.ObjectID)
+
 	var data map[string]interface{}
 
 	switch v := raw["data"].(type) {
@@ -50,32 +62,40 @@ func (r *MongoRequestRepository) Get(database, collection string, id primitive.O
 	case primitive.M:
 		data = map[string]interface{}(v) // convert cleanly
 	default:
-		// fallback: everything except _id becomes data
+		// fallback: everything except _id and owner becomes data
 		delete(raw, "_id")
+		delete(raw, "owner")
 		data = raw
 	}
 
 	doc := &Document{
-		ID:   id,
-		Data: data,
+		ID:    id,
+		Owner: docOwner,
+		Data:  data,
 	}
 
 	logrus.Infof("Decoded Document: %+v", doc)
 	return doc, nil
 }
 
-func (r *MongoRequestRepository) Update(database, collection string, id primitive.ObjectID, data map[string]interface{}) error {
-	_, err := r.col(database, collection).UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": data})
+func (r *MongoRequestRepository) Update(database, collection string, id primitive.ObjectID, data map[string]interface{}, owner *primitive.ObjectID) error {
+	_, err := r.col(database, collection).UpdateOne(context.TODO(), scoped(bson.M{"_id": id}, owner), bson.M{"$set": data})
 	return err
 }
 
-func (r *MongoRequestRepository) Delete(database, collection string, id primitive.ObjectID) error {
-	_, err := r.col(database, collection).DeleteOne(context.TODO(), bson.M{"_id": id})
-	return err
+func (r *MongoRequestRepository) Delete(database, collection string, id primitive.ObjectID, owner *primitive.ObjectID) error {
+	res, err := r.col(database, collection).DeleteOne(context.TODO(), scoped(bson.M{"_id": id}, owner))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
 }
 
-func (r *MongoRequestRepository) GetAll(database, collection string) ([]Document, error) {
-	cursor, err := r.col(database, collection).Find(context.TODO(), bson.M{})
+func (r *MongoRequestRepository) GetAll(database, collection string, owner *primitive.ObjectID) ([]Document, error) {
+	cursor, err := r.col(database, collection).Find(context.TODO(), scoped(bson.M{}, owner))
 	if err != nil {
 		return nil, err
 	}
@@ -91,3 +111,13 @@ func (r *MongoRequestRepository) GetAll(database, collection string) ([]Document
 	}
 	return docs, cursor.Err()
 }
+
+// DeleteAll removes every document of owner. Unlike the other methods the
+// owner is required, so it can never empty a whole collection.
+func (r *MongoRequestRepository) DeleteAll(database, collection string, owner primitive.ObjectID) (int64, error) {
+	res, err := r.col(database, collection).DeleteMany(context.TODO(), bson.M{"owner": owner})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -1,11 +1,23 @@
 package requests
 
-import "github.com/gin-gonic/gin"
+import (
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/utils"
 
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes mounts the generic document API. It expects r to already
+// run JWTMiddleware; the service limits users to their own documents.
 func RegisterRoutes(r *gin.RouterGroup, controller *RequestController) {
-	r.POST("/:database/:collection", controller.Create)
-	r.GET("/:database/:collection/:id", controller.Get)
-	r.PUT("/:database/:collection/:id", controller.Update)
-	r.DELETE("/:database/:collection/:id", controller.Delete)
-	r.GET("/:database/:collection", controller.GetAll)
+	group := r.Group("",
+		middleware.RequireScopes(utils.ScopeRequestsRead, utils.ScopeRequestsWrite),
+		middleware.RequireRole(utils.RoleUser, utils.RoleAdmin))
+	{
+		group.POST("/:database/:collection", controller.Create)
+		group.GET("/:database/:collection/:id", controller.Get)
+		group.PUT("/:database/:collection/:id", controller.Update)
+		group.DELETE("/:database/:collection/:id", controller.Delete)
+		group.GET("/:database/:collection", controller.GetAll)
+	}
 }
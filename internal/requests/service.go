@@ -6,17 +6,34 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var ErrCollectionNotAllowed = errors.New("collection is not accessible")
+
+// reservedFields cannot be set through Update, so callers cannot
+// reassign a document's id or hand it to another owner.
+var reservedFields = []string{"_id", "owner"}
+
 type RequestService struct {
-	repo RequestRepository
+	repo      RequestRepository
+	allowlist Allowlist
 }
 
-func NewRequestService(repo RequestRepository) *RequestService {
-	return &RequestService{repo: repo}
+func NewRequestService(repo RequestRepository, allowlist Allowlist) *RequestService {
+	return &RequestService{repo: repo, allowlist: allowlist}
 }
 
-func (s *RequestService) Create(database, collection string, data map[string]interface{}) (*Document, error) {
+func (s *RequestService) checkAccess(database, collection string) error {
 	if database == "" || collection == "" {
-		return nil, errors.New("database and collection are required")
+		return errors.New("database and collection are required")
+	}
+	if !s.allowlist.Allows(database, collection) {
+		return ErrCollectionNotAllowed
+	}
+	return nil
+}
+
+func (s *RequestService) Create(caller Caller, database, collection string, data map[string]interface{}) (*Document, error) {
+	if err := s.checkAccess(database, collection); err != nil {
+		return nil, err
 	}
 
 	if inner, ok := data["data"].(map[string]interface{}); ok {
@@ -24,8 +41,9 @@ func (s *RequestService) Create(database, collection string, data map[string]int
 	}
 
 	doc := Document{
-		ID:   primitive.NewObjectID(),
-		Data: data,
+		ID:    primitive.NewObjectID(),
+		Owner: caller.UserID,
+		Data:  data,
 	}
 
 	if err := s.repo.Create(database, collection, doc); err != nil {
@@ -35,33 +53,48 @@ func (s *RequestService) Create(database, collection string, data map[string]int
 	return &doc, nil
 }
 
-func (s *RequestService) Get(database, collection, id string) (*Document, error) {
+func (s *RequestService) Get(caller Caller, database, collection, id string) (*Document, error) {
+	if err := s.checkAccess(database, collection); err != nil {
+		return nil, err
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, errors.New("invalid id format")
 	}
-	return s.repo.Get(database, collection, objID)
+	return s.repo.Get(database, collection, objID, caller.scope())
 }
 
-func (s *RequestService) Update(database, collection, id string, data map[string]interface{}) (*Document, error) {
+func (s *RequestService) Update(caller Caller, database, collection, id string, data map[string]interface{}) (*Document, error) {
+	if err := s.checkAccess(database, collection); err != nil {
+		return nil, err
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, errors.New("invalid id format")
 	}
-	if err := s.repo.Update(database, collection, objID, data); err != nil {
+	for _, field := range reservedFields {
+		delete(data, field)
+	}
+	if err := s.repo.Update(database, collection, objID, data, caller.scope()); err != nil {
 		return nil, err
 	}
-	return s.repo.Get(database, collection, objID)
+	return s.repo.Get(database, collection, objID, caller.scope())
 }
 
-func (s *RequestService) Delete(database, collection, id string) error {
+func (s *RequestService) Delete(caller Caller, database, collection, id string) error {
+	if err := s.checkAccess(database, collection); err != nil {
+		return err
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New("invalid id format")
 	}
-	return s.repo.Delete(database, collection, objID)
+	return s.repo.Delete(database, collection, objID, caller.scope())
 }
 
-func (s *RequestService) GetAll(database, collection string) ([]Document, error) {
-	return s.repo.GetAll(database, collection)
+func (s *RequestService) GetAll(caller Caller, database, collection string) ([]Document, error) {
+	if err := s.checkAccess(database, collection); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAll(database, collection, caller.scope())
 }
@@ -0,0 +1,52 @@
+package signing
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"omhs-backend/internal/utils"
+)
+
+// Config controls the keyring. A key signs for RotationInterval; its
+// successor is published PublishLead earlier so verifiers caching the JWKS
+// already know it, and a retired key stays published for Overlap so tokens
+// it signed remain valid until they expire.
+type Config struct {
+	Algorithm        string
+	Issuer           string
+	Audience         string
+	RotationInterval time.Duration
+	PublishLead      time.Duration
+	Overlap          time.Duration
+	RefreshInterval  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Algorithm:        AlgRS256,
+		Issuer:           utils.PublicURL(),
+		Audience:         "omhs-api",
+		RotationInterval: 30 * 24 * time.Hour,
+		PublishLead:      24 * time.Hour,
+		Overlap:          7 * 24 * time.Hour,
+		RefreshInterval:  time.Minute,
+	}
+}
+
+// ConfigFromEnv reads JWT_ALGORITHM (RS256 or EdDSA), JWT_ISSUER,
+// JWT_AUDIENCE and JWT_KEY_ROTATION_DAYS. Unlike most settings an unknown
+// algorithm is an error rather than silently replaced.
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	cfg.Algorithm = utils.GetEnv("JWT_ALGORITHM", cfg.Algorithm)
+	if cfg.Algorithm != AlgRS256 && cfg.Algorithm != AlgEdDSA {
+		return cfg, fmt.Errorf("JWT_ALGORITHM must be %s or %s, got %q", AlgRS256, AlgEdDSA, cfg.Algorithm)
+	}
+	cfg.Issuer = utils.GetEnv("JWT_ISSUER", cfg.Issuer)
+	cfg.Audience = utils.GetEnv("JWT_AUDIENCE", cfg.Audience)
+	if v, err := strconv.Atoi(utils.GetEnv("JWT_KEY_ROTATION_DAYS", "")); err == nil && v >= 1 {
+		cfg.RotationInterval = time.Duration(v) * 24 * time.Hour
+	}
+	return cfg, nil
+}
@@ -0,0 +1,22 @@
+package signing
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSController struct {
+	keyring *Keyring
+}
+
+func NewJWKSController(keyring *Keyring) *JWKSController {
+	return &JWKSController{keyring: keyring}
+}
+
+// JWKS serves the public keys. Verifiers may cache them for a few minutes;
+// new keys are published well before they sign anything.
+func (ctr *JWKSController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctr.keyring.JWKS())
+}
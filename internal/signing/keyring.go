@@ -0,0 +1,313 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadCooldown limits how often an unknown kid makes the keyring re-read
+// the repository, so garbage kids cannot hammer the database.
+const reloadCooldown = 10 * time.Second
+
+var (
+	ErrNoActiveKey       = errors.New("no active signing key")
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match its key")
+)
+
+type loadedKey struct {
+	Key
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// Keyring holds the signing keys shared through a KeyRepository. It signs
+// with the current key and verifies with every published one. It implements
+// utils.TokenKeys.
+type Keyring struct {
+	repo KeyRepository
+	cfg  Config
+
+	mu         sync.RWMutex
+	keys       map[string]*loadedKey
+	ordered    []*loadedKey // by ActivatesAt, oldest first
+	lastReload time.Time
+}
+
+func NewKeyring(repo KeyRepository, cfg Config) *Keyring {
+	return &Keyring{repo: repo, cfg: cfg, keys: map[string]*loadedKey{}}
+}
+
+// Rotate loads the keyring, creates a signing key if there is none and
+// publishes the next one once the current key is within PublishLead of
+// retiring. Safe to call from every instance; a race only yields an extra
+// valid key.
+func (k *Keyring) Rotate(now time.Time) error {
+	if err := k.reload(now); err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	active := k.activeAt(now)
+	var successor *loadedKey
+	if active != nil {
+		for _, key := range k.ordered {
+			if !key.ActivatesAt.Before(active.RetiresAt) {
+				successor = key
+			}
+		}
+	}
+	k.mu.RUnlock()
+
+	switch {
+	case active == nil:
+		logrus.Infof("Creating %s signing key", k.cfg.Algorithm)
+		if err := k.create(now, now); err != nil {
+			return err
+		}
+	case successor == nil && active.RetiresAt.Sub(now) <= k.cfg.PublishLead:
+		logrus.Infof("Publishing next %s signing key, active from %s", k.cfg.Algorithm, active.RetiresAt.Format(time.RFC3339))
+		if err := k.create(now, active.RetiresAt); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return k.reload(now)
+}
+
+// Run keeps the keyring rotated and in sync with other instances until ctx
+// is cancelled.
+func (k *Keyring) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Rotate(time.Now()); err != nil {
+				logrus.Errorf("Signing key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+func (k *Keyring) create(now, activatesAt time.Time) error {
+	key, err := generateKey(k.cfg.Algorithm)
+	if err != nil {
+		return err
+	}
+	key.CreatedAt = now
+	key.ActivatesAt = activatesAt
+	key.RetiresAt = activatesAt.Add(k.cfg.RotationInterval)
+	key.ExpiresAt = key.RetiresAt.Add(k.cfg.Overlap)
+	return k.repo.Insert(key)
+}
+
+func (k *Keyring) reload(now time.Time) error {
+	stored, err := k.repo.ListValid(now)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*loadedKey, len(stored))
+	ordered := make([]*loadedKey, 0, len(stored))
+	for _, key := range stored {
+		loaded, err := loadKey(key)
+		if err != nil {
+			logrus.Errorf("Skipping signing key %s: %v", key.ID, err)
+			continue
+		}
+		keys[key.ID] = loaded
+		ordered = append(ordered, loaded)
+	}
+
+	k.mu.Lock()
+	k.keys, k.ordered, k.lastReload = keys, ordered, now
+	k.mu.Unlock()
+	return nil
+}
+
+// activeAt returns the newest key that has activated and not yet retired.
+// Callers hold mu.
+func (k *Keyring) activeAt(now time.Time) *loadedKey {
+	var active *loadedKey
+	for _, key := range k.ordered {
+		if !key.ActivatesAt.After(now) && key.RetiresAt.After(now) {
+			active = key
+		}
+	}
+	return active
+}
+
+// --- utils.TokenKeys ---
+
+func (k *Keyring) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	active := k.activeAt(time.Now())
+	if active == nil {
+		return "", nil, nil, ErrNoActiveKey
+	}
+	return active.ID, signingMethod(active.Algorithm), active.private, nil
+}
+
+// VerificationKey pins the algorithm to the one the key was made for, so a
+// token cannot pick how its own signature is checked.
+func (k *Keyring) VerificationKey(kid, alg string) (interface{}, error) {
+	key := k.lookup(kid)
+	if key == nil {
+		k.mu.RLock()
+		stale := time.Since(k.lastReload) > reloadCooldown
+		k.mu.RUnlock()
+		if stale {
+			if err := k.reload(time.Now()); err != nil {
+				return nil, err
+			}
+			key = k.lookup(kid)
+		}
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if key.Algorithm != alg {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.public, nil
+}
+
+func (k *Keyring) lookup(kid string) *loadedKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[kid]
+}
+
+func (k *Keyring) Issuer() string {
+	return k.cfg.Issuer
+}
+
+func (k *Keyring) Audience() string {
+	return k.cfg.Audience
+}
+
+// JWKS lists every published public key, including the upcoming one.
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(k.ordered))}
+	for _, key := range k.ordered {
+		jwk, err := publicJWK(key.Algorithm, key.public)
+		if err != nil {
+			continue
+		}
+		jwk.Kid = key.ID
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// --- key material ---
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// generateKey creates a key pair whose id is its RFC 7638 thumbprint.
+func generateKey(alg string) (*Key, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := publicJWK(alg, private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{ID: thumbprint(jwk), Algorithm: alg, PrivateKey: privateDER, PublicKey: publicDER}, nil
+}
+
+func loadKey(key Key) (*loadedKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm != AlgRS256 {
+			return nil, ErrAlgorithmMismatch
+		}
+	case ed25519.PrivateKey:
+		if key.Algorithm != AlgEdDSA {
+			return nil, ErrAlgorithmMismatch
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	return &loadedKey{Key: key, private: private, public: private.Public()}, nil
+}
+
+func publicJWK(alg string, public crypto.PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Use: "sig", Alg: alg, N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Use: "sig", Alg: alg, Crv: "Ed25519", X: b64(pub)}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported public key type %T", public)
+}
+
+// thumbprint hashes the required members in lexicographic order (RFC 7638).
+func thumbprint(jwk JWK) string {
+	var canonical string
+	if jwk.Kty == "RSA" {
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,40 @@
+package signing
+
+import "time"
+
+// Supported JWT algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is one signing key pair. It signs tokens from ActivatesAt until
+// RetiresAt and stays published for verification until ExpiresAt, after
+// which Mongo removes it.
+type Key struct {
+	ID          string    `bson:"_id"`
+	Algorithm   string    `bson:"algorithm"`
+	PrivateKey  []byte    `bson:"privateKey"` // PKCS #8, DER
+	PublicKey   []byte    `bson:"publicKey"`  // PKIX, DER
+	CreatedAt   time.Time `bson:"createdAt"`
+	ActivatesAt time.Time `bson:"activatesAt"`
+	RetiresAt   time.Time `bson:"retiresAt"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+// JWK is a public key in RFC 7517 form. RSA keys use N and E, Ed25519 keys
+// Crv and X.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
@@ -0,0 +1,57 @@
+package signing
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// KeyRepository stores the keyring so every instance signs with the same
+// keys and a restart does not invalidate issued tokens.
+type KeyRepository interface {
+	Insert(key *Key) error
+	ListValid(now time.Time) ([]Key, error)
+}
+
+type MongoKeyRepository struct {
+	client *mongo.Client
+}
+
+func NewMongoKeyRepository(client *mongo.Client) *MongoKeyRepository {
+	return &MongoKeyRepository{client: client}
+}
+
+func (r *MongoKeyRepository) collection() *mongo.Collection {
+	return r.client.Database("users").Collection("signingKeys")
+}
+
+func (r *MongoKeyRepository) EnsureIndexes() error {
+	_, err := r.collection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (r *MongoKeyRepository) Insert(key *Key) error {
+	_, err := r.collection().InsertOne(context.TODO(), key)
+	return err
+}
+
+// ListValid returns the keys not yet expired, oldest first.
+func (r *MongoKeyRepository) ListValid(now time.Time) ([]Key, error) {
+	cursor, err := r.collection().Find(context.TODO(), bson.M{"expiresAt": bson.M{"$gt": now}},
+		options.Find().SetSort(bson.D{{Key: "activatesAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	keys := []Key{}
+	if err := cursor.All(context.TODO(), &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,9 @@
+package signing
+
+import "github.com/gin-gonic/gin"
+
+// RegisterRoutes mounts the JWKS at its well-known path on the root router,
+// outside /api, where OIDC-style verifiers look for it.
+func RegisterRoutes(r gin.IRouter, controller *JWKSController) {
+	r.GET("/.well-known/jwks.json", controller.JWKS)
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "os"
+
+// GetEnv returns the environment variable key, or def when it is unset or empty.
+func GetEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// PublicURL is the externally reachable base URL used in links we email out.
+func PublicURL() string {
+	return GetEnv("PUBLIC_URL", "http://localhost:8080")
+}
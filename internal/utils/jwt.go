@@ -1,21 +1,207 @@
 package utils
 
 import (
-	"os"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var JwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// AccessTokenTTL is kept short because access tokens are stateless;
+// long-lived sessions are carried by rotating refresh tokens instead.
+const AccessTokenTTL = 15 * time.Minute
 
-func GenerateJWT(userId string, username string) (string, error) {
-	claims := jwt.MapClaims{
-		"userId":   userId,
-		"username": username,
-		"exp":      time.Now().Add(7 * 24 * time.Hour).Unix(),
+// clockLeeway absorbs small clock differences between instances.
+const clockLeeway = 30 * time.Second
+
+var ErrNoTokenKeys = errors.New("token signing keys not configured")
+
+// TokenKeys is the key source behind every JWT, implemented by
+// signing.Keyring. It lives behind an interface so utils does not depend on
+// the storage of the keys.
+type TokenKeys interface {
+	// SigningKey returns the kid, method and private key to sign with now.
+	SigningKey() (kid string, method jwt.SigningMethod, key interface{}, err error)
+	// VerificationKey returns the public key for kid, refusing it when alg
+	// is not the algorithm the key was created for.
+	VerificationKey(kid, alg string) (interface{}, error)
+	Issuer() string
+	Audience() string
+}
+
+var (
+	tokenKeysMu sync.RWMutex
+	tokenKeys   TokenKeys
+)
+
+// SetTokenKeys installs the key source. Until it is called every token
+// operation fails with ErrNoTokenKeys rather than signing with an empty key.
+func SetTokenKeys(keys TokenKeys) {
+	tokenKeysMu.Lock()
+	defer tokenKeysMu.Unlock()
+	tokenKeys = keys
+}
+
+func currentTokenKeys() (TokenKeys, error) {
+	tokenKeysMu.RLock()
+	defer tokenKeysMu.RUnlock()
+	if tokenKeys == nil {
+		return nil, ErrNoTokenKeys
 	}
+	return tokenKeys, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtSecret)
+// Claims are the custom claims carried by every access token.
+// SessionID ties the token to the login it was issued for, so revoking the
+// session also invalidates its outstanding access tokens.
+type Claims struct {
+	UserID    string   `json:"userId"`
+	Username  string   `json:"username"`
+	Roles     []string `json:"roles"`
+	SessionID string   `json:"sid,omitempty"`
+	Actor     *Actor   `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Actor is the "act" claim (RFC 8693) of a token an admin uses to act as
+// another user. The token's session is then the actor's, not the user's.
+type Actor struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username,omitempty"`
+}
+
+func GenerateJWT(userId string, username string, roles []string, sessionId string) (string, error) {
+	keys, err := currentTokenKeys()
+	if err != nil {
+		return "", err
+	}
+	claims := Claims{
+		UserID:           userId,
+		Username:         username,
+		Roles:            roles,
+		SessionID:        sessionId,
+		RegisteredClaims: registeredClaims(keys, keys.Audience(), "", AccessTokenTTL),
+	}
+	return signToken(keys, claims)
+}
+
+// GenerateImpersonationJWT signs an access token for userId on behalf of
+// actor, bound to the actor's session and valid for ttl.
+func GenerateImpersonationJWT(userId, username string, roles []string, actor Actor, sessionId string, ttl time.Duration) (string, error) {
+	keys, err := currentTokenKeys()
+	if err != nil {
+		return "", err
+	}
+	claims := Claims{
+		UserID:           userId,
+		Username:         username,
+		Roles:            roles,
+		SessionID:        sessionId,
+		Actor:            &actor,
+		RegisteredClaims: registeredClaims(keys, keys.Audience(), "", ttl),
+	}
+	return signToken(keys, claims)
+}
+
+func ParseJWT(tokenString string) (*Claims, error) {
+	keys, err := currentTokenKeys()
+	if err != nil {
+		return nil, err
+	}
+	claims := &Claims{}
+	if err := parseToken(keys, tokenString, keys.Audience(), claims); err != nil {
+		return nil, err
+	}
+	if claims.UserID == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// ActionClaims back single-purpose tokens such as emailed links. They are
+// never accepted as access tokens: they carry no userId claim and their
+// audience is the purpose rather than the API.
+type ActionClaims struct {
+	Purpose string `json:"purpose"`
+	Value   string `json:"val,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateActionToken signs a token for purpose about subject. Value is
+// optional extra state the token is bound to, e.g. the email being verified.
+func GenerateActionToken(purpose, subject, value string, ttl time.Duration) (string, error) {
+	keys, err := currentTokenKeys()
+	if err != nil {
+		return "", err
+	}
+	claims := ActionClaims{
+		Purpose:          purpose,
+		Value:            value,
+		RegisteredClaims: registeredClaims(keys, purpose, subject, ttl),
+	}
+	return signToken(keys, claims)
+}
+
+func ParseActionToken(purpose, tokenString string) (*ActionClaims, error) {
+	keys, err := currentTokenKeys()
+	if err != nil {
+		return nil, err
+	}
+	claims := &ActionClaims{}
+	if err := parseToken(keys, tokenString, purpose, claims); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose || claims.Subject == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func registeredClaims(keys TokenKeys, audience, subject string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    keys.Issuer(),
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+func signToken(keys TokenKeys, claims jwt.Claims) (string, error) {
+	kid, method, key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// parseToken only accepts the asymmetric algorithms the keyring issues, so
+// HS256 tokens signed with a public key and "none" are refused outright.
+func parseToken(keys TokenKeys, tokenString, audience string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		return keys.VerificationKey(kid, token.Method.Alg())
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(keys.Issuer()),
+		jwt.WithAudience(audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockLeeway),
+	)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
 }
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// Argon2Params tune argon2id. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP minimum recommendation
+// (19 MiB, 2 iterations, 1 lane).
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+// Argon2ParamsFromEnv reads ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and
+// ARGON2_PARALLELISM, keeping the defaults for anything unset or invalid.
+func Argon2ParamsFromEnv() Argon2Params {
+	p := DefaultArgon2Params()
+	if v, err := strconv.ParseUint(GetEnv("ARGON2_MEMORY_KIB", ""), 10, 32); err == nil && v >= 8 {
+		p.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(GetEnv("ARGON2_ITERATIONS", ""), 10, 32); err == nil && v >= 1 {
+		p.Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(GetEnv("ARGON2_PARALLELISM", ""), 10, 8); err == nil && v >= 1 {
+		p.Parallelism = uint8(v)
+	}
+	return p
+}
+
+// PasswordHasher hashes passwords with argon2id into PHC strings such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash> and verifies those as well as
+// legacy bcrypt hashes.
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+func NewPasswordHasher(params Argon2Params) *PasswordHasher {
+	return &PasswordHasher{params: params}
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against encoded. needsRehash is true when the
+// password matched but encoded is bcrypt or uses other argon2 parameters,
+// so the caller should store a fresh hash.
+func (h *PasswordHasher) Verify(encoded, password string) (ok bool, needsRehash bool) {
+	if strings.HasPrefix(encoded, "$2") {
+		ok = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+		return ok, ok
+	}
+
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, false
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false
+	}
+
+	current := h.params
+	stale := params.Memory != current.Memory || params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism || params.KeyLength != current.KeyLength
+	return true, stale
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// The default hasher is built on first use rather than at package init, so
+// the parameters are read after .env has been loaded.
+var (
+	defaultHasherOnce sync.Once
+	defaultHasher     *PasswordHasher
+	dummyHash         string
+)
+
+func passwordHasher() *PasswordHasher {
+	defaultHasherOnce.Do(func() {
+		defaultHasher = NewPasswordHasher(Argon2ParamsFromEnv())
+		dummyHash, _ = defaultHasher.Hash("dummy password")
+	})
+	return defaultHasher
+}
+
+func HashPassword(password string) (string, error) {
+	return passwordHasher().Hash(password)
+}
+
+func VerifyPassword(encoded, password string) (ok bool, needsRehash bool) {
+	return passwordHasher().Verify(encoded, password)
+}
+
+// VerifyDummyPassword costs as much as a real check. Use it when there is
+// no user to check against, so timing does not reveal which usernames exist.
+func VerifyDummyPassword(password string) {
+	passwordHasher().Verify(dummyHash, password)
+}
@@ -0,0 +1,13 @@
+package utils
+
+// Roles carried in access tokens. New roles only need a constant here and
+// an entry in User.Roles; RequireRole compares them as plain strings.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// IsKnownRole reports whether role is one of the constants above.
+func IsKnownRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "strings"
+
+// Scopes limit what a personal access token may do. A "<resource>:*" scope
+// grants every action on that resource. Logged-in sessions are not scoped.
+const (
+	ScopeKanbanRead    = "kanban:read"
+	ScopeKanbanWrite   = "kanban:write"
+	ScopeKanbanAll     = "kanban:*"
+	ScopeRequestsRead  = "requests:read"
+	ScopeRequestsWrite = "requests:write"
+	ScopeRequestsAll   = "requests:*"
+)
+
+var knownScopes = map[string]bool{
+	ScopeKanbanRead:    true,
+	ScopeKanbanWrite:   true,
+	ScopeKanbanAll:     true,
+	ScopeRequestsRead:  true,
+	ScopeRequestsWrite: true,
+	ScopeRequestsAll:   true,
+}
+
+func IsKnownScope(scope string) bool {
+	return knownScopes[scope]
+}
+
+// ScopeAllows reports whether the granted scopes cover needed.
+func ScopeAllows(granted []string, needed string) bool {
+	resource, _, _ := strings.Cut(needed, ":")
+	for _, scope := range granted {
+		if scope == needed || scope == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
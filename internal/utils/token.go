@@ -5,6 +5,10 @@ import (
 	"encoding/hex"
 )
 
+// PersonalAccessTokenPrefix marks personal access tokens, so they can be told
+// apart from JWTs at a glance and found by secret scanners.
+const PersonalAccessTokenPrefix = "omhs_pat_"
+
 // GenerateToken creates a random 32-byte hex token.
 // Used for auth tokens or API keys.
 func GenerateToken() (string, error) {
@@ -14,3 +18,13 @@ func GenerateToken() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// GeneratePersonalAccessToken creates a random token carrying
+// PersonalAccessTokenPrefix.
+func GeneratePersonalAccessToken() (string, error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
@@ -0,0 +1,51 @@
+package webauthn
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecoder is strict about what authenticators send: duplicate map keys,
+// indefinite-length items and deep nesting are rejected.
+var cborDecoder = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{
+		DupMapKey:       cbor.DupMapKeyEnforcedAPF,
+		IndefLength:     cbor.IndefLengthForbidden,
+		MaxNestedLevels: 16,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// attestationObject is the CBOR map a client returns from
+// navigator.credentials.create() (spec §6.5).
+type attestationObject struct {
+	Format   string                     `cbor:"fmt"`
+	AttStmt  map[string]cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte                     `cbor:"authData"`
+}
+
+// COSE_Key parameters (RFC 9052 §7, RFC 9053 §7). The negative labels mean
+// different things per key type, so a key is decoded once for its type and
+// again into the struct for that type.
+type coseKeyHeader struct {
+	Kty int64 `cbor:"1,keyasint"`
+	Alg int64 `cbor:"3,keyasint"`
+}
+
+type ec2Key struct {
+	Crv int64  `cbor:"-1,keyasint"`
+	X   []byte `cbor:"-2,keyasint"`
+	Y   []byte `cbor:"-3,keyasint"`
+}
+
+type okpKey struct {
+	Crv int64  `cbor:"-1,keyasint"`
+	X   []byte `cbor:"-2,keyasint"`
+}
+
+type rsaKey struct {
+	N []byte `cbor:"-1,keyasint"`
+	E []byte `cbor:"-2,keyasint"`
+}
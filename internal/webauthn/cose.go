@@ -0,0 +1,86 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers we accept (RFC 9053 / IANA registry).
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms is offered to clients in order of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// PublicKey is a credential public key decoded from its COSE_Key form.
+type PublicKey struct {
+	Algorithm int
+	key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key and checks it is one we can verify with.
+func ParsePublicKey(coseKey []byte) (*PublicKey, error) {
+	var h coseKeyHeader
+	if err := cborDecoder.Unmarshal(coseKey, &h); err != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	switch {
+	case h.Kty == 2 && h.Alg == AlgES256:
+		var k ec2Key
+		if err := cborDecoder.Unmarshal(coseKey, &k); err != nil || k.Crv != 1 || len(k.X) != 32 || len(k.Y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		// ecdh validates that the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, k.X...), k.Y...)); err != nil {
+			return nil, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(k.X), Y: new(big.Int).SetBytes(k.Y)}
+		return &PublicKey{Algorithm: AlgES256, key: pub}, nil
+
+	case h.Kty == 1 && h.Alg == AlgEdDSA:
+		var k okpKey
+		if err := cborDecoder.Unmarshal(coseKey, &k); err != nil || k.Crv != 6 || len(k.X) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return &PublicKey{Algorithm: AlgEdDSA, key: ed25519.PublicKey(k.X)}, nil
+
+	case h.Kty == 3 && h.Alg == AlgRS256:
+		var k rsaKey
+		if err := cborDecoder.Unmarshal(coseKey, &k); err != nil || len(k.N) < 256 || len(k.E) == 0 || len(k.E) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		exp := new(big.Int).SetBytes(k.E)
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(k.N), E: int(exp.Int64())}
+		return &PublicKey{Algorithm: AlgRS256, key: pub}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// Verify checks sig over data with the key's algorithm.
+func (p *PublicKey) Verify(data, sig []byte) bool {
+	switch p.Algorithm {
+	case AlgES256:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(p.key.(*ecdsa.PublicKey), digest[:], sig)
+	case AlgEdDSA:
+		return ed25519.Verify(p.key.(ed25519.PublicKey), data, sig)
+	case AlgRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(p.key.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
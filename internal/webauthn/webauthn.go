@@ -0,0 +1,273 @@
+// Package webauthn implements the server side of the WebAuthn registration
+// and authentication ceremonies (W3C Web Authentication Level 2) for the
+// "none" attestation format. Storage and sessions are left to callers.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"omhs-backend/internal/utils"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+
+	ChallengeSize = 32
+)
+
+var (
+	ErrClientData        = errors.New("client data does not match the ceremony")
+	ErrAuthenticatorData = errors.New("invalid authenticator data")
+	ErrAttestation       = errors.New("unsupported attestation")
+	ErrUserVerification  = errors.New("user verification required")
+	ErrSignature         = errors.New("invalid assertion signature")
+	ErrSignCount         = errors.New("signature counter did not increase, credential may be cloned")
+)
+
+// Config describes the relying party, i.e. this deployment.
+type Config struct {
+	RPID             string
+	RPName           string
+	Origins          []string
+	UserVerification string // "required" or "preferred"
+	TimeoutMillis    int
+}
+
+// ConfigFromEnv reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and the comma-separated
+// WEBAUTHN_ORIGINS, defaulting to the local frontends.
+func ConfigFromEnv() Config {
+	var origins []string
+	for _, o := range strings.Split(utils.GetEnv("WEBAUTHN_ORIGINS", "http://localhost:3000,http://localhost:4200"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return Config{
+		RPID:             utils.GetEnv("WEBAUTHN_RP_ID", "localhost"),
+		RPName:           utils.GetEnv("WEBAUTHN_RP_NAME", "OMHS"),
+		Origins:          origins,
+		UserVerification: "required",
+		TimeoutMillis:    120000,
+	}
+}
+
+// NewChallenge returns a fresh random ceremony challenge.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// AuthenticatorData is the parsed authData structure (spec §6.1).
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, only present during registration
+}
+
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrAuthenticatorData
+	}
+	ad := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.Flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrAuthenticatorData
+	}
+	ad.AAGUID = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+		return nil, ErrAuthenticatorData
+	}
+	ad.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	// The COSE key is followed by optional extensions, so measure it by decoding.
+	var key cbor.RawMessage
+	after, err := cborDecoder.UnmarshalFirst(rest, &key)
+	if err != nil {
+		return nil, ErrAuthenticatorData
+	}
+	ad.PublicKey = rest[:len(rest)-len(after)]
+	return ad, nil
+}
+
+func (c Config) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd CollectedClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrClientData
+	}
+	if cd.Type != ceremony || cd.CrossOrigin {
+		return ErrClientData
+	}
+
+	expected := base64.RawURLEncoding.EncodeToString(challenge)
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(cd.Challenge, "=")), []byte(expected)) != 1 {
+		return ErrClientData
+	}
+
+	for _, origin := range c.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return ErrClientData
+}
+
+func (c Config) verifyAuthenticatorData(ad *AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return ErrAuthenticatorData
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return ErrAuthenticatorData
+	}
+	if c.UserVerification == "required" && ad.Flags&flagUserVerified == 0 {
+		return ErrUserVerification
+	}
+	return nil
+}
+
+// CreationOptions builds the options for navigator.credentials.create().
+func (c Config) CreationOptions(challenge []byte, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            c.TimeoutMillis,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: c.UserVerification,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options for navigator.credentials.get(). An empty
+// allow list lets the authenticator offer any discoverable credential.
+func (c Config) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          c.TimeoutMillis,
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: c.UserVerification,
+	}
+}
+
+// VerifiedCredential is what callers store after a successful registration.
+type VerifiedCredential struct {
+	ID         []byte
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// VerifyRegistration runs the registration ceremony checks (spec §7.1).
+func (c Config) VerifyRegistration(challenge []byte, resp *AttestationResponse) (*VerifiedCredential, error) {
+	if resp.Type != "public-key" {
+		return nil, ErrClientData
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var attObj attestationObject
+	if err := cborDecoder.Unmarshal(resp.Response.AttestationObject, &attObj); err != nil {
+		return nil, ErrAttestation
+	}
+	// We ask for attestation "none", so any other statement is unexpected.
+	if attObj.Format != "none" || len(attObj.AttStmt) != 0 {
+		return nil, ErrAttestation
+	}
+
+	ad, err := ParseAuthenticatorData(attObj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.PublicKey == nil || !bytes.Equal(ad.CredentialID, resp.RawID) {
+		return nil, ErrAuthenticatorData
+	}
+	if _, err := ParsePublicKey(ad.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &VerifiedCredential{
+		ID:         ad.CredentialID,
+		PublicKey:  ad.PublicKey,
+		SignCount:  ad.SignCount,
+		AAGUID:     ad.AAGUID,
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion runs the authentication ceremony checks (spec §7.2) against
+// a stored credential and returns the authenticator's new signature counter.
+func (c Config) VerifyAssertion(challenge []byte, resp *AssertionResponse, publicKey []byte, storedCount uint32) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, ErrClientData
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := ParseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.Verify(signed, resp.Response.Signature) {
+		return 0, ErrSignature
+	}
+
+	// Authenticators without a counter always report 0; otherwise it must grow.
+	if (ad.SignCount != 0 || storedCount != 0) && ad.SignCount <= storedCount {
+		return 0, ErrSignCount
+	}
+	return ad.SignCount, nil
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"omhs-backend/internal/account"
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/kanban"
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/requests"
+	"omhs-backend/internal/utils"
+)
+
+// setupAccountRouter wires auth, requests, kanban and the account module.
+func setupAccountRouter(client *mongo.Client) (*gin.Engine, *account.AccountService) {
+	router := gin.Default()
+	api := router.Group("/api")
+	pm := utils.NewProjectManager()
+
+	authRepo := auth.NewMongoUserRepository(client)
+	authService := newTestAuthService(client, pm)
+	auth.RegisterRoutes(api, auth.NewAuthController(authService))
+
+	protected := api.Group("")
+	protected.Use(middleware.JWTMiddleware(authService))
+
+	allowlist := requests.ParseAllowlist(testAllowlist)
+	requestRepo := requests.NewMongoRequestRepository(client)
+	requests.RegisterRoutes(protected, requests.NewRequestController(requests.NewRequestService(requestRepo, allowlist)))
+
+	kanbanRepo := kanban.NewKanbanRepository(requestRepo)
+	kanban.RegisterRoutes(protected, kanban.NewKanbanController(kanban.NewKanbanService(*kanbanRepo)))
+
+	accountService := account.NewAccountService(authRepo, authService, kanbanRepo, requestRepo, allowlist,
+		testMailer, account.DefaultGracePeriod,
+		auth.NewMongoTokenRepository(client), auth.NewMongoSessionRepository(client),
+		auth.NewMongoLoginEventRepository(client), auth.NewMongoResetTokenRepository(client),
+		auth.NewMongoMagicLinkRepository(client), auth.NewMongoPersonalAccessTokenRepository(client),
+		auth.NewMongoWebAuthnRepository(client), auth.NewMongoOIDCRepository(client))
+	account.RegisterRoutes(protected, account.NewAccountController(accountService))
+
+	return router, accountService
+}
+
+// apiRequest sends payload as JSON to apiPrefix+path.
+func apiRequest(router *gin.Engine, method, path, token string, payload interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(method, apiPrefix+path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAccountExport(t *testing.T) {
+	router, _ := setupAccountRouter(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	// A board and one document in a generic collection
+	w := apiRequest(router, "GET", kanban.BasePath, token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code := createDocument(router, "testdb", "testcollection", token, requests.Document{Data: map[string]interface{}{"note": "exported"}})
+	assert.Equal(t, http.StatusCreated, code)
+
+	w = apiRequest(router, "GET", account.BasePath+"/export", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	assert.Contains(t, files, "user.json")
+	assert.Contains(t, files, "kanban.json")
+	assert.Contains(t, files, "requests/testdb/testcollection.json")
+	for _, name := range []string{"login-history.json", "sessions.json", "personal-access-tokens.json", "passkeys.json", "oidc-identities.json"} {
+		assert.Contains(t, files, name)
+	}
+	assert.Contains(t, string(files["login-history.json"]), `"success": true`)
+	assert.Contains(t, string(files["sessions.json"]), `"deviceName"`)
+
+	assert.Contains(t, string(files["user.json"]), user["username"])
+	assert.NotContains(t, string(files["user.json"]), "argon2id")
+	assert.Contains(t, string(files["requests/testdb/testcollection.json"]), "exported")
+
+	cleanupOwnedData(t, registeredUser)
+	DeleteUser(t, registeredUser.ID)
+
+	accountTestManager.RegisterTest(t, "TestAccountExport")
+}
+
+func TestAccountDeletion(t *testing.T) {
+	router, service := setupAccountRouter(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	w := apiRequest(router, "GET", kanban.BasePath, token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code := createDocument(router, "testdb", "testcollection", token, requests.Document{Data: map[string]interface{}{"note": "doomed"}})
+	assert.Equal(t, http.StatusCreated, code)
+
+	w = apiRequest(router, "POST", account.BasePath+"/delete", token, map[string]string{"currentPassword": "wrong"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = apiRequest(router, "POST", account.BasePath+"/delete", token, map[string]string{"currentPassword": user["password"]})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var status account.DeletionStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 14, status.GraceDays)
+
+	msg, ok := testMailer.Last(user["email"])
+	assert.True(t, ok)
+	assert.Contains(t, msg.Text, status.DeletionScheduledAt.Format("2006-01-02"))
+
+	w = apiRequest(router, "POST", account.BasePath+"/delete", token, map[string]string{"currentPassword": user["password"]})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Cancelling in the grace period keeps everything
+	w = apiRequest(router, "DELETE", account.BasePath+"/delete", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := service.PurgeDue(time.Now().Add(30 * 24 * time.Hour))
+	assert.NoError(t, err)
+	_, code = AuthRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	w = apiRequest(router, "POST", account.BasePath+"/delete", token, map[string]string{"currentPassword": user["password"]})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Nothing happens before the grace period is over
+	_, err = service.PurgeDue(time.Now())
+	assert.NoError(t, err)
+	_, code = AuthRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Afterwards the account and everything it owned are gone
+	purged, err := service.PurgeDue(status.DeletionScheduledAt.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, 1)
+
+	n, _ := usersCollection().CountDocuments(context.TODO(), bson.M{"_id": registeredUser.ID})
+	assert.Zero(t, n)
+	n, _ = client.Database(kanban.Database).Collection(kanban.Collection).CountDocuments(context.TODO(), bson.M{"_id": registeredUser.ID})
+	assert.Zero(t, n)
+	n, _ = client.Database("testdb").Collection("testcollection").CountDocuments(context.TODO(), bson.M{"owner": registeredUser.ID})
+	assert.Zero(t, n)
+	n, _ = client.Database("users").Collection("refreshTokens").CountDocuments(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.Zero(t, n)
+
+	_, code = AuthRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	accountTestManager.RegisterTest(t, "TestAccountDeletion")
+}
+
+// cleanupOwnedData removes the board and generic documents a test user created.
+func cleanupOwnedData(t *testing.T, user auth.User) {
+	_, err := client.Database(kanban.Database).Collection(kanban.Collection).DeleteOne(context.TODO(), bson.M{"_id": user.ID})
+	assert.NoError(t, err)
+	_, err = client.Database("testdb").Collection("testcollection").DeleteMany(context.TODO(), bson.M{"owner": user.ID})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"omhs-backend/internal/admin"
+	"omhs-backend/internal/mail"
+)
+
+func TestAdminListAndSearchUsers(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	body, code := adminRequest(router, "GET", "/users?search="+user["username"], adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	var page admin.UserPage
+	json.Unmarshal([]byte(body), &page)
+	assert.Equal(t, int64(1), page.Total)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, registeredUser.ID, page.Users[0].ID)
+	}
+	assert.NotContains(t, body, "password")
+
+	body, code = adminRequest(router, "GET", "/users/"+registeredUser.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, user["username"])
+
+	DeleteUser(t, registeredUser.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminListAndSearchUsers")
+}
+
+func TestAdminRoutesRejectRegularUsers(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	_, code := adminRequest(router, "GET", "/users", token)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminRoutesRejectRegularUsers")
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	_, code := adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/disable", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Existing tokens and new logins are both refused.
+	_, code = adminRequest(router, "GET", "/users", token)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	_, code = adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/enable", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminDisableUser")
+}
+
+func TestAdminPromoteAndDemoteUser(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	_, code := adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/promote", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	promotedToken := AdminLogin(router, user["username"], user["password"])
+	_, code = adminRequest(router, "GET", "/users", promotedToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/demote", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = adminRequest(router, "GET", "/users", promotedToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminPromoteAndDemoteUser")
+}
+
+func TestAdminEmailPreview(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	body, code := adminRequest(router, "GET", "/emails", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, mail.TemplatePasswordReset)
+
+	body, code = adminRequest(router, "GET", "/emails/"+mail.TemplateVerifyEmail+"/preview?locale=de-AT", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	var preview admin.EmailPreview
+	json.Unmarshal([]byte(body), &preview)
+	assert.Equal(t, "de", preview.Locale)
+	assert.Contains(t, preview.Subject, "Bestätige")
+	assert.Contains(t, preview.HTML, "<html lang=\"de\">")
+
+	_, code = adminRequest(router, "GET", "/emails/"+mail.TemplateVerifyEmail+"/preview?format=html", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = adminRequest(router, "GET", "/emails/unknown/preview", adminToken)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	adminTestManager.RegisterTest(t, "TestAdminEmailPreview")
+}
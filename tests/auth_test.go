@@ -1,15 +1,27 @@
 package tests
 
 import (
+	"context"
 	"encoding/json"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
 
 	"omhs-backend/internal/auth"
+	"omhs-backend/internal/password"
+	"omhs-backend/internal/utils"
 )
 
 func registerUserAndGetToken(t *testing.T, router *gin.Engine, user map[string]string) (auth.User, string) {
@@ -20,6 +32,9 @@ func registerUserAndGetToken(t *testing.T, router *gin.Engine, user map[string]s
 	json.Unmarshal([]byte(body), &registeredUser)
 	assert.Equal(t, user["username"], registeredUser.Username)
 	assert.Equal(t, user["email"], registeredUser.Email)
+	assert.False(t, registeredUser.EmailVerified)
+
+	verifyUser(t, router, &registeredUser)
 
 	body, code = LoginUser(router, user["username"], user["password"])
 	assert.Equal(t, http.StatusOK, code)
@@ -32,16 +47,21 @@ func registerUserAndGetToken(t *testing.T, router *gin.Engine, user map[string]s
 	return registeredUser, token
 }
 
+func verifyUser(t *testing.T, router *gin.Engine, user *auth.User) {
+	token, err := auth.NewEmailVerificationToken(user)
+	assert.NoError(t, err)
+
+	_, code := VerifyEmail(router, token)
+	assert.Equal(t, http.StatusOK, code)
+}
+
 func TestRegister(t *testing.T) {
 	router, _ := initializeRouterAndControllers(client)
 
 	user := setupTestData()
 	registeredUser, _ := registerUserAndGetToken(t, router, user)
 
-	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
-
-	_, code := DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	DeleteUser(t, registeredUser.ID)
 
 	authTestManager.RegisterTest(t, "TestRegister")
 }
@@ -55,27 +75,22 @@ func TestResetPassword(t *testing.T) {
 	_, code := ResetPassword(router, user["email"], user["username"])
 	assert.Equal(t, http.StatusOK, code)
 
-	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
-
-	body, code := GetPasskey(router, "users", "authentication", registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
-
-	var root map[string]json.RawMessage
-	json.Unmarshal([]byte(body), &root)
+	passkey := GetPasskey(t, user["email"])
+	assert.NotEmpty(t, passkey, "Passkey should not be empty")
 
-	// Unmarshal only the "data" field directly into userDoc
-	var userDoc map[string]interface{}
-	if data, ok := root["data"]; ok {
-		json.Unmarshal(data, &userDoc)
-	}
-	passkey, ok := userDoc["passkey"].(string)
-	assert.True(t, ok, "Passkey should not be empty")
+	// The passkey is never stored in plaintext
+	var userDoc bson.M
+	assert.NoError(t, usersCollection().FindOne(context.TODO(), bson.M{"_id": registeredUser.ID}).Decode(&userDoc))
+	assert.NotContains(t, userDoc, "passkey")
 
 	_, code = ChangePassword(router, user["email"], user["username"], passkey, "newPassword")
 	assert.Equal(t, http.StatusOK, code)
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	// and works only once
+	_, code = ChangePassword(router, user["email"], user["username"], passkey, "otherPassword")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	DeleteUser(t, registeredUser.ID)
 
 	authTestManager.RegisterTest(t, "TestResetPassword")
 }
@@ -98,8 +113,9 @@ func TestDuplicateUserRegistration(t *testing.T) {
 func TestInvalidLogin(t *testing.T) {
 	router, _ := initializeRouterAndControllers(client)
 
+	// a fresh name each run, so earlier runs cannot have locked it
 	user := map[string]string{
-		"username": "invalid_user",
+		"username": "invalid_user_" + generateRandomString(5),
 		"password": "invalid_pass",
 	}
 
@@ -135,10 +151,601 @@ func TestPasswordChangeWithInvalidPasskey(t *testing.T) {
 	_, code = ChangePassword(router, user["email"], user["username"], "invalid_passkey", "newPassword")
 	assert.Equal(t, http.StatusUnauthorized, code)
 
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestPasswordChangeWithInvalidPasskey")
+}
+
+func TestPasswordResetLockout(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	_, code := ResetPassword(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusOK, code)
+	passkey := GetPasskey(t, user["email"])
+
+	// A second passkey cannot be requested straight away
+	_, code = ResetPassword(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	for i := 1; i < auth.MaxResetAttempts; i++ {
+		_, code = ChangePassword(router, user["email"], user["username"], "00000000", "newPassword")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	_, code = ChangePassword(router, user["email"], user["username"], "00000000", "newPassword")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// Once locked, even the right passkey is refused
+	_, code = ChangePassword(router, user["email"], user["username"], passkey, "newPassword")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestPasswordResetLockout")
+}
+
+func TestLoginLockout(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
 	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
+	for i := 1; i < testThrottleConfig.MaxUserFailures; i++ {
+		_, code := LoginUser(router, user["username"], "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	// The failure that reaches the limit locks the account and tells the owner
+	req, _ := http.NewRequest("POST", apiPrefix+auth.BasePath+"/login",
+		strings.NewReader(`{"username":"`+user["username"]+`","password":"wrong-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	sent, ok := testMailer.Last(user["email"])
+	assert.True(t, ok)
+	assert.Contains(t, sent.Subject, "locked")
+
+	// While locked even the right password is refused
+	_, code := LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	_, code = adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/unlock", adminToken)
 	assert.Equal(t, http.StatusOK, code)
 
-	authTestManager.RegisterTest(t, "TestPasswordChangeWithInvalidPasskey")
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestLoginLockout")
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	throttle := auth.NewLoginThrottle(auth.NewMongoLoginAttemptRepository(client), auth.LoginThrottleConfig{
+		MaxUserFailures: 100,
+		MaxIPFailures:   2,
+		LockoutDuration: time.Minute,
+		FailureWindow:   time.Minute,
+	})
+	ip := "192.0.2." + strconv.Itoa(rand.Intn(250)+1)
+	username := "iplock" + generateRandomString(5)
+	defer throttle.Reset(username)
+	defer client.Database("users").Collection("loginAttempts").DeleteOne(context.TODO(), bson.M{"_id": "ip:" + ip})
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err := throttle.RecordFailure(username, ip, now)
+		assert.NoError(t, err)
+	}
+
+	// A locked IP throttles the client without calling the account locked
+	err := throttle.Check("someone-else", ip, now)
+	assert.ErrorIs(t, err, auth.ErrLoginThrottled)
+	assert.NotErrorIs(t, err, auth.ErrAccountLocked)
+
+	authTestManager.RegisterTest(t, "TestLoginIPLockout")
+}
+
+func TestLoginProgressiveDelay(t *testing.T) {
+	cfg := auth.DefaultLoginThrottleConfig()
+
+	assert.Zero(t, cfg.Delay(cfg.DelayAfter))
+	assert.Equal(t, cfg.BaseDelay, cfg.Delay(cfg.DelayAfter+1))
+	assert.Equal(t, 2*cfg.BaseDelay, cfg.Delay(cfg.DelayAfter+2))
+	assert.Equal(t, cfg.MaxDelay, cfg.Delay(100))
+
+	authTestManager.RegisterTest(t, "TestLoginProgressiveDelay")
+}
+
+func TestPasswordHashing(t *testing.T) {
+	hasher := utils.NewPasswordHasher(utils.DefaultArgon2Params())
+
+	hash, err := hasher.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	ok, rehash := hasher.Verify(hash, "correct horse")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _ = hasher.Verify(hash, "wrong horse")
+	assert.False(t, ok)
+
+	// Stronger parameters mark existing hashes for an upgrade
+	stronger := utils.DefaultArgon2Params()
+	stronger.Iterations++
+	ok, rehash = utils.NewPasswordHasher(stronger).Verify(hash, "correct horse")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	ok, rehash = hasher.Verify(string(legacy), "correct horse")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	authTestManager.RegisterTest(t, "TestPasswordHashing")
+}
+
+func TestLegacyBcryptRehashedOnLogin(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	// Simulate an account created before the switch to argon2id
+	legacy, _ := bcrypt.GenerateFromPassword([]byte(user["password"]), bcrypt.DefaultCost)
+	_, err := usersCollection().UpdateOne(context.TODO(), bson.M{"_id": registeredUser.ID},
+		bson.M{"$set": bson.M{"password": string(legacy)}})
+	assert.NoError(t, err)
+
+	_, code := LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	var userDoc bson.M
+	assert.NoError(t, usersCollection().FindOne(context.TODO(), bson.M{"_id": registeredUser.ID}).Decode(&userDoc))
+	assert.True(t, strings.HasPrefix(userDoc["password"].(string), "$argon2id$"))
+
+	// and the upgraded hash still logs in
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestLegacyBcryptRehashedOnLogin")
+}
+
+func loginAndGetTokens(t *testing.T, router *gin.Engine, username, password string) map[string]string {
+	body, code := LoginUser(router, username, password)
+	assert.Equal(t, http.StatusOK, code)
+
+	var tokens map[string]string
+	json.Unmarshal([]byte(body), &tokens)
+	assert.NotEmpty(t, tokens["token"])
+	assert.NotEmpty(t, tokens["refreshToken"])
+	return tokens
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	tokens := loginAndGetTokens(t, router, user["username"], user["password"])
+
+	body, code := RefreshTokens(router, tokens["refreshToken"])
+	assert.Equal(t, http.StatusOK, code)
+
+	var rotated map[string]string
+	json.Unmarshal([]byte(body), &rotated)
+	assert.NotEmpty(t, rotated["token"])
+	assert.NotEqual(t, tokens["refreshToken"], rotated["refreshToken"])
+
+	// Replaying the old refresh token revokes the whole family,
+	// including the token that was just issued.
+	_, code = RefreshTokens(router, tokens["refreshToken"])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	_, code = RefreshTokens(router, rotated["refreshToken"])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestRefreshTokenRotation")
+}
+
+func TestLogoutRevokesAccessToken(t *testing.T) {
+	router := setupKanbanRouter(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	tokens := loginAndGetTokens(t, router, user["username"], user["password"])
+
+	_, code := LogoutUser(router, tokens["refreshToken"])
+	assert.Equal(t, http.StatusOK, code)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/kanban", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["token"])
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	_, code = RefreshTokens(router, tokens["refreshToken"])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestLogoutRevokesAccessToken")
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	body, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusCreated, code)
+
+	var registeredUser auth.User
+	json.Unmarshal([]byte(body), &registeredUser)
+
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusForbidden, code)
+
+	_, code = VerifyEmail(router, "not-a-token")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	verifyUser(t, router, &registeredUser)
+
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = ResendVerification(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusConflict, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestLoginRequiresVerifiedEmail")
+}
+
+func TestResendVerificationThrottled(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	body, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusCreated, code)
+
+	var registeredUser auth.User
+	json.Unmarshal([]byte(body), &registeredUser)
+
+	// Registration just sent one, so an immediate resend is refused.
+	_, code = ResendVerification(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestResendVerificationThrottled")
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	body, code := AuthRequest(router, "POST", "/2fa/setup", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	var setup auth.TOTPSetupResponse
+	json.Unmarshal([]byte(body), &setup)
+	assert.Contains(t, setup.URI, "otpauth://totp/")
+
+	totp, _ := utils.TOTPCode(setup.Secret, time.Now())
+	body, code = AuthRequest(router, "POST", "/2fa/confirm", token, map[string]string{"code": totp})
+	assert.Equal(t, http.StatusOK, code)
+
+	var recovery auth.RecoveryCodesResponse
+	json.Unmarshal([]byte(body), &recovery)
+	assert.Len(t, recovery.RecoveryCodes, auth.RecoveryCodeCount)
+
+	// The password step now only yields an MFA challenge
+	body, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	var challenge auth.LoginResult
+	json.Unmarshal([]byte(body), &challenge)
+	assert.True(t, challenge.MFARequired)
+	assert.Nil(t, challenge.TokenPair)
+
+	mfa := map[string]string{"mfaToken": challenge.MFAToken, "code": totp}
+	body, code = AuthRequest(router, "POST", "/login/mfa", "", mfa)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "refreshToken")
+
+	// The same code cannot be replayed
+	_, code = AuthRequest(router, "POST", "/login/mfa", "", mfa)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Recovery codes work exactly once
+	mfa["code"] = recovery.RecoveryCodes[0]
+	_, code = AuthRequest(router, "POST", "/login/mfa", "", mfa)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = AuthRequest(router, "POST", "/login/mfa", "", mfa)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestTOTPEnrollmentAndLogin")
+}
+
+func TestMFALockoutUnderConcurrency(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+
+	body, code := AuthRequest(router, "POST", "/2fa/setup", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	var setup auth.TOTPSetupResponse
+	json.Unmarshal([]byte(body), &setup)
+	totp, _ := utils.TOTPCode(setup.Secret, time.Now())
+	_, code = AuthRequest(router, "POST", "/2fa/confirm", token, map[string]string{"code": totp})
+	assert.Equal(t, http.StatusOK, code)
+
+	body, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+	var challenge auth.LoginResult
+	json.Unmarshal([]byte(body), &challenge)
+
+	// Parallel guesses get no more tries than sequential ones
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	codes := map[int]int{}
+	for i := 0; i < 3*auth.MaxMFAFailures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, code := AuthRequest(router, "POST", "/login/mfa", "", map[string]string{"mfaToken": challenge.MFAToken, "code": "wrong-code"})
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, auth.MaxMFAFailures, codes[http.StatusUnauthorized])
+	assert.Equal(t, 2*auth.MaxMFAFailures, codes[http.StatusTooManyRequests])
+
+	// Not even the right code gets through now
+	totp, _ = utils.TOTPCode(setup.Secret, time.Now().Add(30*time.Second))
+	_, code = AuthRequest(router, "POST", "/login/mfa", "", map[string]string{"mfaToken": challenge.MFAToken, "code": totp})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// After the cooldown the whole budget is back, not a single attempt
+	_, err := usersCollection().UpdateOne(context.TODO(), bson.M{"_id": registeredUser.ID},
+		bson.M{"$set": bson.M{"mfaFailedAt": time.Now().Add(-auth.MFAFailureCooldown - time.Minute)}})
+	assert.NoError(t, err)
+	for i := 1; i < auth.MaxMFAFailures; i++ {
+		_, code = AuthRequest(router, "POST", "/login/mfa", "", map[string]string{"mfaToken": challenge.MFAToken, "code": "wrong-code"})
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	_, code = AuthRequest(router, "POST", "/login/mfa", "", map[string]string{"mfaToken": challenge.MFAToken, "code": totp})
+	assert.Equal(t, http.StatusOK, code)
+
+	authTestManager.RegisterTest(t, "TestMFALockoutUnderConcurrency")
+}
+
+func TestPasswordResetLockoutUnderConcurrency(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+
+	_, code := ResetPassword(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusOK, code)
+	passkey := GetPasskey(t, user["email"])
+
+	// Parallel guesses get no more tries than sequential ones: the last
+	// allowed wrong guess already reports the lock
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	codes := map[int]int{}
+	for i := 0; i < 3*auth.MaxResetAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, code := ChangePassword(router, user["email"], user["username"], "00000000", "newPassword")
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, auth.MaxResetAttempts-1, codes[http.StatusUnauthorized])
+	assert.Equal(t, 2*auth.MaxResetAttempts+1, codes[http.StatusTooManyRequests])
+
+	_, code = ChangePassword(router, user["email"], user["username"], passkey, "newPassword")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	authTestManager.RegisterTest(t, "TestPasswordResetLockoutUnderConcurrency")
+}
+
+// policyCodes decodes a 400 policy response into its field error codes.
+func policyCodes(t *testing.T, body string) []string {
+	var resp struct {
+		Fields []password.FieldError `json:"fields"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(body), &resp))
+	codes := make([]string, len(resp.Fields))
+	for i, f := range resp.Fields {
+		codes[i] = f.Code
+	}
+	return codes
+}
+
+func TestBreachedPasswordFilter(t *testing.T) {
+	for _, pw := range []string{"password", "Password1", "qwerty123", "P@ssw0rd", "letmein!"} {
+		assert.True(t, password.Breached(pw), pw)
+	}
+	for _, pw := range []string{"vq7#rL2m!xTz", "correct-Horse-battery-9"} {
+		assert.False(t, password.Breached(pw), pw)
+	}
+
+	authTestManager.RegisterTest(t, "TestBreachedPasswordFilter")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	defer func(p password.Policy) { testPasswordPolicy = p }(testPasswordPolicy)
+	testPasswordPolicy = password.DefaultPolicy()
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+
+	// Every broken rule is reported at once
+	user["password"] = "password1"
+	body, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.ElementsMatch(t, []string{password.CodeTooShort, password.CodeTooFewClasses, password.CodeBreached}, policyCodes(t, body))
+
+	user["password"] = "Xy7!" + strings.ToUpper(user["username"])
+	body, code = RegisterUser(router, user)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{password.CodeContainsUser}, policyCodes(t, body))
+
+	user["password"] = "vq7#rL2m!xTz"
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	_, code = ResetPassword(router, user["email"], user["username"])
+	assert.Equal(t, http.StatusOK, code)
+	passkey := GetPasskey(t, user["email"])
+
+	// The current password cannot be reused, and the passkey survives the rejection
+	body, code = ChangePassword(router, user["email"], user["username"], passkey, user["password"])
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{password.CodeReused}, policyCodes(t, body))
+
+	body, code = ChangePassword(router, user["email"], user["username"], passkey, "short")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"field":"newPassword"`)
+
+	_, code = ChangePassword(router, user["email"], user["username"], passkey, "Gk4$wN9pQe2r")
+	assert.Equal(t, http.StatusOK, code)
+
+	// The old hash moved into the history
+	var stored auth.User
+	assert.NoError(t, usersCollection().FindOne(context.TODO(), bson.M{"_id": registeredUser.ID}).Decode(&stored))
+	assert.Len(t, stored.PasswordHistory, 1)
+	assert.True(t, password.Reused(user["password"], stored.PasswordHistory...))
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestPasswordPolicy")
+}
+
+func TestMeProfile(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	_, code := AuthRequest(router, "GET", "/me", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	body, code := AuthRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "password")
+	assert.NotContains(t, body, "totpSecret")
+
+	var me auth.PublicUser
+	assert.NoError(t, json.Unmarshal([]byte(body), &me))
+	assert.Equal(t, registeredUser.ID, me.ID)
+	assert.Equal(t, user["email"], me.Email)
+
+	update := map[string]string{"displayName": "Jane Doe", "locale": "de-AT", "timezone": "Europe/Vienna"}
+	body, code = AuthRequest(router, "PATCH", "/me", token, update)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal([]byte(body), &me))
+	assert.Equal(t, "Jane Doe", me.DisplayName)
+	assert.Equal(t, "de", me.Locale)
+	assert.Equal(t, "Europe/Vienna", me.Timezone)
+
+	// Fields left out stay as they are
+	_, code = AuthRequest(router, "PATCH", "/me", token, map[string]string{"timezone": "UTC"})
+	assert.Equal(t, http.StatusOK, code)
+	body, _ = AuthRequest(router, "GET", "/me", token, nil)
+	assert.NoError(t, json.Unmarshal([]byte(body), &me))
+	assert.Equal(t, "Jane Doe", me.DisplayName)
+	assert.Equal(t, "UTC", me.Timezone)
+
+	_, code = AuthRequest(router, "PATCH", "/me", token, map[string]string{"timezone": "Mars/Olympus_Mons"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// A new email needs the current password and only applies once confirmed
+	newEmail := "me_" + generateRandomString(8) + "@example.com"
+	_, code = AuthRequest(router, "PATCH", "/me", token, map[string]string{"email": newEmail})
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = AuthRequest(router, "PATCH", "/me", token, map[string]string{"email": newEmail, "currentPassword": "wrong"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	body, code = AuthRequest(router, "PATCH", "/me", token, map[string]string{"email": newEmail, "currentPassword": user["password"]})
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal([]byte(body), &me))
+	assert.Equal(t, user["email"], me.Email)
+	assert.Equal(t, newEmail, me.PendingEmail)
+
+	confirm := GetLinkToken(t, newEmail)
+	_, code = AuthRequest(router, "GET", "/confirm-email?token="+url.QueryEscape(confirm), "", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	body, _ = AuthRequest(router, "GET", "/me", token, nil)
+	assert.NoError(t, json.Unmarshal([]byte(body), &me))
+	assert.Equal(t, newEmail, me.Email)
+	assert.Empty(t, me.PendingEmail)
+	assert.True(t, me.EmailVerified)
+
+	// The link is single-use
+	_, code = AuthRequest(router, "GET", "/confirm-email?token="+url.QueryEscape(confirm), "", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestMeProfile")
+}
+
+func TestChangeOwnPassword(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+
+	_, code := AuthRequest(router, "POST", "/me/password", token, map[string]string{"currentPassword": "wrong", "newPassword": "Gk4$wN9pQe2r"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	body, code := AuthRequest(router, "POST", "/me/password", token, map[string]string{"currentPassword": user["password"], "newPassword": "Gk4$wN9pQe2r"})
+	assert.Equal(t, http.StatusOK, code)
+
+	var tokens auth.TokenPair
+	assert.NoError(t, json.Unmarshal([]byte(body), &tokens))
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// Other sessions are logged out, the returned tokens work
+	_, code = AuthRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = AuthRequest(router, "GET", "/me", tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = LoginUser(router, user["username"], user["password"])
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = LoginUser(router, user["username"], "Gk4$wN9pQe2r")
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestChangeOwnPassword")
 }
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/admin"
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/middleware"
+)
+
+func TestAdminImpersonation(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, userToken := registerUserAndGetToken(t, router, user)
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	body, code := adminRequest(router, "POST", "/users/"+registeredUser.ID.Hex()+"/impersonate", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	var impersonation auth.ImpersonationToken
+	assert.NoError(t, json.Unmarshal([]byte(body), &impersonation))
+	assert.NotEmpty(t, impersonation.AccessToken)
+	if assert.NotNil(t, impersonation.User) {
+		assert.Equal(t, registeredUser.ID, impersonation.User.ID)
+	}
+
+	// The admin sees what the user sees, and every response says who is looking
+	w := apiRequest(router, "GET", auth.BasePath+"/me", impersonation.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, os.Getenv("ADMIN_USER"), w.Header().Get(middleware.ImpersonationHeader))
+	assert.Contains(t, w.Body.String(), user["username"])
+
+	// but cannot take over the account or use admin rights as the user
+	w = apiRequest(router, "POST", auth.BasePath+"/tokens", impersonation.AccessToken, map[string]interface{}{"name": "keep"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	_, code = adminRequest(router, "GET", "/users", impersonation.AccessToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// nor log the user out everywhere, which an impersonation token with no
+	// session of its own would otherwise do
+	w = apiRequest(router, "DELETE", auth.BasePath+"/sessions", impersonation.AccessToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = apiRequest(router, "GET", auth.BasePath+"/me", userToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Admins cannot be impersonated
+	w = apiRequest(router, "GET", auth.BasePath+"/me", adminToken, nil)
+	assert.Empty(t, w.Header().Get(middleware.ImpersonationHeader))
+	var adminUser auth.PublicUser
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &adminUser))
+	_, code = adminRequest(router, "POST", "/users/"+adminUser.ID.Hex()+"/impersonate", adminToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	body, code = adminRequest(router, "GET", "/audit-log?userId="+registeredUser.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	var log auth.AuditLogPage
+	assert.NoError(t, json.Unmarshal([]byte(body), &log))
+	assert.Equal(t, int64(5), log.Total)
+	if assert.Len(t, log.Entries, 5) {
+		assert.Equal(t, apiPrefix+auth.BasePath+"/sessions", log.Entries[0].Path)
+		assert.Equal(t, http.StatusForbidden, log.Entries[0].Status)
+		assert.Equal(t, apiPrefix+admin.BasePath+"/users", log.Entries[1].Path)
+		assert.Equal(t, http.StatusForbidden, log.Entries[1].Status)
+		assert.Equal(t, apiPrefix+auth.BasePath+"/me", log.Entries[3].Path)
+		assert.Equal(t, http.StatusOK, log.Entries[3].Status)
+		assert.Equal(t, adminUser.ID, log.Entries[3].ActorID)
+		assert.Equal(t, auth.AuditActionImpersonate, log.Entries[4].Action)
+	}
+
+	_, code = adminRequest(router, "GET", "/audit-log?actorId=nope", adminToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, err := client.Database("users").Collection("auditLog").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.NoError(t, err)
+	DeleteUser(t, registeredUser.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminImpersonation")
+}
@@ -16,6 +16,7 @@ import (
 	"omhs-backend/internal/kanban"
 	"omhs-backend/internal/middleware"
 	"omhs-backend/internal/requests"
+	"omhs-backend/internal/utils"
 )
 
 // Build full router with Auth + Requests + Kanban + JWT middleware
@@ -24,24 +25,22 @@ func setupKanbanRouter(client *mongo.Client) *gin.Engine {
 	api := router.Group("/api")
 
 	// Auth
-	authRepo := auth.NewMongoUserRepository(client)
-	authService := auth.NewAuthService(authRepo)
+	authService := newTestAuthService(client, utils.NewProjectManager())
 	authController := auth.NewAuthController(authService)
 	auth.RegisterRoutes(api, authController)
 
 	// Requests
 	requestRepo := requests.NewMongoRequestRepository(client)
-	requestService := requests.NewRequestService(requestRepo)
+	requestService := requests.NewRequestService(requestRepo, requests.ParseAllowlist(testAllowlist))
 	requestController := requests.NewRequestController(requestService)
-	requests.RegisterRoutes(api, requestController)
+	protected := api.Group("/")
+	protected.Use(middleware.JWTMiddleware(authService))
+	requests.RegisterRoutes(protected, requestController)
 
 	// Kanban
 	kanbanRepo := kanban.NewKanbanRepository(requestRepo)
 	kanbanService := kanban.NewKanbanService(*kanbanRepo)
 	kanbanController := kanban.NewKanbanController(kanbanService)
-
-	protected := api.Group("/")
-	protected.Use(middleware.JWTMiddleware())
 	kanban.RegisterRoutes(protected, kanbanController)
 
 	return router
@@ -83,8 +82,7 @@ func TestKanbanCreate(t *testing.T) {
 	_, code := deleteDocument(router, "data", "Kanbans", registeredUser.ID.Hex(), adminToken)
 	assert.Equal(t, http.StatusOK, code)
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	DeleteUser(t, registeredUser.ID)
 }
 
 func TestKanbanGet(t *testing.T) {
@@ -126,8 +124,7 @@ func TestKanbanGet(t *testing.T) {
 	_, code := deleteDocument(router, "data", "Kanbans", registeredUser.ID.Hex(), adminToken)
 	assert.Equal(t, http.StatusOK, code)
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	DeleteUser(t, registeredUser.ID)
 }
 
 func TestKanbanUpdate(t *testing.T) {
@@ -176,8 +173,7 @@ func TestKanbanUpdate(t *testing.T) {
 	_, code := deleteDocument(router, "data", "Kanbans", registeredUser.ID.Hex(), adminToken)
 	assert.Equal(t, http.StatusOK, code)
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	DeleteUser(t, registeredUser.ID)
 }
 
 func TestKanbanLazyCreation(t *testing.T) {
@@ -190,6 +186,7 @@ func TestKanbanLazyCreation(t *testing.T) {
 
 	var registeredUser auth.User
 	json.Unmarshal([]byte(body), &registeredUser)
+	verifyUser(t, router, &registeredUser)
 
 	// Login → get JWT
 	body, code = LoginUser(router, user["username"], user["password"])
@@ -220,7 +217,7 @@ func TestKanbanLazyCreation(t *testing.T) {
 
 	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
 
-	_, code = GetPasskey(
+	_, code = getDocument(
 		router,
 		"data",
 		"Kanbans",
@@ -240,6 +237,5 @@ func TestKanbanLazyCreation(t *testing.T) {
 	)
 	assert.Equal(t, http.StatusOK, code)
 
-	_, code = DeleteUser(router, registeredUser.ID.Hex(), adminToken)
-	assert.Equal(t, http.StatusOK, code)
+	DeleteUser(t, registeredUser.ID)
 }
@@ -0,0 +1,373 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/ldap"
+	"omhs-backend/internal/utils"
+)
+
+const (
+	ldapBaseDN      = "dc=example,dc=com"
+	ldapServiceDN   = "cn=svc," + ldapBaseDN
+	ldapServicePass = "svc-secret"
+	ldapAdminsDN    = "cn=admins,ou=groups," + ldapBaseDN
+)
+
+// berNode is a decoded BER element, enough of it to serve LDAP requests.
+type berNode struct {
+	tag      byte
+	value    []byte
+	children []berNode
+}
+
+func (n berNode) str() string {
+	return string(n.value)
+}
+
+func readBER(r *bufio.Reader) (berNode, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berNode{}, err
+	}
+	length, err := r.ReadByte()
+	if err != nil {
+		return berNode{}, err
+	}
+	size := int(length)
+	if length&0x80 != 0 {
+		size = 0
+		for i := 0; i < int(length&0x7f); i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return berNode{}, err
+			}
+			size = size<<8 | int(b)
+		}
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berNode{}, err
+	}
+
+	node := berNode{tag: tag, value: content}
+	if tag&0x20 != 0 {
+		inner := bufio.NewReader(strings.NewReader(string(content)))
+		for {
+			child, err := readBER(inner)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return berNode{}, err
+			}
+			node.children = append(node.children, child)
+		}
+	}
+	return node, nil
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+	switch {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n < 0x100:
+		length = []byte{0x81, byte(n)}
+	default:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	}
+	return append(append([]byte{tag}, length...), content...)
+}
+
+func berSeq(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return berEncode(tag, content)
+}
+
+func berString(s string) []byte {
+	return berEncode(0x04, []byte(s))
+}
+
+// ldapResult encodes an LDAPResult body with the given op tag.
+func ldapResult(tag byte, code byte) []byte {
+	return berSeq(tag, berEncode(0x0a, []byte{code}), berString(""), berString(""))
+}
+
+type stubEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// ldapStub is an in-process LDAP server: simple bind, subtree search with
+// and/equality/present filters, unbind.
+type ldapStub struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries []*stubEntry
+	binds   []string
+}
+
+func newLDAPStub(t *testing.T) *ldapStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &ldapStub{listener: l}
+	s.add(&stubEntry{dn: ldapServiceDN, password: ldapServicePass, attrs: map[string][]string{"objectclass": {"applicationProcess"}}})
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *ldapStub) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStub) add(e *stubEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+}
+
+func (s *ldapStub) addUser(uid, password, mail, cn string, memberOf ...string) string {
+	dn := "uid=" + uid + ",ou=people," + ldapBaseDN
+	s.add(&stubEntry{dn: dn, password: password, attrs: map[string][]string{
+		"objectclass": {"top", "person"}, "uid": {uid}, "mail": {mail}, "cn": {cn}, "memberof": memberOf,
+	}})
+	return dn
+}
+
+func (s *ldapStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapStub) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readBER(r)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id := berEncode(0x02, msg.children[0].value)
+		op := msg.children[1]
+		reply := func(body []byte) { conn.Write(berSeq(0x30, id, body)) }
+
+		switch op.tag {
+		case 0x60: // BindRequest
+			dn, password := op.children[1].str(), op.children[2].str()
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+			code := byte(49)
+			if e := s.find(dn); e != nil && password != "" && e.password == password {
+				code = 0
+			}
+			reply(ldapResult(0x61, code))
+		case 0x63: // SearchRequest
+			base, filter := strings.ToLower(op.children[0].str()), op.children[6]
+			s.mu.Lock()
+			for _, e := range s.entries {
+				if strings.HasSuffix(strings.ToLower(e.dn), base) && matchFilter(filter, e) {
+					reply(encodeEntry(e))
+				}
+			}
+			s.mu.Unlock()
+			reply(ldapResult(0x65, 0))
+		case 0x42: // UnbindRequest
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapStub) find(dn string) *stubEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) {
+			return e
+		}
+	}
+	return nil
+}
+
+func matchFilter(f berNode, e *stubEntry) bool {
+	switch f.tag {
+	case 0xa0:
+		for _, sub := range f.children {
+			if !matchFilter(sub, e) {
+				return false
+			}
+		}
+		return true
+	case 0xa3:
+		for _, v := range e.attrs[strings.ToLower(f.children[0].str())] {
+			if strings.EqualFold(v, f.children[1].str()) {
+				return true
+			}
+		}
+		return false
+	case 0x87:
+		return len(e.attrs[strings.ToLower(f.str())]) > 0
+	}
+	return false
+}
+
+func encodeEntry(e *stubEntry) []byte {
+	var attrs [][]byte
+	for name, values := range e.attrs {
+		var vals [][]byte
+		for _, v := range values {
+			vals = append(vals, berString(v))
+		}
+		attrs = append(attrs, berSeq(0x30, berString(name), berSeq(0x31, vals...)))
+	}
+	return berSeq(0x64, berString(e.dn), berSeq(0x30, attrs...))
+}
+
+func setupLDAPRouter(stub *ldapStub) *gin.Engine {
+	router := gin.Default()
+	pm := utils.NewProjectManager()
+	authRepo := auth.NewMongoUserRepository(client)
+
+	dir := ldap.NewDirectory(ldap.Config{
+		URL:                  stub.url(),
+		BindDN:               ldapServiceDN,
+		BindPassword:         ldapServicePass,
+		UserBaseDN:           "ou=people," + ldapBaseDN,
+		UserAttribute:        "uid",
+		UserObjectClass:      "person",
+		EmailAttribute:       "mail",
+		DisplayNameAttribute: "cn",
+		GroupBaseDN:          "ou=groups," + ldapBaseDN,
+		GroupMemberAttribute: "member",
+		AdminGroups:          []string{"admins"},
+		Timeout:              2 * time.Second,
+	})
+	authService := newTestAuthService(client, pm, auth.NewPasswordAuthenticator(authRepo), auth.NewLDAPAuthenticator(dir, authRepo))
+	auth.RegisterRoutes(router.Group("/api"), auth.NewAuthController(authService))
+	return router
+}
+
+func ldapLogin(t *testing.T, router *gin.Engine, username, password string) (auth.LoginResult, int) {
+	body, code := LoginUser(router, username, password)
+	var result auth.LoginResult
+	json.Unmarshal([]byte(body), &result)
+	return result, code
+}
+
+func findUserByUsername(t *testing.T, username string) auth.User {
+	var user auth.User
+	assert.NoError(t, usersCollection().FindOne(context.TODO(), bson.M{"username": username}).Decode(&user))
+	return user
+}
+
+func TestLDAPLogin(t *testing.T) {
+	stub := newLDAPStub(t)
+	router := setupLDAPRouter(stub)
+
+	jane := "ldap-jane-" + strings.ToLower(generateRandomString(6))
+	bob := "ldap-bob-" + strings.ToLower(generateRandomString(6))
+	stub.addUser(jane, "jane-secret", jane+"@example.com", "Jane Doe", ldapAdminsDN)
+	bobDN := stub.addUser(bob, "bob-secret", bob+"@example.com", "Bob Roe")
+	admins := &stubEntry{dn: ldapAdminsDN, attrs: map[string][]string{"objectclass": {"groupOfNames"}, "cn": {"admins"}}}
+	stub.add(admins)
+
+	// Wrong and empty passwords are refused; an empty one never reaches the
+	// directory as an unauthenticated bind
+	_, code := ldapLogin(t, router, jane, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = ldapLogin(t, router, jane, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// First login provisions the account, admin through memberOf
+	result, code := ldapLogin(t, router, jane, "jane-secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, result.TokenPair)
+	janeUser := findUserByUsername(t, jane)
+	defer DeleteUser(t, janeUser.ID)
+	assert.Equal(t, auth.AuthSourceLDAP, janeUser.AuthSource)
+	assert.True(t, janeUser.IsAdmin)
+	assert.True(t, janeUser.EmailVerified)
+	assert.Equal(t, "Jane Doe", janeUser.DisplayName)
+	assert.Empty(t, janeUser.Password)
+	assert.Contains(t, stub.binds, "uid="+jane+",ou=people,"+ldapBaseDN)
+
+	// The directory owns the password
+	_, code = AuthRequest(router, "POST", "/me/password", result.AccessToken, map[string]string{
+		"currentPassword": "jane-secret", "newPassword": "Another-Secret-42",
+	})
+	assert.Equal(t, http.StatusConflict, code)
+	_, code = ResetPassword(router, janeUser.Email, jane)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Group membership found by group search is synced on every login
+	_, code = ldapLogin(t, router, bob, "bob-secret")
+	assert.Equal(t, http.StatusOK, code)
+	bobUser := findUserByUsername(t, bob)
+	defer DeleteUser(t, bobUser.ID)
+	assert.False(t, bobUser.IsAdmin)
+
+	stub.mu.Lock()
+	admins.attrs["member"] = []string{bobDN}
+	stub.mu.Unlock()
+	_, code = ldapLogin(t, router, bob, "bob-secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, findUserByUsername(t, bob).IsAdmin)
+	assert.Equal(t, bobUser.ID, findUserByUsername(t, bob).ID)
+
+	authTestManager.RegisterTest(t, "TestLDAPLogin")
+}
+
+func TestLDAPLocalAccounts(t *testing.T) {
+	stub := newLDAPStub(t)
+	router := setupLDAPRouter(stub)
+
+	// Local passwords keep working alongside the directory
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+
+	// and a directory entry with the same name cannot take the account over
+	stub.addUser(user["username"], "directory-secret", "someone@example.com", "Someone Else")
+	_, code := ldapLogin(t, router, user["username"], "directory-secret")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Empty(t, findUserByUsername(t, user["username"]).AuthSource)
+
+	// Without the directory nobody can be told their password is wrong
+	stub.listener.Close()
+	_, code = ldapLogin(t, router, "ldap-unknown-"+generateRandomString(6), "whatever")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	_, code = ldapLogin(t, router, user["username"], user["password"])
+	assert.Equal(t, http.StatusOK, code)
+
+	authTestManager.RegisterTest(t, "TestLDAPLocalAccounts")
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/auth"
+)
+
+// sentTo counts the emails sent to addr so far.
+func sentTo(addr string) int {
+	n := 0
+	for _, msg := range testMailer.Messages() {
+		if msg.To == addr {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLoginHistory(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	user["email"] = "history-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	// The first login of an account is no reason for an alert
+	assert.Equal(t, 1, sentTo(user["email"]), "only the verification email")
+
+	_, code := LoginUser(router, user["username"], "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// A login from a new device is, once
+	laptop := loginFrom(t, router, user["username"], user["password"], firefoxOnWindows)
+	assert.Equal(t, 2, sentTo(user["email"]))
+	alert, _ := testMailer.Last(user["email"])
+	assert.Equal(t, "New sign-in to your account", alert.Subject)
+	assert.Contains(t, alert.Text, "Firefox on Windows")
+
+	loginFrom(t, router, user["username"], user["password"], firefoxOnWindows)
+	assert.Equal(t, 2, sentTo(user["email"]))
+
+	// History lists every attempt, newest first
+	body, code := AuthRequest(router, "GET", "/login-history", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "fingerprint")
+	var history auth.LoginHistoryPage
+	assert.NoError(t, json.Unmarshal([]byte(body), &history))
+	assert.Equal(t, int64(4), history.Total)
+	if assert.Len(t, history.Events, 4) {
+		assert.True(t, history.Events[0].Success)
+		assert.False(t, history.Events[0].NewDevice)
+		assert.True(t, history.Events[1].NewDevice)
+		assert.Equal(t, "Firefox on Windows", history.Events[1].DeviceName)
+		assert.Equal(t, auth.LoginMethodPassword, history.Events[1].Method)
+		assert.False(t, history.Events[2].Success)
+		assert.Equal(t, "invalid_credentials", history.Events[2].Reason)
+		assert.True(t, history.Events[3].Success)
+	}
+
+	body, code = AuthRequest(router, "GET", "/login-history?page=2&limit=3", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal([]byte(body), &history))
+	assert.Len(t, history.Events, 1)
+	assert.Equal(t, int64(3), history.Limit)
+
+	_, err := client.Database("users").Collection("loginEvents").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.NoError(t, err)
+	_, err = client.Database("users").Collection("sessions").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.NoError(t, err)
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestLoginHistory")
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/auth"
+)
+
+var magicLinkPattern = regexp.MustCompile(`token=(\S+)`)
+
+func TestMagicLink(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	user["email"] = "magic-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	sent := sentTo(user["email"])
+
+	// Unknown addresses get the same answer
+	_, code := AuthRequest(router, "POST", "/magic-link", "", map[string]string{"email": "nobody-" + user["email"]})
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code = AuthRequest(router, "POST", "/magic-link", "", map[string]string{"email": strings.ToUpper(user["email"])})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, sent+1, sentTo(user["email"]))
+	msg, _ := testMailer.Last(user["email"])
+	assert.Equal(t, "Your login link", msg.Subject)
+
+	// Asking again right away sends nothing
+	_, code = AuthRequest(router, "POST", "/magic-link", "", map[string]string{"email": user["email"]})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, sent+1, sentTo(user["email"]))
+
+	match := magicLinkPattern.FindStringSubmatch(msg.Text)
+	if !assert.NotNil(t, match, "no link in email") {
+		return
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+
+	// Opening the link, as mail scanners do, only leads to the frontend
+	w := apiRequest(router, "GET", auth.BasePath+"/magic-link/consume?token="+url.QueryEscape(token), "", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	target, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, token, target.Query().Get("token"))
+	n, _ := client.Database("users").Collection("magicLinks").CountDocuments(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.Equal(t, int64(1), n)
+
+	body, code := AuthRequest(router, "POST", "/magic-link/consume", "", map[string]string{"token": target.Query().Get("token")})
+	assert.Equal(t, http.StatusOK, code)
+	var result auth.LoginResult
+	assert.NoError(t, json.Unmarshal([]byte(body), &result))
+	if assert.NotNil(t, result.TokenPair) {
+		_, code = AuthRequest(router, "GET", "/me", result.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	// Links work once
+	_, code = AuthRequest(router, "POST", "/magic-link/consume", "", map[string]string{"token": token})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = AuthRequest(router, "POST", "/magic-link/consume", "", map[string]string{"token": "not-a-token"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	body, code = AuthRequest(router, "GET", "/login-history", result.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	var history auth.LoginHistoryPage
+	assert.NoError(t, json.Unmarshal([]byte(body), &history))
+	if assert.GreaterOrEqual(t, len(history.Events), 2) {
+		assert.False(t, history.Events[0].Success)
+		assert.Equal(t, "invalid_magic_link", history.Events[0].Reason)
+		assert.True(t, history.Events[1].Success)
+		assert.Equal(t, auth.LoginMethodMagicLink, history.Events[1].Method)
+	}
+
+	for _, name := range []string{"loginEvents", "sessions", "magicLinks"} {
+		_, err = client.Database("users").Collection(name).DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+		assert.NoError(t, err)
+	}
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestMagicLink")
+}
+
+func TestMagicLinkAccountChanged(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	user["email"] = "magic-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+
+	_, code := AuthRequest(router, "POST", "/magic-link", "", map[string]string{"email": user["email"]})
+	assert.Equal(t, http.StatusOK, code)
+	msg, _ := testMailer.Last(user["email"])
+	match := magicLinkPattern.FindStringSubmatch(msg.Text)
+	if !assert.NotNil(t, match, "no link in email") {
+		return
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+
+	// An account that moved to the directory or lost its verified email no
+	// longer logs in by link, and the refusal leaves the link in place
+	for _, change := range []bson.M{{"authSource": "ldap"}, {"emailVerified": false}} {
+		_, err = usersCollection().UpdateOne(context.TODO(), bson.M{"_id": registeredUser.ID}, bson.M{"$set": change})
+		assert.NoError(t, err)
+
+		_, code = AuthRequest(router, "POST", "/magic-link/consume", "", map[string]string{"token": token})
+		assert.Equal(t, http.StatusUnauthorized, code)
+		n, _ := client.Database("users").Collection("magicLinks").CountDocuments(context.TODO(), bson.M{"userId": registeredUser.ID})
+		assert.Equal(t, int64(1), n)
+
+		_, err = usersCollection().UpdateOne(context.TODO(), bson.M{"_id": registeredUser.ID},
+			bson.M{"$unset": bson.M{"authSource": ""}, "$set": bson.M{"emailVerified": true}})
+		assert.NoError(t, err)
+	}
+
+	_, code = AuthRequest(router, "POST", "/magic-link/consume", "", map[string]string{"token": token})
+	assert.Equal(t, http.StatusOK, code)
+
+	for _, name := range []string{"loginEvents", "sessions", "magicLinks"} {
+		_, err = client.Database("users").Collection(name).DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+		assert.NoError(t, err)
+	}
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestMagicLinkAccountChanged")
+}
@@ -1,17 +1,321 @@
 package tests
 
 import (
-	"omhs-backend/internal/utils"
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"omhs-backend/internal/mail"
 )
 
-// TestSendEmail tests the sendEmail function
-func TestSendEmail(t *testing.T) {
-	// Call the sendEmail function
-	err := utils.SendEmail("recipient@example.com", "Test Subject", "Test Message")
+var testMessage = mail.Message{
+	To:      "recipient@example.com",
+	Subject: "Test Subject",
+	Text:    "Test Message",
+}
+
+// fakeSMTPServer accepts a single SMTP conversation and records what it was told.
+type fakeSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	starttls bool
+	authed   bool
+	from     string
+	rcpt     string
+	data     string
+	done     chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T, implicitTLS, offerStartTLS bool) *fakeSMTPServer {
+	cfg := selfSignedTLSConfig(t)
+
+	var l net.Listener
+	var err error
+	if implicitTLS {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", cfg)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
 	assert.NoError(t, err)
 
-	mailTestManager.RegisterTest(t, "TestSendEmail")
+	s := &fakeSMTPServer{listener: l, tls: cfg, starttls: offerStartTLS, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTPServer) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.listener.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			reply("250-fake")
+			if _, ok := conn.(*tls.Conn); !ok && s.starttls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			s.authed = true
+			reply("235 ok")
+		case "MAIL":
+			s.from = cmd
+			reply("250 ok")
+		case "RCPT":
+			s.rcpt = cmd
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				body.WriteString(l)
+			}
+			s.data = body.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) wait(t *testing.T) {
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP conversation did not finish")
+	}
+}
+
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// clientTLSConfig trusts nothing but the fake server's own certificate.
+func clientTLSConfig(server *fakeSMTPServer) *tls.Config {
+	cert, _ := x509.ParseCertificate(server.tls.Certificates[0].Certificate[0])
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+}
+
+func TestSMTPMailerModes(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     string
+		username string
+	}{
+		{"no auth, plain", mail.TLSNone, ""},
+		{"implicit TLS", mail.TLSImplicit, "sender@example.com"},
+		{"STARTTLS", mail.TLSStartTLS, "sender@example.com"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeSMTPServer(t, tc.mode == mail.TLSImplicit, true)
+			mailer := mail.NewSMTPMailer(mail.SMTPConfig{
+				Host:      "127.0.0.1",
+				Port:      server.port(),
+				Username:  tc.username,
+				Password:  "secret",
+				From:      "sender@example.com",
+				TLS:       tc.mode,
+				TLSConfig: clientTLSConfig(server),
+			})
+
+			err := mailer.Send(testMessage)
+			assert.NoError(t, err)
+			server.wait(t)
+
+			assert.Equal(t, tc.username != "", server.authed)
+			assert.Contains(t, server.from, "sender@example.com")
+			assert.Contains(t, server.rcpt, testMessage.To)
+			assert.Contains(t, server.data, "Subject: Test Subject")
+			assert.Contains(t, server.data, "Test Message")
+		})
+	}
+
+	mailTestManager.RegisterTest(t, "TestSMTPMailerModes")
+}
+
+func TestSMTPMailerRequiresStartTLS(t *testing.T) {
+	server := newFakeSMTPServer(t, false, false)
+
+	// Never fall back to plain text when STARTTLS was asked for.
+	mailer := mail.NewSMTPMailer(mail.SMTPConfig{
+		Host:      "127.0.0.1",
+		Port:      server.port(),
+		Username:  "sender@example.com",
+		Password:  "secret",
+		TLS:       mail.TLSStartTLS,
+		TLSConfig: clientTLSConfig(server),
+	})
+	assert.ErrorIs(t, mailer.Send(testMessage), mail.ErrStartTLSUnsupported)
+	server.wait(t)
+	assert.False(t, server.authed)
+
+	mailTestManager.RegisterTest(t, "TestSMTPMailerRequiresStartTLS")
+}
+
+func TestFileMailerWritesMaildir(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "sender@example.com")
+	assert.NoError(t, err)
+
+	assert.NoError(t, mailer.Send(testMessage))
+
+	files, _ := os.ReadDir(filepath.Join(dir, "new"))
+	assert.Len(t, files, 1)
+
+	content, _ := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	assert.Contains(t, string(content), "To: recipient@example.com")
+	assert.Contains(t, string(content), "Test Message")
+
+	mailTestManager.RegisterTest(t, "TestFileMailerWritesMaildir")
+}
+
+func TestMemoryMailerCaptures(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+
+	assert.NoError(t, mailer.Send(testMessage))
+	sent, ok := mailer.Last(testMessage.To)
+	assert.True(t, ok)
+	assert.Equal(t, testMessage, sent)
+
+	// Header injection is refused before anything is sent
+	bad := testMessage
+	bad.Subject = "Hi\r\nBcc: everyone@example.com"
+	assert.ErrorIs(t, mailer.Send(bad), mail.ErrInvalidMessage)
+	assert.Len(t, mailer.Messages(), 1)
+
+	mailTestManager.RegisterTest(t, "TestMemoryMailerCaptures")
+}
+
+func TestRenderLocalizedTemplate(t *testing.T) {
+	data := mail.Data{"Username": "jane", "Passkey": "1a2b3c4d", "Minutes": 10}
+
+	en, err := mail.Render(mail.TemplatePasswordReset, "", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Password Reset Passkey", en.Subject)
+	assert.Contains(t, en.Text, "1a2b3c4d")
+	assert.Contains(t, en.HTML, "1a2b3c4d")
+
+	de, err := mail.Render(mail.TemplatePasswordReset, "de_DE", data)
+	assert.NoError(t, err)
+	assert.Contains(t, de.Text, "10 Minuten")
+
+	// Unknown locales fall back to English, unknown templates are an error
+	fallback, _ := mail.Render(mail.TemplatePasswordReset, "xx", data)
+	assert.Equal(t, en.Subject, fallback.Subject)
+	_, err = mail.Render("unknown", "en", data)
+	assert.ErrorIs(t, err, mail.ErrUnknownTemplate)
+
+	// HTML output is escaped
+	data["Username"] = "<script>"
+	escaped, _ := mail.Render(mail.TemplatePasswordReset, "en", data)
+	assert.NotContains(t, escaped.HTML, "<script>")
+
+	mailTestManager.RegisterTest(t, "TestRenderLocalizedTemplate")
+}
+
+func TestMultipartMessageHeaders(t *testing.T) {
+	msg, err := mail.Preview(mail.TemplateVerifyEmail, "de")
+	assert.NoError(t, err)
+	msg.To = "recipient@example.com"
+
+	parsed, err := netmail.ReadMessage(strings.NewReader(string(msg.Bytes("sender@example.com"))))
+	assert.NoError(t, err)
+
+	_, err = parsed.Header.Date()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>"))
+
+	// Non-ASCII subjects are RFC 2047 encoded
+	assert.NotEqual(t, msg.Subject, parsed.Header.Get("Subject"))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Equal(t, msg.Subject, subject)
+
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	var types []string
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		assert.NotEmpty(t, body)
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}, types)
+
+	mailTestManager.RegisterTest(t, "TestMultipartMessageHeaders")
 }
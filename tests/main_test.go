@@ -9,12 +9,16 @@ import (
 var authTestManager *TestManager
 var requestsTestManager *TestManager
 var mailTestManager *TestManager
+var adminTestManager *TestManager
+var accountTestManager *TestManager
 
 func TestMain(m *testing.M) {
 	// Initialize test managers for each suite
 	authTestManager = GetTestManager("auth_test suite")
 	requestsTestManager = GetTestManager("requests_test suite")
 	mailTestManager = GetTestManager("mail_test suite")
+	adminTestManager = GetTestManager("admin_test suite")
+	accountTestManager = GetTestManager("account_test suite")
 
 	// Run all tests
 	exitCode := m.Run()
@@ -23,6 +27,8 @@ func TestMain(m *testing.M) {
 	authTestManager.PrintSummary()
 	requestsTestManager.PrintSummary()
 	mailTestManager.PrintSummary()
+	adminTestManager.PrintSummary()
+	accountTestManager.PrintSummary()
 
 	PrintOverallSummary()
 
@@ -0,0 +1,400 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/oidc"
+	"omhs-backend/internal/utils"
+)
+
+const (
+	mockClientID     = "omhs-test"
+	mockClientSecret = "mock-secret"
+)
+
+type mockOIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified interface{}
+	Name          string
+}
+
+type mockAuthorization struct {
+	user        mockOIDCUser
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// mockOIDCProvider is an in-process identity provider: discovery, an
+// authorize endpoint that logs in whoever is set as next user, the token
+// endpoint with PKCE and client authentication, and a JWKS.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	next  mockOIDCUser
+	codes map[string]mockAuthorization
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	m := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) config() oidc.Config {
+	return oidc.Config{
+		Name:         "mock",
+		DisplayName:  "Mock IdP",
+		Issuer:       m.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		RedirectURL:  oidc.DefaultRedirectURL("mock"),
+	}
+}
+
+func (m *mockOIDCProvider) loginAs(user mockOIDCUser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next = user
+}
+
+func (m *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (m *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != mockClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := utils.GenerateToken()
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{user: m.next, nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri")}
+	m.mu.Unlock()
+
+	target, _ := url.Parse(q.Get("redirect_uri"))
+	target.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != mockClientID || secret != mockClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	authz, found := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !found || authz.redirectURI != r.FormValue("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            authz.user.Subject,
+		"aud":            mockClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          authz.nonce,
+		"email":          authz.user.Email,
+		"email_verified": authz.user.EmailVerified,
+		"name":           authz.user.Name,
+	})
+	idToken.Header["kid"] = "mock-1"
+	signed, _ := idToken.SignedString(m.key)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "unused", "token_type": "Bearer", "id_token": signed})
+}
+
+func (m *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "mock-1", "use": "sig", "alg": "RS256",
+		"n": b64(m.key.N.Bytes()), "e": b64(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
+
+func setupOIDCRouter(mock *mockOIDCProvider) *gin.Engine {
+	router, pm := initializeRouterAndControllers(client)
+	api := router.Group("/api")
+
+	authService := newTestAuthService(client, pm)
+	oidcRepo := auth.NewMongoOIDCRepository(client)
+	pm.Execute(oidcRepo.EnsureIndexes, "Failed to create OIDC indexes")
+	provider := oidc.NewProvider(mock.config(), mock.server.Client())
+	service := auth.NewOIDCService(authService, auth.NewMongoUserRepository(client), oidcRepo, provider)
+	auth.RegisterOIDCRoutes(api, auth.NewOIDCController(service))
+
+	// only here to check the issued tokens
+	api.GET("/whoami", middleware.JWTMiddleware(authService), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userId": c.GetString("userId")})
+	})
+	return router
+}
+
+// oidcCallback runs the browser's part: our login redirect with its binding
+// cookie, the provider's redirect back, and the callback. It returns the
+// query the frontend is redirected with, the callback query and the cookie.
+func oidcCallback(t *testing.T, router *gin.Engine, mock *mockOIDCProvider, user mockOIDCUser) (url.Values, url.Values, *http.Cookie) {
+	mock.loginAs(user)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", apiPrefix+auth.BasePath+"/oidc/mock/login", nil))
+	if !assert.Equal(t, http.StatusFound, w.Code) {
+		return nil, nil, nil
+	}
+	authorizeURL := w.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(authorizeURL, mock.server.URL+"/authorize?"))
+	var binding *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == auth.OIDCBindingCookie {
+			binding = cookie
+		}
+	}
+	if !assert.NotNil(t, binding) {
+		return nil, nil, nil
+	}
+	assert.True(t, binding.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, binding.SameSite)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authorizeURL)
+	if !assert.NoError(t, err) {
+		return nil, nil, nil
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	assert.Equal(t, "/api/auth/oidc/mock/callback", callback.Path)
+
+	return callbackRedirect(t, router, callback.RawQuery, binding), callback.Query(), binding
+}
+
+// callbackRedirect calls the callback, with the binding cookie if there is
+// one, and returns the query of the frontend URL it redirects to.
+func callbackRedirect(t *testing.T, router *gin.Engine, rawQuery string, binding *http.Cookie) url.Values {
+	req := httptest.NewRequest("GET", apiPrefix+auth.BasePath+"/oidc/mock/callback?"+rawQuery, nil)
+	if binding != nil {
+		req.AddCookie(binding)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusFound, w.Code) {
+		return url.Values{}
+	}
+	target, err := url.Parse(w.Header().Get("Location"))
+	if !assert.NoError(t, err) {
+		return url.Values{}
+	}
+	return target.Query()
+}
+
+// oidcLogin logs in through the provider and exchanges the code the
+// frontend got, like the frontend would.
+func oidcLogin(t *testing.T, router *gin.Engine, mock *mockOIDCProvider, user mockOIDCUser) (string, int) {
+	redirect, _, _ := oidcCallback(t, router, mock, user)
+	if !assert.Empty(t, redirect.Get("error")) || !assert.NotEmpty(t, redirect.Get("code")) {
+		return "", 0
+	}
+	return AuthRequest(router, "POST", "/oidc/exchange", "", map[string]interface{}{"code": redirect.Get("code")})
+}
+
+func whoami(t *testing.T, router *gin.Engine, body string) string {
+	var result auth.LoginResult
+	assert.NoError(t, json.Unmarshal([]byte(body), &result))
+	if !assert.NotNil(t, result.TokenPair) {
+		return ""
+	}
+	w := apiRequest(router, "GET", "/whoami", result.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var me map[string]string
+	json.Unmarshal(w.Body.Bytes(), &me)
+	return me["userId"]
+}
+
+func cleanupOIDC(t *testing.T, subjects ...string) {
+	_, err := client.Database("users").Collection("oidcIdentities").DeleteMany(context.TODO(), bson.M{"subject": bson.M{"$in": subjects}})
+	assert.NoError(t, err)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	router := setupOIDCRouter(mock)
+
+	body, code := AuthRequest(router, "GET", "/oidc/providers", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"mock"`)
+	_, code = AuthRequest(router, "GET", "/oidc/unknown/login", "", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// First login creates a verified account
+	email := "oidc-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	user := mockOIDCUser{Subject: "sub-" + generateRandomString(8), Email: email, EmailVerified: true, Name: "Jane Provider"}
+	redirect, callback, binding := oidcCallback(t, router, mock, user)
+	assert.Empty(t, redirect.Get("error"))
+	body, code = AuthRequest(router, "POST", "/oidc/exchange", "", map[string]interface{}{"code": redirect.Get("code")})
+	assert.Equal(t, http.StatusOK, code, body)
+	userId := whoami(t, router, body)
+
+	id, _ := primitive.ObjectIDFromHex(userId)
+	defer DeleteUser(t, id)
+	defer cleanupOIDC(t, user.Subject)
+
+	var created auth.User
+	assert.NoError(t, usersCollection().FindOne(context.TODO(), bson.M{"_id": id}).Decode(&created))
+	assert.Equal(t, email, created.Email)
+	assert.True(t, created.EmailVerified)
+	assert.Equal(t, "Jane Provider", created.DisplayName)
+	assert.Empty(t, created.Password)
+
+	// Neither the callback nor the code can be replayed
+	assert.Equal(t, "invalid_state", callbackRedirect(t, router, callback.Encode(), binding).Get("error"))
+	_, code = AuthRequest(router, "POST", "/oidc/exchange", "", map[string]interface{}{"code": redirect.Get("code")})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// The subject maps to the same account even if the email changed
+	user.Email = "changed-" + email
+	body, code = oidcLogin(t, router, mock, user)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, userId, whoami(t, router, body))
+
+	// Identities can be listed and unlinked
+	var result auth.LoginResult
+	json.Unmarshal([]byte(body), &result)
+	body, code = AuthRequest(router, "GET", "/oidc/identities", result.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	var identities []auth.OIDCIdentity
+	assert.NoError(t, json.Unmarshal([]byte(body), &identities))
+	if assert.Len(t, identities, 1) {
+		assert.Equal(t, "mock", identities[0].Provider)
+		assert.NotContains(t, body, user.Subject)
+		_, code = AuthRequest(router, "DELETE", "/oidc/identities/"+identities[0].ID.Hex(), result.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, code)
+	}
+	body, _ = AuthRequest(router, "GET", "/oidc/identities", result.AccessToken, nil)
+	assert.Equal(t, "[]", body)
+
+	// Emails the provider has not verified are not trusted; neither are
+	// callbacks with a missing or forged state
+	unverified := mockOIDCUser{Subject: "sub-" + generateRandomString(8), Email: "unverified-" + email, EmailVerified: "false"}
+	redirect, _, _ = oidcCallback(t, router, mock, unverified)
+	assert.Equal(t, "email_unverified", redirect.Get("error"))
+	assert.Equal(t, "invalid_state", callbackRedirect(t, router, "code=abc&state=forged", binding).Get("error"))
+
+	authTestManager.RegisterTest(t, "TestOIDCLogin")
+}
+
+func TestOIDCLoginCSRF(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	router := setupOIDCRouter(mock)
+
+	// An attacker logs in with their own provider account but stops before
+	// the callback, which they then get a victim's browser to open
+	attacker := mockOIDCUser{Subject: "sub-" + generateRandomString(8), Email: "oidc-" + strings.ToLower(generateRandomString(8)) + "@example.com", EmailVerified: true}
+	defer cleanupOIDC(t, attacker.Subject)
+	mock.loginAs(attacker)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", apiPrefix+auth.BasePath+"/oidc/mock/login", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(w.Header().Get("Location"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	// The victim has no binding cookie, or one from their own login
+	assert.Equal(t, "invalid_state", callbackRedirect(t, router, callback.RawQuery, nil).Get("error"))
+	_, _, victimBinding := oidcCallback(t, router, mock, mockOIDCUser{Subject: "sub-" + generateRandomString(8), Email: "unverified@example.com", EmailVerified: false})
+	assert.Equal(t, "invalid_state", callbackRedirect(t, router, callback.RawQuery, victimBinding).Get("error"))
+
+	n, _ := client.Database("users").Collection("oidcIdentities").CountDocuments(context.TODO(), bson.M{"subject": attacker.Subject})
+	assert.Equal(t, int64(0), n)
+
+	authTestManager.RegisterTest(t, "TestOIDCLoginCSRF")
+}
+
+func TestOIDCAccountLinking(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	router := setupOIDCRouter(mock)
+
+	// A local account with a verified address is linked by email
+	user := setupTestData()
+	user["email"] = "oidc-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+
+	subject := "sub-" + generateRandomString(8)
+	defer cleanupOIDC(t, subject)
+	body, code := oidcLogin(t, router, mock, mockOIDCUser{Subject: subject, Email: strings.ToUpper(user["email"]), EmailVerified: "true"})
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, registeredUser.ID.Hex(), whoami(t, router, body))
+
+	n, _ := client.Database("users").Collection("oidcIdentities").CountDocuments(context.TODO(),
+		bson.M{"subject": subject, "userId": registeredUser.ID})
+	assert.Equal(t, int64(1), n)
+
+	// but never to one whose address nobody has confirmed
+	pending := setupTestData()
+	pending["email"] = "oidc-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+	body, code = RegisterUser(router, pending)
+	assert.Equal(t, http.StatusCreated, code)
+	var pendingUser auth.User
+	json.Unmarshal([]byte(body), &pendingUser)
+	defer DeleteUser(t, pendingUser.ID)
+
+	redirect, _, _ := oidcCallback(t, router, mock, mockOIDCUser{Subject: "sub-" + generateRandomString(8), Email: pending["email"], EmailVerified: true})
+	assert.Equal(t, "email_conflict", redirect.Get("error"))
+
+	authTestManager.RegisterTest(t, "TestOIDCAccountLinking")
+}
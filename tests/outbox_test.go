@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/mail"
+)
+
+// failingMailer simulates an SMTP server that is down.
+type failingMailer struct{}
+
+func (failingMailer) Send(mail.Message) error {
+	return errors.New("connection refused")
+}
+
+var testWorkerConfig = mail.WorkerConfig{
+	PollInterval: 10 * time.Millisecond,
+	Lease:        time.Minute,
+	MaxAttempts:  2,
+	BaseDelay:    0,
+	MaxDelay:     0,
+}
+
+// drainOutbox lets worker process everything that is due.
+func drainOutbox(t *testing.T, worker *mail.Worker) {
+	for {
+		processed, err := worker.ProcessNext()
+		assert.NoError(t, err)
+		if !processed {
+			return
+		}
+	}
+}
+
+func TestOutboxEnqueueIsIdempotent(t *testing.T) {
+	repo := mail.NewMongoOutboxRepository(client)
+	assert.NoError(t, repo.EnsureIndexes())
+	outbox := mail.NewOutbox(repo)
+
+	msg := testMessage
+	msg.ID = "outbox-test-" + generateRandomString(8)
+	assert.NoError(t, outbox.Send(msg))
+	assert.NoError(t, outbox.Send(msg))
+
+	delivered := mail.NewMemoryMailer()
+	drainOutbox(t, mail.NewWorker(repo, delivered, testWorkerConfig))
+
+	count := 0
+	for _, sent := range delivered.Messages() {
+		if sent.ID == msg.ID {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+
+	DeleteOutboxMessages(t, msg.ID)
+
+	mailTestManager.RegisterTest(t, "TestOutboxEnqueueIsIdempotent")
+}
+
+func TestOutboxDeadLetterAndAdminRetry(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	repo := mail.NewMongoOutboxRepository(client)
+	msg := testMessage
+	msg.ID = "outbox-test-" + generateRandomString(8)
+	assert.NoError(t, mail.NewOutbox(repo).Send(msg))
+
+	// Every attempt fails until the attempt budget is used up
+	drainOutbox(t, mail.NewWorker(repo, failingMailer{}, testWorkerConfig))
+
+	body, code := adminRequest(router, "GET", "/outbox?status="+mail.StatusDead, adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, msg.Text, "bodies must not be exposed")
+
+	var page mail.OutboxPage
+	json.Unmarshal([]byte(body), &page)
+	var dead *mail.OutboxMessage
+	for i := range page.Messages {
+		if page.Messages[i].Key == msg.ID {
+			dead = &page.Messages[i]
+		}
+	}
+	if !assert.NotNil(t, dead) {
+		return
+	}
+	assert.Equal(t, testWorkerConfig.MaxAttempts, dead.Attempts)
+	assert.Equal(t, "connection refused", dead.LastError)
+
+	// After an admin retry the message goes out once SMTP is back
+	_, code = adminRequest(router, "POST", "/outbox/"+dead.ID.Hex()+"/retry", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	delivered := mail.NewMemoryMailer()
+	drainOutbox(t, mail.NewWorker(repo, delivered, testWorkerConfig))
+	_, ok := delivered.Last(msg.To)
+	assert.True(t, ok)
+
+	body, code = adminRequest(router, "GET", "/outbox/"+dead.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"status":"sent"`)
+
+	// Delivered bodies are not kept
+	stored, err := repo.FindByID(dead.ID)
+	if assert.NoError(t, err) {
+		assert.Empty(t, stored.Text)
+		assert.Empty(t, stored.HTML)
+	}
+
+	_, code = adminRequest(router, "POST", "/outbox/"+dead.ID.Hex()+"/retry", adminToken)
+	assert.Equal(t, http.StatusConflict, code)
+
+	DeleteOutboxMessages(t, msg.ID)
+
+	mailTestManager.RegisterTest(t, "TestOutboxDeadLetterAndAdminRetry")
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	worker := mail.NewWorker(nil, nil, mail.WorkerConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+
+	assert.Equal(t, time.Second, worker.Backoff(1))
+	assert.Equal(t, 2*time.Second, worker.Backoff(2))
+	assert.Equal(t, 8*time.Second, worker.Backoff(4))
+	assert.Equal(t, 10*time.Second, worker.Backoff(10))
+
+	mailTestManager.RegisterTest(t, "TestOutboxBackoff")
+}
+
+// doubleSender hands every message to the outbox twice, like a caller that
+// is retried after its first attempt already went through.
+type doubleSender struct {
+	outbox *mail.Outbox
+}
+
+func (d doubleSender) Send(msg mail.Message) error {
+	if err := d.outbox.Send(msg); err != nil {
+		return err
+	}
+	return d.outbox.Send(msg)
+}
+
+func TestOutboxKeysFromAuthService(t *testing.T) {
+	router, pm := initializeRouterAndControllers(client)
+	repo := mail.NewMongoOutboxRepository(client)
+	assert.NoError(t, repo.EnsureIndexes())
+	service := newTestAuthServiceWithMailer(client, pm, doubleSender{mail.NewOutbox(repo)})
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+
+	assert.NoError(t, service.ResetPassword(auth.ResetPasswordRequest{Email: user["email"], Username: user["username"]}))
+
+	outbox := client.Database("mail").Collection("outbox")
+	n, err := outbox.CountDocuments(context.TODO(), bson.M{"to": user["email"]})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = outbox.DeleteMany(context.TODO(), bson.M{"to": user["email"]})
+	assert.NoError(t, err)
+
+	mailTestManager.RegisterTest(t, "TestOutboxKeysFromAuthService")
+}
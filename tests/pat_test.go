@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/kanban"
+	"omhs-backend/internal/utils"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	router := setupKanbanRouter(client)
+
+	user := setupTestData()
+	registeredUser, jwt := registerUserAndGetToken(t, router, user)
+
+	_, code := AuthRequest(router, "POST", "/tokens", jwt, map[string]interface{}{"name": "ci", "scopes": []string{"kanban:admin"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	body, code := AuthRequest(router, "POST", "/tokens", jwt, map[string]interface{}{
+		"name": "ci", "scopes": []string{utils.ScopeKanbanRead}, "expiresInDays": 30,
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	var readOnly auth.CreatedPersonalAccessToken
+	assert.NoError(t, json.Unmarshal([]byte(body), &readOnly))
+	assert.True(t, strings.HasPrefix(readOnly.Token, utils.PersonalAccessTokenPrefix))
+	assert.NotNil(t, readOnly.ExpiresAt)
+
+	// Only the hash is stored
+	n, _ := client.Database("users").Collection("personalAccessTokens").CountDocuments(context.TODO(),
+		bson.M{"tokenHash": utils.HashToken(readOnly.Token)})
+	assert.Equal(t, int64(1), n)
+
+	// Scopes decide what the token may do
+	w := apiRequest(router, "GET", kanban.BasePath, readOnly.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = apiRequest(router, "PUT", kanban.BasePath, readOnly.Token, map[string]interface{}{"title": "x"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = apiRequest(router, "GET", "/testdb/testcollection", readOnly.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// and tokens cannot manage the account or mint more tokens
+	_, code = AuthRequest(router, "GET", "/me", readOnly.Token, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = AuthRequest(router, "POST", "/tokens", readOnly.Token, map[string]interface{}{"name": "x", "scopes": []string{utils.ScopeKanbanAll}})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	body, code = AuthRequest(router, "POST", "/tokens", jwt, map[string]interface{}{"name": "sync", "scopes": []string{utils.ScopeRequestsAll}})
+	assert.Equal(t, http.StatusCreated, code)
+	var requestsToken auth.CreatedPersonalAccessToken
+	assert.NoError(t, json.Unmarshal([]byte(body), &requestsToken))
+	assert.Nil(t, requestsToken.ExpiresAt)
+
+	w = apiRequest(router, "POST", "/testdb/testcollection", requestsToken.Token, map[string]interface{}{"note": "from a script"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Listing shows last use but never the token
+	body, code = AuthRequest(router, "GET", "/tokens", jwt, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, readOnly.Token)
+	assert.NotContains(t, body, "tokenHash")
+	var listed []auth.PersonalAccessToken
+	assert.NoError(t, json.Unmarshal([]byte(body), &listed))
+	assert.Len(t, listed, 2)
+	for _, tok := range listed {
+		assert.NotNil(t, tok.LastUsedAt, tok.Name)
+	}
+
+	// Revoked and expired tokens stop working
+	_, code = AuthRequest(router, "DELETE", "/tokens/"+readOnly.ID.Hex(), jwt, nil)
+	assert.Equal(t, http.StatusOK, code)
+	w = apiRequest(router, "GET", kanban.BasePath, readOnly.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, code = AuthRequest(router, "DELETE", "/tokens/"+readOnly.ID.Hex(), jwt, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	_, err := client.Database("users").Collection("personalAccessTokens").UpdateOne(context.TODO(),
+		bson.M{"_id": requestsToken.ID}, bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Minute)}})
+	assert.NoError(t, err)
+	w = apiRequest(router, "GET", "/testdb/testcollection", requestsToken.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	cleanupOwnedData(t, registeredUser)
+	_, err = client.Database("users").Collection("personalAccessTokens").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.NoError(t, err)
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestPersonalAccessTokens")
+}
+
+func TestPersonalAccessTokenLimit(t *testing.T) {
+	router := setupKanbanRouter(client)
+
+	user := setupTestData()
+	registeredUser, jwt := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+	defer client.Database("users").Collection("personalAccessTokens").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+
+	create := func() (auth.CreatedPersonalAccessToken, int) {
+		var created auth.CreatedPersonalAccessToken
+		body, code := AuthRequest(router, "POST", "/tokens", jwt, map[string]interface{}{"name": "ci", "scopes": []string{utils.ScopeKanbanRead}})
+		json.Unmarshal([]byte(body), &created)
+		return created, code
+	}
+
+	var tokens []auth.CreatedPersonalAccessToken
+	for i := 0; i < auth.MaxPersonalAccessTokens; i++ {
+		created, code := create()
+		assert.Equal(t, http.StatusCreated, code)
+		tokens = append(tokens, created)
+	}
+	_, code := create()
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Revoked and expired tokens free their slot
+	for _, tok := range tokens[:5] {
+		_, code = AuthRequest(router, "DELETE", "/tokens/"+tok.ID.Hex(), jwt, nil)
+		assert.Equal(t, http.StatusOK, code)
+	}
+	_, err := client.Database("users").Collection("personalAccessTokens").UpdateOne(context.TODO(),
+		bson.M{"_id": tokens[5].ID}, bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Minute)}})
+	assert.NoError(t, err)
+
+	for i := 0; i < 6; i++ {
+		_, code = create()
+		assert.Equal(t, http.StatusCreated, code)
+	}
+	_, code = create()
+	assert.Equal(t, http.StatusConflict, code)
+
+	authTestManager.RegisterTest(t, "TestPersonalAccessTokenLimit")
+}
+
+func TestPersonalAccessTokenLimitUnderConcurrency(t *testing.T) {
+	router := setupKanbanRouter(client)
+
+	user := setupTestData()
+	registeredUser, jwt := registerUserAndGetToken(t, router, user)
+	defer DeleteUser(t, registeredUser.ID)
+	defer client.Database("users").Collection("personalAccessTokens").DeleteMany(context.TODO(), bson.M{"userId": registeredUser.ID})
+
+	create := func() int {
+		_, code := AuthRequest(router, "POST", "/tokens", jwt, map[string]interface{}{"name": "ci", "scopes": []string{utils.ScopeKanbanRead}})
+		return code
+	}
+	for i := 0; i < auth.MaxPersonalAccessTokens-3; i++ {
+		assert.Equal(t, http.StatusCreated, create())
+	}
+
+	// Racing requests may all lose the last slots, but never exceed the cap
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- create()
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+	assert.LessOrEqual(t, created, 3)
+
+	count, err := client.Database("users").Collection("personalAccessTokens").CountDocuments(context.TODO(), bson.M{"userId": registeredUser.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(auth.MaxPersonalAccessTokens-3+created), count)
+
+	authTestManager.RegisterTest(t, "TestPersonalAccessTokenLimitUnderConcurrency")
+}
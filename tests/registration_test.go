@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"omhs-backend/internal/admin"
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/utils"
+)
+
+// inviteCodePattern finds the code in the plain-text invite email.
+var inviteCodePattern = regexp.MustCompile(`\b([0-9a-f]{64})\b`)
+
+func getInviteCode(t *testing.T, email string) string {
+	msg, ok := testMailer.Last(email)
+	assert.True(t, ok, "no email sent to %s", email)
+
+	match := inviteCodePattern.FindStringSubmatch(msg.Text)
+	if !assert.NotNil(t, match, "no invite code in email %q", msg.Subject) {
+		return ""
+	}
+	return match[1]
+}
+
+func TestRegistrationDomains(t *testing.T) {
+	defer func(r auth.RegistrationConfig) { testRegistration = r }(testRegistration)
+	testRegistration = auth.RegistrationConfig{Mode: auth.RegistrationDomain, AllowedDomains: []string{"example.com"}}
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	user["email"] = "domain-" + strings.ToLower(generateRandomString(8)) + "@example.org"
+	_, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	user["email"] = "domain-" + strings.ToLower(generateRandomString(8)) + "@Example.com"
+	body, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusCreated, code)
+
+	var registered auth.User
+	assert.NoError(t, json.Unmarshal([]byte(body), &registered))
+	DeleteUser(t, registered.ID)
+
+	authTestManager.RegisterTest(t, "TestRegistrationDomains")
+}
+
+func TestAdminInvites(t *testing.T) {
+	defer func(r auth.RegistrationConfig) { testRegistration = r }(testRegistration)
+	testRegistration = auth.RegistrationConfig{Mode: auth.RegistrationInvite}
+	router, _ := initializeRouterAndControllers(client)
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	user := setupTestData()
+	user["email"] = "invite-" + strings.ToLower(generateRandomString(8)) + "@example.com"
+
+	// Sign-up is closed without an invite
+	_, code := RegisterUser(router, user)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	w := apiRequest(router, "POST", admin.BasePath+"/invites", adminToken, map[string]interface{}{"email": user["email"], "role": "owner"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = apiRequest(router, "POST", admin.BasePath+"/invites", adminToken, map[string]interface{}{
+		"email": user["email"], "role": utils.RoleAdmin, "expiresInDays": 7,
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "codeHash")
+	var invite auth.Invite
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &invite))
+	assert.NotNil(t, invite.ExpiresAt)
+	user["inviteCode"] = getInviteCode(t, user["email"])
+
+	body, code := adminRequest(router, "GET", "/invites", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, invite.ID.Hex())
+
+	// The code only works for the address it was sent to
+	other := setupTestData()
+	other["email"] = "other-" + user["email"]
+	other["inviteCode"] = user["inviteCode"]
+	_, code = RegisterUser(router, other)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// and gives the invited account its role and a verified email
+	body, code = RegisterUser(router, user)
+	assert.Equal(t, http.StatusCreated, code)
+	var registered auth.User
+	assert.NoError(t, json.Unmarshal([]byte(body), &registered))
+	assert.True(t, registered.EmailVerified)
+	assert.NotEmpty(t, AdminLogin(router, user["username"], user["password"]))
+
+	body, code = adminRequest(router, "GET", "/users/"+registered.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"isAdmin":true`)
+
+	// Invites are single-use
+	second := setupTestData()
+	second["email"] = user["email"]
+	second["inviteCode"] = user["inviteCode"]
+	_, code = RegisterUser(router, second)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	body, _ = adminRequest(router, "GET", "/invites", adminToken)
+	assert.NotContains(t, body, invite.ID.Hex())
+
+	// Revoked invites stop working
+	w = apiRequest(router, "POST", admin.BasePath+"/invites", adminToken, map[string]interface{}{"email": second["email"]})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var revoked auth.Invite
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &revoked))
+	assert.Nil(t, revoked.ExpiresAt)
+	second["inviteCode"] = getInviteCode(t, second["email"])
+
+	_, code = adminRequest(router, "DELETE", "/invites/"+revoked.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = RegisterUser(router, second)
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = adminRequest(router, "DELETE", "/invites/"+revoked.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	_, err := client.Database("users").Collection("invites").DeleteMany(context.TODO(), bson.M{"email": user["email"]})
+	assert.NoError(t, err)
+	DeleteUser(t, registered.ID)
+
+	adminTestManager.RegisterTest(t, "TestAdminInvites")
+}
@@ -141,3 +141,70 @@ func TestDeleteDocument(t *testing.T) {
 
 	requestsTestManager.RegisterTest(t, "TestDeleteDocument")
 }
+
+// TestRequestsScopedToOwner tests that users only see documents they created.
+func TestRequestsScopedToOwner(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	owner, ownerToken := registerUserAndGetToken(t, router, setupTestData())
+	other, otherToken := registerUserAndGetToken(t, router, setupTestData())
+
+	doc := requests.Document{
+		Data: map[string]interface{}{
+			"field1": "value1",
+		},
+	}
+
+	body, code := createDocument(router, "testdb", "testcollection", ownerToken, doc)
+	assert.Equal(t, http.StatusCreated, code)
+
+	var createdDoc requests.Document
+	json.Unmarshal([]byte(body), &createdDoc)
+	assert.Equal(t, owner.ID, createdDoc.Owner)
+
+	_, code = getDocument(router, "testdb", "testcollection", createdDoc.ID.Hex(), otherToken)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	_, code = deleteDocument(router, "testdb", "testcollection", createdDoc.ID.Hex(), otherToken)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	_, code = getDocument(router, "testdb", "testcollection", createdDoc.ID.Hex(), ownerToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Admins are not scoped to their own documents
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	_, code = getDocument(router, "testdb", "testcollection", createdDoc.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Cleanup
+	_, code = deleteDocument(router, "testdb", "testcollection", createdDoc.ID.Hex(), ownerToken)
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, owner.ID)
+	DeleteUser(t, other.ID)
+
+	requestsTestManager.RegisterTest(t, "TestRequestsScopedToOwner")
+}
+
+// TestRequestsRejectUnlistedCollection tests that collections outside the allowlist are refused.
+func TestRequestsRejectUnlistedCollection(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	registeredUser, token := registerUserAndGetToken(t, router, setupTestData())
+
+	_, code := getDocument(router, "users", "authentication", registeredUser.ID.Hex(), "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	_, code = getDocument(router, "users", "authentication", registeredUser.ID.Hex(), token)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	adminToken := AdminLogin(router, os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS"))
+
+	_, code = getDocument(router, "users", "authentication", registeredUser.ID.Hex(), adminToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	requestsTestManager.RegisterTest(t, "TestRequestsRejectUnlistedCollection")
+}
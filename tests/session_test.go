@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"omhs-backend/internal/auth"
+)
+
+const (
+	firefoxOnWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	safariOnIPhone   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+)
+
+// loginFrom logs in with the given User-Agent header.
+func loginFrom(t *testing.T, router *gin.Engine, username, password, userAgent string) auth.TokenPair {
+	payload, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	req, _ := http.NewRequest("POST", apiPrefix+auth.BasePath+"/login", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tokens auth.TokenPair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	return tokens
+}
+
+func listSessions(t *testing.T, router *gin.Engine, token string) []auth.Session {
+	body, code := AuthRequest(router, "GET", "/sessions", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	var sessions []auth.Session
+	assert.NoError(t, json.Unmarshal([]byte(body), &sessions))
+	return sessions
+}
+
+func TestSessions(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, _ := registerUserAndGetToken(t, router, user)
+	laptop := loginFrom(t, router, user["username"], user["password"], firefoxOnWindows)
+	phone := loginFrom(t, router, user["username"], user["password"], safariOnIPhone)
+
+	// One session per login, each named after its device
+	sessions := listSessions(t, router, laptop.AccessToken)
+	assert.Len(t, sessions, 3)
+	var laptopSession, phoneSession auth.Session
+	for _, s := range sessions {
+		switch s.UserAgent {
+		case firefoxOnWindows:
+			laptopSession = s
+		case safariOnIPhone:
+			phoneSession = s
+		}
+	}
+	assert.Equal(t, "Firefox on Windows", laptopSession.DeviceName)
+	assert.Equal(t, "Safari on iPhone", phoneSession.DeviceName)
+	assert.True(t, laptopSession.Current)
+	assert.False(t, phoneSession.Current)
+	assert.False(t, laptopSession.CreatedAt.IsZero())
+
+	// Refreshing keeps the session
+	body, code := RefreshTokens(router, phone.RefreshToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal([]byte(body), &phone))
+	assert.Len(t, listSessions(t, router, phone.AccessToken), 3)
+
+	// Revoking a session logs out its access and refresh tokens at once
+	_, code = AuthRequest(router, "DELETE", "/sessions/"+phoneSession.ID, laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = AuthRequest(router, "GET", "/me", phone.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = RefreshTokens(router, phone.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, code = AuthRequest(router, "DELETE", "/sessions/"+phoneSession.ID, laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Other users' sessions cannot be touched
+	other := setupTestData()
+	other["email"] = "other-" + other["email"]
+	otherUser, otherToken := registerUserAndGetToken(t, router, other)
+	_, code = AuthRequest(router, "DELETE", "/sessions/"+laptopSession.ID, otherToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Everything but the caller's session can be revoked in one go
+	body, code = AuthRequest(router, "DELETE", "/sessions", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"revoked":1`)
+	sessions = listSessions(t, router, laptop.AccessToken)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, laptopSession.ID, sessions[0].ID)
+
+	// Logging out ends the session
+	_, code = LogoutUser(router, laptop.RefreshToken)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = AuthRequest(router, "GET", "/sessions", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	for _, id := range []primitive.ObjectID{registeredUser.ID, otherUser.ID} {
+		_, err := client.Database("users").Collection("sessions").DeleteMany(context.TODO(), bson.M{"userId": id})
+		assert.NoError(t, err)
+	}
+	DeleteUser(t, registeredUser.ID)
+	DeleteUser(t, otherUser.ID)
+
+	authTestManager.RegisterTest(t, "TestSessions")
+}
@@ -3,11 +3,22 @@ package tests
 
 import (
 	"context"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/signing"
+	"omhs-backend/internal/utils"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -39,4 +50,72 @@ func init() {
 	if err := client.Ping(context.TODO(), nil); err != nil {
 		logrus.Fatalf("Failed to ping MongoDB: %v", err)
 	}
+
+	// Same startup backfill as the server, so the admin account can log in
+	if err := auth.NewMongoUserRepository(client).MarkLegacyUsersVerified(); err != nil {
+		logrus.Fatalf("Failed to backfill email verification: %v", err)
+	}
+
+	// Tokens are signed with the shared keyring, as on the server
+	keyRepo := signing.NewMongoKeyRepository(client)
+	if err := keyRepo.EnsureIndexes(); err != nil {
+		logrus.Fatalf("Failed to create signing key indexes: %v", err)
+	}
+	testKeyring = signing.NewKeyring(keyRepo, signing.DefaultConfig())
+	if err := testKeyring.Rotate(time.Now()); err != nil {
+		logrus.Fatalf("Failed to load signing keys: %v", err)
+	}
+	utils.SetTokenKeys(testKeyring)
+}
+
+// User records are not reachable through the requests API, so the helpers
+// below go to the users.authentication collection directly.
+
+func usersCollection() *mongo.Collection {
+	return client.Database("users").Collection("authentication")
+}
+
+// DeleteUser removes a test user created during a test.
+func DeleteUser(t *testing.T, userID primitive.ObjectID) {
+	_, err := usersCollection().DeleteOne(context.TODO(), bson.M{"_id": userID})
+	assert.NoError(t, err)
+}
+
+// passkeyPattern finds the passkey in the plain-text reset email.
+var passkeyPattern = regexp.MustCompile(`: ([0-9a-f]{8})\b`)
+
+// GetPasskey returns the passkey from the last reset email sent to email.
+// Only its hash is stored, so the email is the only place to read it from.
+func GetPasskey(t *testing.T, email string) string {
+	msg, ok := testMailer.Last(email)
+	assert.True(t, ok, "no email sent to %s", email)
+
+	match := passkeyPattern.FindStringSubmatch(msg.Text)
+	if !assert.NotNil(t, match, "no passkey in email %q", msg.Subject) {
+		return ""
+	}
+	return match[1]
+}
+
+// linkTokenPattern finds the token query parameter of an emailed link.
+var linkTokenPattern = regexp.MustCompile(`[?&]token=([^\s&"<]+)`)
+
+// GetLinkToken returns the token from the link in the last email sent to email.
+func GetLinkToken(t *testing.T, email string) string {
+	msg, ok := testMailer.Last(email)
+	assert.True(t, ok, "no email sent to %s", email)
+
+	match := linkTokenPattern.FindStringSubmatch(msg.Text)
+	if !assert.NotNil(t, match, "no link in email %q", msg.Subject) {
+		return ""
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+	return token
+}
+
+// DeleteOutboxMessages removes queued test emails by idempotency key.
+func DeleteOutboxMessages(t *testing.T, keys ...string) {
+	_, err := client.Database("mail").Collection("outbox").DeleteMany(context.TODO(), bson.M{"key": bson.M{"$in": keys}})
+	assert.NoError(t, err)
 }
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+
+	"omhs-backend/internal/signing"
+	"omhs-backend/internal/utils"
+)
+
+// memoryKeyRepository lets rotation be driven with arbitrary clocks without
+// leaving keys in the shared collection.
+type memoryKeyRepository struct {
+	mu   sync.Mutex
+	keys []signing.Key
+}
+
+func (r *memoryKeyRepository) Insert(key *signing.Key) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *memoryKeyRepository) ListValid(now time.Time) ([]signing.Key, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var valid []signing.Key
+	for _, key := range r.keys {
+		if key.ExpiresAt.After(now) {
+			valid = append(valid, key)
+		}
+	}
+	return valid, nil
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	token, err := utils.GenerateJWT("64b000000000000000000000", "jwks", nil, "")
+	assert.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &utils.Claims{})
+	assert.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	assert.NotEmpty(t, kid)
+	assert.Equal(t, signing.AlgRS256, parsed.Method.Alg())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age")
+
+	var set signing.JWKSet
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	var published *signing.JWK
+	for i := range set.Keys {
+		if set.Keys[i].Kid == kid {
+			published = &set.Keys[i]
+		}
+	}
+	if !assert.NotNil(t, published, "signing key %s not in JWKS", kid) {
+		return
+	}
+	assert.NotContains(t, w.Body.String(), `"d"`)
+
+	// A third party can verify the token from the JWKS alone
+	n, _ := base64.RawURLEncoding.DecodeString(published.N)
+	e, _ := base64.RawURLEncoding.DecodeString(published.E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	verified, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil })
+	assert.NoError(t, err)
+	assert.True(t, verified.Valid)
+
+	authTestManager.RegisterTest(t, "TestJWKSEndpoint")
+}
+
+func TestKeyringRotation(t *testing.T) {
+	cfg := signing.DefaultConfig()
+	cfg.Algorithm = signing.AlgEdDSA
+	keyring := signing.NewKeyring(&memoryKeyRepository{}, cfg)
+	now := time.Now()
+
+	// The first key has almost served its term; rotating publishes the next
+	// one, which takes over when the first retires
+	assert.NoError(t, keyring.Rotate(now.Add(-cfg.RotationInterval-time.Hour)))
+	assert.Len(t, keyring.JWKS().Keys, 1)
+	first := keyring.JWKS().Keys[0].Kid
+
+	assert.NoError(t, keyring.Rotate(now.Add(-2*time.Hour)))
+	assert.Len(t, keyring.JWKS().Keys, 2)
+	assert.NoError(t, keyring.Rotate(now.Add(-2*time.Hour)))
+	assert.Len(t, keyring.JWKS().Keys, 2)
+
+	kid, method, _, err := keyring.SigningKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, kid)
+	assert.Equal(t, signing.AlgEdDSA, method.Alg())
+
+	// The retired key still verifies, but only for its own algorithm
+	_, err = keyring.VerificationKey(first, signing.AlgEdDSA)
+	assert.NoError(t, err)
+	_, err = keyring.VerificationKey(first, signing.AlgRS256)
+	assert.ErrorIs(t, err, signing.ErrAlgorithmMismatch)
+	_, err = keyring.VerificationKey("unknown", signing.AlgEdDSA)
+	assert.ErrorIs(t, err, signing.ErrUnknownKey)
+
+	// and is dropped once the overlap is over
+	assert.NoError(t, keyring.Rotate(now.Add(cfg.Overlap)))
+	assert.Len(t, keyring.JWKS().Keys, 1)
+	assert.Equal(t, kid, keyring.JWKS().Keys[0].Kid)
+
+	authTestManager.RegisterTest(t, "TestKeyringRotation")
+}
+
+func TestJWTAlgorithmPinning(t *testing.T) {
+	token, err := utils.GenerateJWT("64b000000000000000000000", "pinning", nil, "")
+	assert.NoError(t, err)
+	claims, err := utils.ParseJWT(token)
+	assert.NoError(t, err)
+	assert.Equal(t, testKeyring.Issuer(), claims.Issuer)
+	assert.Contains(t, claims.Audience, testKeyring.Audience())
+	assert.NotNil(t, claims.NotBefore)
+
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, &utils.Claims{})
+	kid := parsed.Header["kid"].(string)
+	forged := *claims
+	forged.Roles = []string{"admin"}
+
+	// HS256 keyed with the published public key
+	public, err := testKeyring.VerificationKey(kid, signing.AlgRS256)
+	assert.NoError(t, err)
+	publicDER, _ := json.Marshal(public)
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, forged)
+	hs.Header["kid"] = kid
+	hsToken, _ := hs.SignedString(publicDER)
+	_, err = utils.ParseJWT(hsToken)
+	assert.Error(t, err)
+
+	// alg "none"
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, forged)
+	none.Header["kid"] = kid
+	noneToken, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = utils.ParseJWT(noneToken)
+	assert.Error(t, err)
+
+	// Tokens meant for something else are refused by audience
+	action, err := utils.GenerateActionToken("verify-email", "64b000000000000000000000", "", time.Minute)
+	assert.NoError(t, err)
+	_, err = utils.ParseJWT(action)
+	assert.Error(t, err)
+	_, err = utils.ParseActionToken("change-email", action)
+	assert.Error(t, err)
+
+	authTestManager.RegisterTest(t, "TestJWTAlgorithmPinning")
+}
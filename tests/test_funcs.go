@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"time"
 
@@ -13,13 +14,29 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"omhs-backend/internal/admin"
 	"omhs-backend/internal/auth"
+	"omhs-backend/internal/mail"
+	"omhs-backend/internal/middleware"
+	"omhs-backend/internal/password"
 	"omhs-backend/internal/requests"
+	"omhs-backend/internal/signing"
 	"omhs-backend/internal/utils"
+	"omhs-backend/internal/webauthn"
 )
 
 const apiPrefix = "/api"
 
+// testAllowlist opens the collections the requests and kanban suites use.
+const testAllowlist = "testdb.testcollection,data.Kanbans"
+
+// testMailer captures every email the services send during the tests.
+var testMailer = mail.NewMemoryMailer()
+
+// testKeyring signs every token in the tests; it is loaded in init once
+// MongoDB is connected.
+var testKeyring *signing.Keyring
+
 func generateRandomString(n int) string {
 	rand.Seed(time.Now().UnixNano())
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -41,6 +58,31 @@ func RegisterUser(router *gin.Engine, user map[string]string) (string, int) {
 	return w.Body.String(), w.Code
 }
 
+func VerifyEmail(router *gin.Engine, token string) (string, int) {
+	verifyReq, _ := http.NewRequest("GET", apiPrefix+auth.BasePath+"/verify-email?token="+url.QueryEscape(token), nil)
+
+	verifyRecorder := httptest.NewRecorder()
+	router.ServeHTTP(verifyRecorder, verifyReq)
+
+	logrus.Infof("Verify Email Response: %s", verifyRecorder.Body.String())
+	return verifyRecorder.Body.String(), verifyRecorder.Code
+}
+
+func ResendVerification(router *gin.Engine, email, username string) (string, int) {
+	resendReqJSON, _ := json.Marshal(map[string]string{
+		"email":    email,
+		"username": username,
+	})
+	resendReq, _ := http.NewRequest("POST", apiPrefix+auth.BasePath+"/resend-verification", bytes.NewBuffer(resendReqJSON))
+	resendReq.Header.Set("Content-Type", "application/json")
+
+	resendRecorder := httptest.NewRecorder()
+	router.ServeHTTP(resendRecorder, resendReq)
+
+	logrus.Infof("Resend Verification Response: %s", resendRecorder.Body.String())
+	return resendRecorder.Body.String(), resendRecorder.Code
+}
+
 func LoginUser(router *gin.Engine, username, password string) (string, int) {
 	loginReqJSON, _ := json.Marshal(map[string]string{
 		"username": username,
@@ -56,14 +98,54 @@ func LoginUser(router *gin.Engine, username, password string) (string, int) {
 	return loginRecorder.Body.String(), loginRecorder.Code
 }
 
-func DeleteUser(router *gin.Engine, userID, adminToken string) (string, int) {
-	deleteReq, _ := http.NewRequest("DELETE", apiPrefix+"/users/authentication/"+userID, nil)
-	deleteReq.Header.Set("Authorization", "Bearer "+adminToken)
-	deleteRecorder := httptest.NewRecorder()
-	router.ServeHTTP(deleteRecorder, deleteReq)
+func RefreshTokens(router *gin.Engine, refreshToken string) (string, int) {
+	refreshReqJSON, _ := json.Marshal(map[string]string{
+		"refreshToken": refreshToken,
+	})
+	refreshReq, _ := http.NewRequest("POST", apiPrefix+auth.BasePath+"/refresh", bytes.NewBuffer(refreshReqJSON))
+	refreshReq.Header.Set("Content-Type", "application/json")
+
+	refreshRecorder := httptest.NewRecorder()
+	router.ServeHTTP(refreshRecorder, refreshReq)
+
+	logrus.Infof("Refresh Response: %s", refreshRecorder.Body.String())
+	return refreshRecorder.Body.String(), refreshRecorder.Code
+}
+
+func LogoutUser(router *gin.Engine, refreshToken string) (string, int) {
+	logoutReqJSON, _ := json.Marshal(map[string]string{
+		"refreshToken": refreshToken,
+	})
+	logoutReq, _ := http.NewRequest("POST", apiPrefix+auth.BasePath+"/logout", bytes.NewBuffer(logoutReqJSON))
+	logoutReq.Header.Set("Content-Type", "application/json")
+
+	logoutRecorder := httptest.NewRecorder()
+	router.ServeHTTP(logoutRecorder, logoutReq)
+
+	logrus.Infof("Logout Response: %s", logoutRecorder.Body.String())
+	return logoutRecorder.Body.String(), logoutRecorder.Code
+}
+
+// AuthRequest calls an /api/auth endpoint as the holder of token, if any.
+func AuthRequest(router *gin.Engine, method, path, token string, payload interface{}) (string, int) {
+	var body *bytes.Buffer
+	if payload != nil {
+		payloadJSON, _ := json.Marshal(payload)
+		body = bytes.NewBuffer(payloadJSON)
+	} else {
+		body = bytes.NewBuffer(nil)
+	}
+	req, _ := http.NewRequest(method, apiPrefix+auth.BasePath+path, body)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
-	logrus.Infof("Delete Response: %s", deleteRecorder.Body.String())
-	return deleteRecorder.Body.String(), deleteRecorder.Code
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	logrus.Infof("Auth %s %s Response: %s", method, path, recorder.Body.String())
+	return recorder.Body.String(), recorder.Code
 }
 
 func ResetPassword(router *gin.Engine, email, username string) (string, int) {
@@ -98,16 +180,6 @@ func ChangePassword(router *gin.Engine, email, username, passkey, newPassword st
 	return changeRecorder.Body.String(), changeRecorder.Code
 }
 
-func GetPasskey(router *gin.Engine, database, collection, userID, adminToken string) (string, int) {
-	getReq, _ := http.NewRequest("GET", apiPrefix+"/"+database+"/"+collection+"/"+userID, nil)
-	getReq.Header.Set("Authorization", "Bearer "+adminToken)
-	getRecorder := httptest.NewRecorder()
-	router.ServeHTTP(getRecorder, getReq)
-
-	logrus.Infof("Get Document Response: %s", getRecorder.Body.String())
-	return getRecorder.Body.String(), getRecorder.Code
-}
-
 func AdminLogin(router *gin.Engine, username, password string) string {
 	body, _ := LoginUser(router, username, password)
 	var loginResponse map[string]string
@@ -124,23 +196,81 @@ func setupTestData() map[string]string {
 	}
 }
 
+// testThrottleConfig locks accounts quickly but without progressive delays,
+// and never locks the shared IP of httptest requests.
+var testThrottleConfig = auth.LoginThrottleConfig{
+	MaxUserFailures: 3,
+	MaxIPFailures:   1_000_000,
+	LockoutDuration: time.Minute,
+	FailureWindow:   time.Minute,
+}
+
+// testPasswordPolicy accepts whatever NON_ADMIN_PASS is set to;
+// TestPasswordPolicy swaps in the default policy.
+var testPasswordPolicy = password.Policy{MinLength: 1}
+
+// testRegistration keeps sign-up open; the registration tests swap in the
+// other modes.
+var testRegistration = auth.RegistrationConfig{Mode: auth.RegistrationOpen}
+
+func newTestAuthService(client *mongo.Client, pm *utils.ProjectManager, authenticators ...auth.Authenticator) *auth.AuthService {
+	return newTestAuthServiceWithMailer(client, pm, testMailer, authenticators...)
+}
+
+func newTestAuthServiceWithMailer(client *mongo.Client, pm *utils.ProjectManager, mailer mail.Mailer, authenticators ...auth.Authenticator) *auth.AuthService {
+	sessionRepo := auth.NewMongoSessionRepository(client)
+	pm.Execute(sessionRepo.EnsureIndexes, "Failed to create session indexes")
+	loginEventRepo := auth.NewMongoLoginEventRepository(client)
+	pm.Execute(loginEventRepo.EnsureIndexes, "Failed to create login history indexes")
+	resetRepo := auth.NewMongoResetTokenRepository(client)
+	pm.Execute(resetRepo.EnsureIndexes, "Failed to create reset token indexes")
+	magicLinkRepo := auth.NewMongoMagicLinkRepository(client)
+	pm.Execute(magicLinkRepo.EnsureIndexes, "Failed to create magic link indexes")
+	patRepo := auth.NewMongoPersonalAccessTokenRepository(client)
+	pm.Execute(patRepo.EnsureIndexes, "Failed to create personal access token indexes")
+	inviteRepo := auth.NewMongoInviteRepository(client)
+	pm.Execute(inviteRepo.EnsureIndexes, "Failed to create invite indexes")
+	auditRepo := auth.NewMongoAuditLogRepository(client)
+	pm.Execute(auditRepo.EnsureIndexes, "Failed to create audit log indexes")
+	attemptRepo := auth.NewMongoLoginAttemptRepository(client)
+	pm.Execute(attemptRepo.EnsureIndexes, "Failed to create login attempt indexes")
+
+	throttle := auth.NewLoginThrottle(attemptRepo, testThrottleConfig)
+	return auth.NewAuthService(auth.NewMongoUserRepository(client), auth.NewMongoTokenRepository(client), sessionRepo, loginEventRepo, resetRepo, magicLinkRepo, patRepo, inviteRepo, auditRepo, throttle, testPasswordPolicy, testRegistration, mailer, authenticators...)
+}
+
 func initializeRouterAndControllers(client *mongo.Client) (*gin.Engine, *utils.ProjectManager) {
 	router := gin.Default()
 	pm := utils.NewProjectManager()
 
 	api := router.Group("/api")
+	signing.RegisterRoutes(router, signing.NewJWKSController(testKeyring))
 
 	// --- Auth Module ---
 	authRepo := auth.NewMongoUserRepository(client)
-	authService := auth.NewAuthService(authRepo)
+	authService := newTestAuthService(client, pm)
 	authController := auth.NewAuthController(authService)
 	auth.RegisterRoutes(api, authController)
 
+	webauthnRepo := auth.NewMongoWebAuthnRepository(client)
+	pm.Execute(webauthnRepo.EnsureIndexes, "Failed to create WebAuthn indexes")
+	webauthnService := auth.NewWebAuthnService(authService, authRepo, webauthnRepo, webauthn.ConfigFromEnv())
+	auth.RegisterWebAuthnRoutes(api, auth.NewWebAuthnController(webauthnService))
+
 	// --- Requests Module ---
 	requestRepo := requests.NewMongoRequestRepository(client)
-	requestService := requests.NewRequestService(requestRepo)
+	requestService := requests.NewRequestService(requestRepo, requests.ParseAllowlist(testAllowlist))
 	requestController := requests.NewRequestController(requestService)
-	requests.RegisterRoutes(api, requestController)
+	protected := api.Group("")
+	protected.Use(middleware.JWTMiddleware(authService))
+	requests.RegisterRoutes(protected, requestController)
+
+	// --- Admin Module ---
+	outboxRepo := mail.NewMongoOutboxRepository(client)
+	pm.Execute(outboxRepo.EnsureIndexes, "Failed to create outbox indexes")
+	adminService := admin.NewAdminService(authRepo, authService, outboxRepo)
+	adminController := admin.NewAdminController(adminService)
+	admin.RegisterRoutes(protected, adminController)
 
 	return router, pm
 }
@@ -189,3 +319,13 @@ func deleteDocument(router *gin.Engine, database, collection, docID, adminToken
 	logrus.Infof("Delete Document Response: %s", w.Body.String())
 	return w.Body.String(), w.Code
 }
+
+func adminRequest(router *gin.Engine, method, path, adminToken string) (string, int) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, apiPrefix+admin.BasePath+path, nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	router.ServeHTTP(w, req)
+
+	logrus.Infof("Admin %s %s Response: %s", method, path, w.Body.String())
+	return w.Body.String(), w.Code
+}
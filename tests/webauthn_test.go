@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"omhs-backend/internal/auth"
+	"omhs-backend/internal/webauthn"
+)
+
+// softAuthenticator is a minimal platform authenticator for the tests: one
+// ES256 credential, user verification always performed, "none" attestation.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	cfg := webauthn.ConfigFromEnv()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+
+	return &softAuthenticator{rpID: cfg.RPID, origin: cfg.Origins[0], key: key, credentialID: credentialID}
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(webauthn.CollectedClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(0x05) // UP | UV
+	if attested {
+		flags |= 0x40
+	}
+
+	var buf bytes.Buffer
+	buf.Write(rpIDHash[:])
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.signCount)
+	if attested {
+		buf.Write(make([]byte, 16)) // AAGUID
+		binary.Write(&buf, binary.BigEndian, uint16(len(a.credentialID)))
+		buf.Write(a.credentialID)
+		buf.Write(a.coseKey())
+	}
+	return buf.Bytes()
+}
+
+// coseKey encodes {1: 2, 3: -7, -1: 1, -2: x, -3: y}, an EC2 P-256 ES256 key.
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.PublicKey.X.FillBytes(x)
+	a.key.PublicKey.Y.FillBytes(y)
+
+	var buf bytes.Buffer
+	buf.WriteByte(0xa5)
+	buf.Write([]byte{0x01, 0x02})
+	buf.Write([]byte{0x03, 0x26})
+	buf.Write([]byte{0x20, 0x01})
+	buf.Write([]byte{0x21, 0x58, 0x20})
+	buf.Write(x)
+	buf.Write([]byte{0x22, 0x58, 0x20})
+	buf.Write(y)
+	return buf.Bytes()
+}
+
+func (a *softAuthenticator) create(challenge, userHandle []byte) webauthn.AttestationResponse {
+	a.userHandle = userHandle
+	authData := a.authData(true)
+
+	// {"fmt": "none", "attStmt": {}, "authData": authData}
+	var obj bytes.Buffer
+	obj.WriteByte(0xa3)
+	obj.WriteString("\x63fmt\x64none")
+	obj.WriteString("\x67attStmt\xa0")
+	obj.WriteString("\x68authData")
+	obj.Write([]byte{0x59, byte(len(authData) >> 8), byte(len(authData))})
+	obj.Write(authData)
+
+	var resp webauthn.AttestationResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	resp.RawID = a.credentialID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	resp.Response.AttestationObject = obj.Bytes()
+	return resp
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) webauthn.AssertionResponse {
+	a.signCount++
+	authData := a.authData(false)
+	clientData := a.clientData("webauthn.get", challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(t, err)
+
+	var resp webauthn.AssertionResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	resp.RawID = a.credentialID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = signature
+	resp.Response.UserHandle = a.userHandle
+	return resp
+}
+
+// webauthnBegin posts to a begin endpoint and decodes the session id and challenge.
+func webauthnBegin(t *testing.T, body string) (string, []byte) {
+	var begin struct {
+		SessionID string `json:"sessionId"`
+		PublicKey struct {
+			Challenge webauthn.Bytes `json:"challenge"`
+			User      struct {
+				ID webauthn.Bytes `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(body), &begin))
+	assert.NotEmpty(t, begin.SessionID)
+	return begin.SessionID, begin.PublicKey.Challenge
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	router, _ := initializeRouterAndControllers(client)
+
+	user := setupTestData()
+	registeredUser, token := registerUserAndGetToken(t, router, user)
+	authenticator := newSoftAuthenticator(t)
+
+	// --- Registration ceremony ---
+	body, code := AuthRequest(router, "POST", "/webauthn/register/begin", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	sessionID, challenge := webauthnBegin(t, body)
+
+	attestation := authenticator.create(challenge, registeredUser.ID[:])
+	register := map[string]interface{}{"sessionId": sessionID, "name": "Test key", "credential": attestation}
+	_, code = AuthRequest(router, "POST", "/webauthn/register/finish", token, register)
+	assert.Equal(t, http.StatusCreated, code)
+
+	// The session is single-use
+	_, code = AuthRequest(router, "POST", "/webauthn/register/finish", token, register)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	body, code = AuthRequest(router, "GET", "/webauthn/credentials", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	var creds []auth.WebAuthnCredential
+	json.Unmarshal([]byte(body), &creds)
+	assert.Len(t, creds, 1)
+
+	// --- Passwordless login ---
+	body, code = AuthRequest(router, "POST", "/webauthn/login/begin", "", map[string]string{"username": user["username"]})
+	assert.Equal(t, http.StatusOK, code)
+	sessionID, challenge = webauthnBegin(t, body)
+
+	assertion := authenticator.get(t, challenge)
+	login := map[string]interface{}{"sessionId": sessionID, "credential": assertion}
+	body, code = AuthRequest(router, "POST", "/webauthn/login/finish", "", login)
+	assert.Equal(t, http.StatusOK, code)
+
+	var tokens auth.TokenPair
+	json.Unmarshal([]byte(body), &tokens)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	// Replaying the assertion against a fresh challenge fails on the signature
+	body, _ = AuthRequest(router, "POST", "/webauthn/login/begin", "", map[string]string{})
+	sessionID, _ = webauthnBegin(t, body)
+	_, code = AuthRequest(router, "POST", "/webauthn/login/finish", "", map[string]interface{}{"sessionId": sessionID, "credential": assertion})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// A cloned authenticator reporting a stale counter is rejected
+	body, _ = AuthRequest(router, "POST", "/webauthn/login/begin", "", map[string]string{})
+	sessionID, challenge = webauthnBegin(t, body)
+	authenticator.signCount = 0
+	stale := authenticator.get(t, challenge)
+	_, code = AuthRequest(router, "POST", "/webauthn/login/finish", "", map[string]interface{}{"sessionId": sessionID, "credential": stale})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	_, code = AuthRequest(router, "DELETE", "/webauthn/credentials/"+creds[0].ID.Hex(), token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	DeleteUser(t, registeredUser.ID)
+
+	authTestManager.RegisterTest(t, "TestWebAuthnRegistrationAndLogin")
+}